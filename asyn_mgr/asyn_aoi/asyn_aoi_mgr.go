@@ -23,16 +23,12 @@ func RegAoiCreateFc(anysAoiTyp define.AsynAoiTyp, fc IAsynAoi) {
 	AysnAoiPools[anysAoiTyp] = fc
 }
 
-func (this *AoiMgr) ReqLen() int {
-	return 0
-}
-
 func (this *AoiMgr) Init() {
-	go this.reqLoop()
+	this.Go(this.reqLoop)
 }
 
 func (this *AoiMgr) Close() {
-
+	this.Stop()
 }
 
 var aoiMgr = CreateAoiMgr()
@@ -68,7 +64,9 @@ func (this *AoiMgr) run() bool {
 			log.Error("astar get req chan msg error")
 			return false
 		}
-		this.handleReq(req)
+		this.Process(req, this.handleReq)
+	case <-this.Done():
+		return false
 	}
 
 	return true
@@ -0,0 +1,361 @@
+package asyn_mgr
+
+import (
+	"errors"
+	"fmt"
+	"px/shared/asyn_mgr/asyn_msg"
+	"sync"
+	"time"
+
+	"gitlab.sunborngame.com/base/log"
+)
+
+var (
+	ErrCircuitOpen = errors.New("asyn circuit open")
+)
+
+const breakerBuckets = 10 // 统计窗口分成的桶数
+
+const (
+	DefaultBreakerWindow       = 10 * time.Second
+	DefaultBreakerOpenDuration = 5 * time.Second
+)
+
+type BreakerState int32
+
+const (
+	BreakerClosed   BreakerState = iota // 正常放行
+	BreakerOpen                         // 直接失败
+	BreakerHalfOpen                     // 放行少量探测请求
+)
+
+func (this BreakerState) String() string {
+	switch this {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half_open"
+	default:
+		return fmt.Sprintf("BreakerState(%d)", int32(this))
+	}
+}
+
+// BreakerPolicy 熔断策略，错误率或慢调用率超过阈值时打开
+type BreakerPolicy struct {
+	Window         time.Duration // 统计窗口，为0时使用DefaultBreakerWindow
+	MinRequests    int           // 窗口内请求数达到后才判断
+	ErrorRate      float64       // 0~1，0表示不按错误率熔断
+	SlowThreshold  time.Duration // 从发送到回调超过该时间视为慢调用，0表示不统计
+	SlowRate       float64       // 0~1，0表示不按慢调用率熔断
+	OpenDuration   time.Duration // 打开后等待该时间进入半开，为0时使用DefaultBreakerOpenDuration
+	HalfOpenProbes int           // 半开时放行的探测请求数，全部成功后关闭，任一失败重新打开，为0时为1
+	// 非nil时按endpoint分别熔断，如db实例、redis节点
+	Endpoint func(req asyn_msg.ReqInf) string
+	// 判断响应是否失败，nil时超时和RespError视为失败，业务错误不计入
+	Failure func(resp asyn_msg.RespInf) bool
+}
+
+func (this *BreakerPolicy) failure(resp asyn_msg.RespInf) bool {
+	if this.Failure != nil {
+		return this.Failure(resp)
+	}
+	_, ok := resp.(error)
+	return ok
+}
+
+// BreakerEvent 熔断状态变化，在主逻辑中通知
+type BreakerEvent struct {
+	ModuleId asyn_msg.AsynModuleId
+	Endpoint string
+	From     BreakerState
+	To       BreakerState
+	Time     time.Time
+}
+
+// 投递到主逻辑的状态变化
+type respBreakerEvent struct {
+	asyn_msg.RespBase
+	BreakerEvent
+}
+
+func (this *respBreakerEvent) GetModuleId() asyn_msg.AsynModuleId {
+	return this.ModuleId
+}
+
+type breakerKey struct {
+	moduleId asyn_msg.AsynModuleId
+	endpoint string
+}
+
+type breakerBucket struct {
+	start  int64 // 桶的开始时间(UnixNano)
+	total  int
+	failed int
+	slow   int
+}
+
+type circuitBreaker struct {
+	key    breakerKey
+	policy *BreakerPolicy
+
+	lock     sync.Mutex
+	state    BreakerState
+	openedAt time.Time
+	probing  int // 半开时在途的探测请求
+	probeOk  int
+	buckets  [breakerBuckets]breakerBucket
+}
+
+type breakers struct {
+	once      sync.Once
+	lock      sync.RWMutex
+	policies  map[asyn_msg.AsynModuleId]*BreakerPolicy
+	breakers  map[breakerKey]*circuitBreaker
+	listeners []func(BreakerEvent)
+}
+
+func newBreakers() *breakers {
+	return &breakers{
+		policies: make(map[asyn_msg.AsynModuleId]*BreakerPolicy),
+		breakers: make(map[breakerKey]*circuitBreaker),
+	}
+}
+
+// 未设置的字段使用默认值，复制一份不修改调用方的策略
+func (this *BreakerPolicy) withDefaults() *BreakerPolicy {
+	var policy = *this
+	if policy.Window <= 0 {
+		policy.Window = DefaultBreakerWindow
+	}
+	if policy.OpenDuration <= 0 {
+		policy.OpenDuration = DefaultBreakerOpenDuration
+	}
+	if policy.HalfOpenProbes <= 0 {
+		policy.HalfOpenProbes = 1
+	}
+	return &policy
+}
+
+// SetBreakerPolicy 启动时设置模块的熔断策略，policy为nil时取消
+func (this *AsynMgr) SetBreakerPolicy(moduleId asyn_msg.AsynModuleId, policy *BreakerPolicy) {
+	this.breakers.once.Do(func() {
+		this.AddSendInterceptor(AllModules, this.breakerIntercept)
+	})
+	this.breakers.lock.Lock()
+	defer this.breakers.lock.Unlock()
+	for key := range this.breakers.breakers {
+		if key.moduleId == moduleId {
+			delete(this.breakers.breakers, key)
+		}
+	}
+	if policy == nil {
+		delete(this.breakers.policies, moduleId)
+		return
+	}
+	this.breakers.policies[moduleId] = policy.withDefaults()
+}
+
+// OnBreakerEvent 订阅熔断状态变化，在主逻辑HandleResp中执行
+func (this *AsynMgr) OnBreakerEvent(fc func(event BreakerEvent)) {
+	this.breakers.lock.Lock()
+	defer this.breakers.lock.Unlock()
+	this.breakers.listeners = append(this.breakers.listeners, fc)
+}
+
+// BreakerState 没有设置策略或还没有请求时为BreakerClosed
+func (this *AsynMgr) BreakerState(moduleId asyn_msg.AsynModuleId, endpoint string) BreakerState {
+	this.breakers.lock.RLock()
+	breaker, ok := this.breakers.breakers[breakerKey{moduleId, endpoint}]
+	this.breakers.lock.RUnlock()
+	if !ok {
+		return BreakerClosed
+	}
+	breaker.lock.Lock()
+	defer breaker.lock.Unlock()
+	return breaker.state
+}
+
+func (this *breakers) get(req asyn_msg.ReqInf) *circuitBreaker {
+	this.lock.RLock()
+	policy, ok := this.policies[req.GetModuleId()]
+	if !ok {
+		this.lock.RUnlock()
+		return nil
+	}
+	var key = breakerKey{moduleId: req.GetModuleId()}
+	if policy.Endpoint != nil {
+		key.endpoint = policy.Endpoint(req)
+	}
+	breaker, ok := this.breakers[key]
+	this.lock.RUnlock()
+	if ok {
+		return breaker
+	}
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if breaker, ok = this.breakers[key]; !ok {
+		breaker = &circuitBreaker{key: key, policy: policy}
+		this.breakers[key] = breaker
+	}
+	return breaker
+}
+
+func (this *breakers) notify(event BreakerEvent) {
+	this.lock.RLock()
+	var listeners = this.listeners
+	this.lock.RUnlock()
+	for _, fc := range listeners {
+		fc(event)
+	}
+}
+
+func (this *AsynMgr) breakerIntercept(owner asyn_msg.RespOwner, req asyn_msg.ReqInf, cb asyn_msg.AsynCallback, next SendHandler) {
+	var breaker = this.breakers.get(req)
+	if breaker == nil {
+		next(owner, req, cb)
+		return
+	}
+
+	// 没有回调的请求无法统计结果，只在关闭时放行
+	allowed, probe, events := breaker.allow(time.Now(), cb != nil)
+	this.publishBreakerEvents(events)
+	if !allowed {
+		// 打开期间请求很多，不逐个记录日志
+		if cb != nil {
+			this.putDetached(owner, &respDetached{
+				RespError: asyn_msg.NewRespError(req.GetModuleId(), req, fmt.Errorf("%w, endpoint=%s", ErrCircuitOpen, breaker.key.endpoint)),
+				cb:        cb,
+			})
+		}
+		return
+	}
+	if cb == nil {
+		next(owner, req, cb)
+		return
+	}
+
+	var start = time.Now()
+	next(owner, req, func(resp asyn_msg.RespInf) asyn_msg.AsynCBPtr {
+		var cost = time.Since(start)
+		var slow = breaker.policy.SlowThreshold > 0 && cost >= breaker.policy.SlowThreshold
+		this.publishBreakerEvents(breaker.record(time.Now(), probe, breaker.policy.failure(resp), slow))
+
+		x := cb(resp)
+		if x == 0 {
+			x = asyn_msg.CBPtr(cb)
+		}
+		return x
+	})
+}
+
+func (this *AsynMgr) publishBreakerEvents(events []BreakerEvent) {
+	for _, event := range events {
+		log.Warning("asyn circuit breaker %v -> %v, moduleId=%d, endpoint=%s", event.From, event.To, event.ModuleId, event.Endpoint)
+		this.respChan.Put(&respBreakerEvent{BreakerEvent: event})
+	}
+}
+
+// 需持有lock
+func (this *circuitBreaker) setState(now time.Time, state BreakerState) BreakerEvent {
+	var event = BreakerEvent{
+		ModuleId: this.key.moduleId,
+		Endpoint: this.key.endpoint,
+		From:     this.state,
+		To:       state,
+		Time:     now,
+	}
+	this.state = state
+	this.probing, this.probeOk = 0, 0
+	switch state {
+	case BreakerOpen:
+		this.openedAt = now
+	case BreakerClosed:
+		this.buckets = [breakerBuckets]breakerBucket{}
+	}
+	return event
+}
+
+// allow 返回是否放行、是否为半开时的探测请求
+func (this *circuitBreaker) allow(now time.Time, canProbe bool) (bool, bool, []BreakerEvent) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	var events []BreakerEvent
+	if this.state == BreakerOpen && now.Sub(this.openedAt) >= this.policy.OpenDuration {
+		events = append(events, this.setState(now, BreakerHalfOpen))
+	}
+	switch this.state {
+	case BreakerClosed:
+		return true, false, events
+	case BreakerHalfOpen:
+		if canProbe && this.probing+this.probeOk < this.policy.HalfOpenProbes {
+			this.probing++
+			return true, true, events
+		}
+	}
+	return false, false, events
+}
+
+func (this *circuitBreaker) record(now time.Time, probe bool, failed bool, slow bool) []BreakerEvent {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if probe {
+		if this.state != BreakerHalfOpen {
+			return nil
+		}
+		this.probing--
+		if failed || slow {
+			return []BreakerEvent{this.setState(now, BreakerOpen)}
+		}
+		this.probeOk++
+		if this.probeOk >= this.policy.HalfOpenProbes {
+			return []BreakerEvent{this.setState(now, BreakerClosed)}
+		}
+		return nil
+	}
+	// 打开前发出的请求不影响之后的状态
+	if this.state != BreakerClosed {
+		return nil
+	}
+
+	var bucket = this.bucket(now)
+	bucket.total++
+	if failed {
+		bucket.failed++
+	}
+	if slow {
+		bucket.slow++
+	}
+
+	var total, failedN, slowN int
+	var since = now.Add(-this.policy.Window).UnixNano()
+	for i := range this.buckets {
+		if b := &this.buckets[i]; b.start > since {
+			total, failedN, slowN = total+b.total, failedN+b.failed, slowN+b.slow
+		}
+	}
+	if total < this.policy.MinRequests || total == 0 {
+		return nil
+	}
+	if (this.policy.ErrorRate > 0 && float64(failedN)/float64(total) >= this.policy.ErrorRate) ||
+		(this.policy.SlowThreshold > 0 && this.policy.SlowRate > 0 && float64(slowN)/float64(total) >= this.policy.SlowRate) {
+		return []BreakerEvent{this.setState(now, BreakerOpen)}
+	}
+	return nil
+}
+
+// 需持有lock，当前时间所在的桶，过期的桶重置
+func (this *circuitBreaker) bucket(now time.Time) *breakerBucket {
+	var width = int64(this.policy.Window) / breakerBuckets
+	if width <= 0 {
+		width = 1
+	}
+	var start = now.UnixNano() / width * width
+	var bucket = &this.buckets[(start/width)%breakerBuckets]
+	if bucket.start != start {
+		*bucket = breakerBucket{start: start}
+	}
+	return bucket
+}
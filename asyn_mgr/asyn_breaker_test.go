@@ -0,0 +1,113 @@
+package asyn_mgr
+
+import (
+	"errors"
+	"px/shared/asyn_mgr/asyn_msg"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	var calls int32
+	var module = newTestModule(asyn_msg.RedisModuleId, func(req asyn_msg.ReqInf) asyn_msg.RespInf {
+		atomic.AddInt32(&calls, 1)
+		var key = req.(*testReq).Key
+		if strings.HasSuffix(key, "fail") {
+			return asyn_msg.NewRespError(asyn_msg.RedisModuleId, req, asyn_msg.ErrStuck)
+		}
+		return &testResp{Key: key}
+	})
+	var mgr = newTestMgr(t, module)
+	mgr.SetBreakerPolicy(asyn_msg.RedisModuleId, &BreakerPolicy{
+		Window:         time.Second,
+		MinRequests:    4,
+		ErrorRate:      0.5,
+		OpenDuration:   50 * time.Millisecond,
+		HalfOpenProbes: 1,
+		Endpoint: func(req asyn_msg.ReqInf) string {
+			return strings.Split(req.(*testReq).Key, "/")[0]
+		},
+	})
+	var events []string
+	mgr.OnBreakerEvent(func(event BreakerEvent) {
+		events = append(events, event.Endpoint+":"+event.From.String()+"->"+event.To.String())
+	})
+	var call = func(key string) error {
+		t.Helper()
+		return asyn_msg.RespErr(testCall(t, mgr, key))
+	}
+
+	for _, key := range []string{"a/ok", "a/fail", "a/fail", "a/fail"} {
+		call(key)
+	}
+	if s := mgr.BreakerState(asyn_msg.RedisModuleId, "a"); s != BreakerOpen {
+		t.Fatalf("state=%v, want open", s)
+	}
+	// 打开时直接失败，不进入模块，其他endpoint不受影响
+	var sent = atomic.LoadInt32(&calls)
+	if err := call("a/ok"); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("err=%v, want ErrCircuitOpen", err)
+	}
+	if n := atomic.LoadInt32(&calls); n != sent {
+		t.Errorf("module calls=%d, want %d", n, sent)
+	}
+	if err := call("b/ok"); err != nil {
+		t.Errorf("endpoint b err=%v", err)
+	}
+
+	// 半开时探测失败重新打开，成功后关闭
+	time.Sleep(60 * time.Millisecond)
+	if err := call("a/fail"); !errors.Is(err, asyn_msg.ErrStuck) {
+		t.Errorf("probe err=%v, want ErrStuck", err)
+	}
+	if s := mgr.BreakerState(asyn_msg.RedisModuleId, "a"); s != BreakerOpen {
+		t.Errorf("state=%v after failed probe, want open", s)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if err := call("a/ok"); err != nil {
+		t.Errorf("probe err=%v", err)
+	}
+	for handleOne(mgr, 20*time.Millisecond) {
+	}
+
+	var want = []string{"a:closed->open", "a:open->half_open", "a:half_open->open", "a:open->half_open", "a:half_open->closed"}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("events=%v, want %v", events, want)
+	}
+}
+
+// 零值字段使用默认值，打开后能进入半开并由探测关闭
+func TestCircuitBreakerDefaults(t *testing.T) {
+	var mgr = newTestMgr(t, newTestModule(asyn_msg.RedisModuleId, func(req asyn_msg.ReqInf) asyn_msg.RespInf {
+		return &testResp{}
+	}))
+	var policy = &BreakerPolicy{ErrorRate: 0.5}
+	mgr.SetBreakerPolicy(asyn_msg.RedisModuleId, policy)
+	if policy.Window != 0 || policy.HalfOpenProbes != 0 {
+		t.Errorf("caller policy modified: %+v", policy)
+	}
+
+	var breaker = mgr.breakers.get(&testReq{})
+	var now = time.Now()
+	breaker.record(now, false, true, false)
+	if s := breaker.state; s != BreakerOpen {
+		t.Fatalf("state=%v, want open", s)
+	}
+	if allowed, _, _ := breaker.allow(now.Add(DefaultBreakerOpenDuration/2), true); allowed {
+		t.Errorf("allowed before OpenDuration")
+	}
+	allowed, probe, _ := breaker.allow(now.Add(DefaultBreakerOpenDuration), true)
+	if !allowed || !probe {
+		t.Fatalf("allowed=%v, probe=%v after OpenDuration, want probe", allowed, probe)
+	}
+	if allowed, _, _ = breaker.allow(now.Add(DefaultBreakerOpenDuration), true); allowed {
+		t.Errorf("second probe allowed, want 1")
+	}
+	breaker.record(now.Add(DefaultBreakerOpenDuration), true, false, false)
+	if s := breaker.state; s != BreakerClosed {
+		t.Errorf("state=%v after probe, want closed", s)
+	}
+}
@@ -0,0 +1,137 @@
+package asyn_mgr
+
+import (
+	"px/shared/asyn_mgr/asyn_msg"
+	"reflect"
+	"sync"
+	"sync/atomic"
+)
+
+// Coalescible 可合并的读请求，返回""表示该请求不合并
+// 模块、请求类型和CoalesceKey都相同的请求在途时共用一次调用，响应浅拷贝给所有回调
+type Coalescible interface {
+	CoalesceKey() string
+}
+
+type coalesceKey struct {
+	moduleId asyn_msg.AsynModuleId
+	reqType  reflect.Type
+	key      string
+}
+
+type coalesceWaiter struct {
+	owner asyn_msg.RespOwner
+	cb    asyn_msg.AsynCallback
+}
+
+// 在途的请求，第一个请求发出，之后相同的请求只登记回调
+type coalesceCall struct {
+	waiters []coalesceWaiter
+}
+
+type coalescer struct {
+	once     sync.Once
+	lock     sync.Mutex
+	reqTypes map[reflect.Type]struct{}
+	calls    map[coalesceKey]*coalesceCall
+	merged   uint64 // 被合并的请求数
+}
+
+func newCoalescer() *coalescer {
+	return &coalescer{
+		reqTypes: make(map[reflect.Type]struct{}),
+		calls:    make(map[coalesceKey]*coalesceCall),
+	}
+}
+
+// respCoalesced 合并请求的响应，随回调投递给等待者的owner
+type respCoalesced struct {
+	asyn_msg.RespInf
+	cb asyn_msg.AsynCallback
+}
+
+func (this *respCoalesced) handle() asyn_msg.AsynCBPtr {
+	x := this.cb(this.RespInf)
+	if x == 0 {
+		x = asyn_msg.CBPtr(this.cb)
+	}
+	return x
+}
+
+// EnableCoalesce 启动时开启某类请求的合并，req需实现Coalescible，只用于获取类型
+// 只用于读请求，等待者收到的RespError、RespTimeout中的Req是实际发出的请求
+func (this *AsynMgr) EnableCoalesce(req asyn_msg.ReqInf) bool {
+	if _, ok := req.(Coalescible); !ok {
+		return false
+	}
+	this.coalescer.once.Do(func() {
+		this.AddSendInterceptor(AllModules, this.coalesceIntercept)
+	})
+	this.coalescer.lock.Lock()
+	defer this.coalescer.lock.Unlock()
+	this.coalescer.reqTypes[reflect.TypeOf(req)] = struct{}{}
+	return true
+}
+
+// CoalescedCount 被合并、没有实际发出的请求数
+func (this *AsynMgr) CoalescedCount() uint64 {
+	return atomic.LoadUint64(&this.coalescer.merged)
+}
+
+// 返回false表示请求不合并
+func (this *coalescer) key(req asyn_msg.ReqInf) (coalesceKey, bool) {
+	var tp = reflect.TypeOf(req)
+	this.lock.Lock()
+	_, ok := this.reqTypes[tp]
+	this.lock.Unlock()
+	if !ok {
+		return coalesceKey{}, false
+	}
+	var key = req.(Coalescible).CoalesceKey()
+	if key == "" {
+		return coalesceKey{}, false
+	}
+	return coalesceKey{moduleId: req.GetModuleId(), reqType: tp, key: key}, true
+}
+
+func (this *AsynMgr) coalesceIntercept(owner asyn_msg.RespOwner, req asyn_msg.ReqInf, cb asyn_msg.AsynCallback, next SendHandler) {
+	if cb == nil {
+		next(owner, req, cb)
+		return
+	}
+	key, ok := this.coalescer.key(req)
+	if !ok {
+		next(owner, req, cb)
+		return
+	}
+
+	this.coalescer.lock.Lock()
+	if call, ok := this.coalescer.calls[key]; ok {
+		call.waiters = append(call.waiters, coalesceWaiter{owner: owner, cb: cb})
+		this.coalescer.lock.Unlock()
+		atomic.AddUint64(&this.coalescer.merged, 1)
+		return
+	}
+	var call = &coalesceCall{}
+	this.coalescer.calls[key] = call
+	this.coalescer.lock.Unlock()
+
+	next(owner, req, func(resp asyn_msg.RespInf) asyn_msg.AsynCBPtr {
+		this.coalescer.lock.Lock()
+		delete(this.coalescer.calls, key)
+		var waiters = call.waiters
+		this.coalescer.lock.Unlock()
+
+		for _, waiter := range waiters {
+			this.putDetached(waiter.owner, &respCoalesced{
+				RespInf: asyn_msg.ShallowCopy(resp),
+				cb:      waiter.cb,
+			})
+		}
+		x := cb(resp)
+		if x == 0 {
+			x = asyn_msg.CBPtr(cb)
+		}
+		return x
+	})
+}
@@ -0,0 +1,98 @@
+package asyn_mgr
+
+import (
+	"px/shared/asyn_mgr/asyn_msg"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type testGetReq struct {
+	asyn_msg.ReqBase
+	Key string
+}
+
+func (this *testGetReq) GetModuleId() asyn_msg.AsynModuleId {
+	return asyn_msg.RedisModuleId
+}
+
+// Key为空时不合并
+func (this *testGetReq) CoalesceKey() string {
+	return this.Key
+}
+
+func TestCoalesce(t *testing.T) {
+	var release = make(chan struct{})
+	var calls int32
+	var module = newTestModule(asyn_msg.RedisModuleId, func(req asyn_msg.ReqInf) asyn_msg.RespInf {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return &testResp{Key: req.(*testGetReq).Key}
+	})
+	var mgr = newTestMgr(t, module)
+	if mgr.EnableCoalesce(&testReq{}) {
+		t.Errorf("enable coalesce without CoalesceKey")
+	}
+	if !mgr.EnableCoalesce(&testGetReq{}) {
+		t.Fatalf("enable coalesce failed")
+	}
+
+	var resps []asyn_msg.RespInf
+	var cb = func(resp asyn_msg.RespInf) asyn_msg.AsynCBPtr {
+		resps = append(resps, resp)
+		return 0
+	}
+	// 其他goroutine的请求也合并，响应投递到各自的owner
+	var loop = NewAsynLoop()
+	var loopResps []asyn_msg.RespInf
+	mgr.SendReq(&testGetReq{Key: "k"}, cb)
+	mgr.SendReq(&testGetReq{Key: "k"}, cb)
+	mgr.SendReqFrom(loop, &testGetReq{Key: "k"}, func(resp asyn_msg.RespInf) asyn_msg.AsynCBPtr {
+		loopResps = append(loopResps, resp)
+		return 0
+	})
+	mgr.SendReq(&testGetReq{Key: "other"}, cb)
+	mgr.SendReq(&testGetReq{}, cb)
+	close(release)
+
+	for len(resps) < 4 {
+		if !handleOne(mgr, time.Second) {
+			t.Fatalf("resps=%d, want 4", len(resps))
+		}
+	}
+	select {
+	case resp := <-loop.Resp():
+		mgr.HandleResp(resp)
+	case <-time.After(time.Second):
+		t.Fatal("wait loop resp timeout")
+	}
+
+	if n := atomic.LoadInt32(&calls); n != 3 {
+		t.Errorf("module calls=%d, want 3", n)
+	}
+	if n := mgr.CoalescedCount(); n != 2 {
+		t.Errorf("coalesced=%d, want 2", n)
+	}
+	var keys = make(map[string]int)
+	var seen = make(map[asyn_msg.RespInf]bool)
+	for _, resp := range append(resps, loopResps...) {
+		r, ok := resp.(*testResp)
+		if !ok || seen[resp] {
+			t.Fatalf("resp=%+v, want a distinct *testResp per callback", resp)
+		}
+		seen[resp] = true
+		keys[r.Key]++
+	}
+	if keys["k"] != 3 || keys["other"] != 1 || keys[""] != 1 {
+		t.Errorf("keys=%v", keys)
+	}
+
+	// 完成后相同的请求重新发出
+	mgr.SendReq(&testGetReq{Key: "k"}, cb)
+	if !handleOne(mgr, time.Second) {
+		t.Fatal("wait resp timeout")
+	}
+	if n := atomic.LoadInt32(&calls); n != 4 {
+		t.Errorf("module calls=%d, want 4", n)
+	}
+}
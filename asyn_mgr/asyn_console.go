@@ -0,0 +1,394 @@
+package asyn_mgr
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"px/shared/asyn_mgr/asyn_msg"
+	"px/shared/time_wheel"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"gitlab.sunborngame.com/base/log"
+)
+
+var (
+	ErrConsoleNotLocal = errors.New("asyn console must listen on loopback")
+	ErrConsoleFailed   = errors.New("asyn callback failed by console")
+)
+
+const (
+	ConsoleDefaultLimit = 50   // 列表默认返回条数
+	ConsoleReqMaxLen    = 256  // 请求内容最多显示的字符数
+	ConsoleMaxLimit     = 1000 // 列表最多返回条数
+)
+
+// Console 调试控制台，查看模块、等待回调的请求、请求队列和时间轮，只提供让回调失败和查看队列两个操作
+//
+//	GET  /              HTML页面
+//	GET  /modules       模块列表
+//	GET  /callbacks     等待最久的回调，?module=&limit=
+//	GET  /queue         模块请求队列，?module=&limit=
+//	GET  /timers        时间轮中的定时器，?wheel=&limit=
+//	POST /fail          以ErrConsoleFailed回调请求，?module=&fcid=&token=
+//
+// 监听回环地址也能被本机浏览器中的网页跨站提交，POST需要带上Token()，首页表单已带上
+type Console struct {
+	mgr   *AsynMgr
+	mux   *http.ServeMux
+	token string
+
+	lock   sync.RWMutex
+	wheels map[string]*time_wheel.TimeWheelS
+}
+
+type ConsoleModule struct {
+	ModuleId   asyn_msg.AsynModuleId      `json:"module_id"`
+	Name       string                     `json:"name"`
+	Type       string                     `json:"type"`
+	Health     string                     `json:"health"`
+	QueueDepth int                        `json:"queue_depth"`
+	QueueCap   int                        `json:"queue_cap"`
+	LaneDepth  [asyn_msg.ReqLaneCount]int `json:"lane_depth"`
+	Pending    int                        `json:"pending"`
+	Working    int                        `json:"working"`
+	Loop       asyn_msg.LoopStat          `json:"loop"`
+}
+
+type ConsoleReq struct {
+	ModuleId   asyn_msg.AsynModuleId `json:"module_id"`
+	FcId       uint64                `json:"fc_id"`
+	ReqType    string                `json:"req_type"`
+	Priority   string                `json:"priority"`
+	WaitedMs   int64                 `json:"waited_ms"`
+	Processing bool                  `json:"processing"`
+	Req        string                `json:"req"` // 请求实现fmt.Stringer时的内容
+}
+
+type ConsoleTimeWheel struct {
+	Name    string                 `json:"name"`
+	Pending int                    `json:"pending"`
+	Timers  []time_wheel.TimerInfo `json:"timers"`
+}
+
+// NewConsole 创建后用ListenAndServe启动，或将Console挂到已有的本地http服务上
+func (this *AsynMgr) NewConsole() *Console {
+	var buf = make([]byte, 16)
+	_, _ = rand.Read(buf)
+	var console = &Console{
+		mgr:    this,
+		mux:    http.NewServeMux(),
+		token:  hex.EncodeToString(buf),
+		wheels: make(map[string]*time_wheel.TimeWheelS),
+	}
+	console.mux.HandleFunc("/", console.handleIndex)
+	console.mux.HandleFunc("/modules", console.handleModules)
+	console.mux.HandleFunc("/callbacks", console.handleCallBacks)
+	console.mux.HandleFunc("/queue", console.handleQueue)
+	console.mux.HandleFunc("/timers", console.handleTimers)
+	console.mux.HandleFunc("/fail", console.handleFail)
+	return console
+}
+
+// Token 调用POST接口时需要的token，每次NewConsole随机生成
+func (this *Console) Token() string {
+	return this.token
+}
+
+// AddTimeWheel 注册要查看的时间轮
+func (this *Console) AddTimeWheel(name string, tw *time_wheel.TimeWheelS) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.wheels[name] = tw
+}
+
+// ListenAndServe 只允许监听本地回环地址，阻塞直到出错
+func (this *Console) ListenAndServe(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return fmt.Errorf("%w, addr=%s", ErrConsoleNotLocal, addr)
+	}
+	log.Info("asyn console serve on http://%s/, token=%s", addr, this.token)
+	return http.ListenAndServe(addr, this)
+}
+
+func (this *Console) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	this.mux.ServeHTTP(w, r)
+}
+
+// Modules 按模块ID排序
+func (this *Console) Modules() []ConsoleModule {
+	var modules = this.mgr.allModules()
+	var infos = make([]ConsoleModule, 0, len(modules))
+	for moduleId, module := range modules {
+		var base = module.GetAsynBase()
+		infos = append(infos, ConsoleModule{
+			ModuleId:   moduleId,
+			Name:       asyn_msg.ModuleName(moduleId),
+			Type:       fmt.Sprintf("%T", module),
+			Health:     this.mgr.moduleHealth(module, time.Now()).String(),
+			QueueDepth: module.ReqLen(),
+			QueueCap:   base.ReqQueueCap(),
+			LaneDepth:  this.mgr.QueueLaneDepth(moduleId),
+			Pending:    module.CallBackLen(),
+			Working:    len(base.Working()),
+			Loop:       base.GetLoopStat(),
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ModuleId < infos[j].ModuleId })
+	return infos
+}
+
+// CallBacks 等待回调最久的请求，moduleId为0时包括所有模块
+func (this *Console) CallBacks(moduleId asyn_msg.AsynModuleId, limit int) []ConsoleReq {
+	var now = time.Now()
+	var reqs []ConsoleReq
+	for id, module := range this.mgr.allModules() {
+		if moduleId != 0 && id != moduleId {
+			continue
+		}
+		var base = module.GetAsynBase()
+		var processing = make(map[uint64]bool)
+		for _, w := range base.Working() {
+			processing[w.Req.GetFcId()] = true
+		}
+		for _, fc := range base.PendingCallBacks(limit) {
+			var req = newConsoleReq(id, fc.Req, now)
+			req.Processing = processing[req.FcId]
+			reqs = append(reqs, req)
+		}
+	}
+	sort.Slice(reqs, func(i, j int) bool { return reqs[i].WaitedMs > reqs[j].WaitedMs })
+	if len(reqs) > limit {
+		reqs = reqs[:limit]
+	}
+	return reqs
+}
+
+// Queue 模块请求队列中的请求，按出队顺序
+func (this *Console) Queue(moduleId asyn_msg.AsynModuleId, limit int) ([]ConsoleReq, error) {
+	module, ok := this.mgr.getModule(moduleId)
+	if !ok {
+		return nil, fmt.Errorf("%w, moduleId=%d", ErrModuleNotFound, moduleId)
+	}
+	var now = time.Now()
+	var queued = module.GetAsynBase().QueuedReqs(limit)
+	var reqs = make([]ConsoleReq, 0, len(queued))
+	for _, req := range queued {
+		reqs = append(reqs, newConsoleReq(moduleId, req, now))
+	}
+	return reqs, nil
+}
+
+// Timers name为空时包括所有时间轮
+func (this *Console) Timers(name string, limit int) []ConsoleTimeWheel {
+	this.lock.RLock()
+	var wheels = make([]ConsoleTimeWheel, 0, len(this.wheels))
+	for wheelName, tw := range this.wheels {
+		if name != "" && wheelName != name {
+			continue
+		}
+		wheels = append(wheels, ConsoleTimeWheel{
+			Name:    wheelName,
+			Pending: tw.PendingLen(),
+			Timers:  tw.PendingTimers(limit),
+		})
+	}
+	this.lock.RUnlock()
+	sort.Slice(wheels, func(i, j int) bool { return wheels[i].Name < wheels[j].Name })
+	return wheels
+}
+
+// FailCallBack 以ErrConsoleFailed回调卡住的请求，回调在主逻辑中执行
+func (this *Console) FailCallBack(moduleId asyn_msg.AsynModuleId, fcId uint64) error {
+	module, ok := this.mgr.getModule(moduleId)
+	if !ok {
+		return fmt.Errorf("%w, moduleId=%d", ErrModuleNotFound, moduleId)
+	}
+	if !module.GetAsynBase().FailCallBack(fcId, ErrConsoleFailed) {
+		return fmt.Errorf("callback not found, moduleId=%d, fcId=%d", moduleId, fcId)
+	}
+	log.Warning("asyn console fail callback, moduleId=%d, fcId=%d", moduleId, fcId)
+	return nil
+}
+
+func newConsoleReq(moduleId asyn_msg.AsynModuleId, req asyn_msg.ReqInf, now time.Time) ConsoleReq {
+	// 请求可能正在被模块处理，不用反射读取字段
+	var content string
+	if stringer, ok := req.(fmt.Stringer); ok {
+		content = stringer.String()
+	}
+	if len(content) > ConsoleReqMaxLen {
+		content = content[:ConsoleReqMaxLen] + "..."
+	}
+	return ConsoleReq{
+		ModuleId: moduleId,
+		FcId:     req.GetFcId(),
+		ReqType:  asyn_msg.TypeName(req),
+		Priority: req.GetPriority().String(),
+		WaitedMs: now.Sub(time.Unix(0, req.GetSendTime())).Milliseconds(),
+		Req:      content,
+	}
+}
+
+func (this *Console) handleModules(w http.ResponseWriter, r *http.Request) {
+	writeConsoleJSON(w, this.Modules())
+}
+
+func (this *Console) handleCallBacks(w http.ResponseWriter, r *http.Request) {
+	moduleId, err := consoleModuleId(r, false)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeConsoleJSON(w, this.CallBacks(moduleId, consoleLimit(r)))
+}
+
+func (this *Console) handleQueue(w http.ResponseWriter, r *http.Request) {
+	moduleId, err := consoleModuleId(r, true)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	reqs, err := this.Queue(moduleId, consoleLimit(r))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	writeConsoleJSON(w, reqs)
+}
+
+func (this *Console) handleTimers(w http.ResponseWriter, r *http.Request) {
+	writeConsoleJSON(w, this.Timers(r.URL.Query().Get("wheel"), consoleLimit(r)))
+}
+
+func (this *Console) handleFail(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !this.checkPost(r) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	moduleId, err := consoleModuleId(r, true)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	fcId, err := strconv.ParseUint(r.FormValue("fcid"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid fcid", http.StatusBadRequest)
+		return
+	}
+	if err = this.FailCallBack(moduleId, fcId); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	// 页面表单提交后回到首页
+	if r.FormValue("redirect") != "" {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	writeConsoleJSON(w, map[string]any{"module_id": moduleId, "fc_id": fcId})
+}
+
+// 防止跨站提交，token不一致或Origin不是控制台地址时拒绝
+func (this *Console) checkPost(r *http.Request) bool {
+	if origin := r.Header.Get("Origin"); origin != "" && origin != "http://"+r.Host {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(r.FormValue("token")), []byte(this.token)) == 1
+}
+
+func (this *Console) handleIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	var data = struct {
+		Now       time.Time
+		Token     string
+		Modules   []ConsoleModule
+		CallBacks []ConsoleReq
+		Wheels    []ConsoleTimeWheel
+	}{
+		Now:       time.Now(),
+		Token:     this.token,
+		Modules:   this.Modules(),
+		CallBacks: this.CallBacks(0, ConsoleDefaultLimit),
+		Wheels:    this.Timers("", ConsoleDefaultLimit),
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := consoleTemplate.Execute(w, data); err != nil {
+		log.Error("asyn console write page err:%v", err)
+	}
+}
+
+// required为false时可以不指定模块，返回0
+func consoleModuleId(r *http.Request, required bool) (asyn_msg.AsynModuleId, error) {
+	var value = r.FormValue("module")
+	if value == "" && !required {
+		return 0, nil
+	}
+	if id, err := strconv.ParseInt(value, 10, 32); err == nil {
+		return asyn_msg.AsynModuleId(id), nil
+	}
+	if id, ok := asyn_msg.ModuleIdByName(value); ok {
+		return id, nil
+	}
+	return 0, fmt.Errorf("invalid module %q", value)
+}
+
+func consoleLimit(r *http.Request) int {
+	limit, err := strconv.Atoi(r.FormValue("limit"))
+	if err != nil || limit <= 0 {
+		return ConsoleDefaultLimit
+	}
+	if limit > ConsoleMaxLimit {
+		return ConsoleMaxLimit
+	}
+	return limit
+}
+
+func writeConsoleJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	var encoder = json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(v); err != nil {
+		log.Error("asyn console write json err:%v", err)
+	}
+}
+
+var consoleTemplate = template.Must(template.New("console").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>asyn console</title>
+<style>body{font-family:monospace}table{border-collapse:collapse;margin-bottom:16px}td,th{border:1px solid #ccc;padding:2px 6px;text-align:left}</style>
+</head><body>
+<p>{{.Now.Format "2006-01-02 15:04:05"}} | <a href="/modules">modules</a> <a href="/callbacks">callbacks</a> <a href="/timers">timers</a></p>
+<h3>modules</h3>
+<table><tr><th>id</th><th>name</th><th>type</th><th>health</th><th>queue</th><th>lanes</th><th>pending</th><th>working</th><th>restarts</th></tr>
+{{range .Modules}}<tr><td>{{.ModuleId}}</td><td>{{.Name}}</td><td>{{.Type}}</td><td>{{.Health}}</td><td><a href="/queue?module={{.ModuleId}}">{{.QueueDepth}}/{{.QueueCap}}</a></td><td>{{.LaneDepth}}</td><td>{{.Pending}}</td><td>{{.Working}}</td><td>{{.Loop.Restarts}}</td></tr>
+{{end}}</table>
+<h3>oldest callbacks</h3>
+<table><tr><th>module</th><th>fcId</th><th>req</th><th>priority</th><th>waited(ms)</th><th>processing</th><th></th></tr>
+{{range .CallBacks}}<tr><td>{{.ModuleId}}</td><td>{{.FcId}}</td><td title="{{.Req}}">{{.ReqType}}</td><td>{{.Priority}}</td><td>{{.WaitedMs}}</td><td>{{.Processing}}</td>
+<td><form method="post" action="/fail?module={{.ModuleId}}&fcid={{.FcId}}&redirect=1" onsubmit="return confirm('fail callback {{.FcId}}?')"><input type="hidden" name="token" value="{{$.Token}}"><button>fail</button></form></td></tr>
+{{end}}</table>
+{{range .Wheels}}<h3>time wheel {{.Name}} ({{.Pending}})</h3>
+<table><tr><th>timerId</th><th>callback</th><th>exeTime</th><th>delay</th><th>repeated</th></tr>
+{{range .Timers}}<tr><td>{{.TimerID}}</td><td>{{.Callback}}</td><td>{{.ExeTime}}</td><td>{{.Delay}}</td><td>{{.Repeated}}</td></tr>
+{{end}}</table>
+{{end}}
+</body></html>
+`))
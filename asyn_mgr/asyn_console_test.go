@@ -0,0 +1,136 @@
+package asyn_mgr
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"px/shared/asyn_mgr/asyn_msg"
+	"px/shared/time_wheel"
+	"px/utils/cbctx"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestConsole(t *testing.T) {
+	var release = make(chan struct{})
+	var module = newTestModule(asyn_msg.RedisModuleId, func(req asyn_msg.ReqInf) asyn_msg.RespInf {
+		<-release
+		return &testResp{Key: req.(*testReq).Key}
+	})
+	var mgr = newTestMgr(t, module)
+	defer close(release)
+
+	var results = make(map[string]error)
+	for _, key := range []string{"a", "b", "c"} {
+		var key = key
+		mgr.SendReq(&testReq{Key: key}, func(resp asyn_msg.RespInf) asyn_msg.AsynCBPtr {
+			results[key] = asyn_msg.RespErr(resp)
+			return 0
+		})
+		time.Sleep(time.Millisecond)
+	}
+	for i := 0; i < 100 && len(module.Working()) == 0; i++ {
+		time.Sleep(time.Millisecond)
+	}
+
+	var tw = time_wheel.NewTimeWheelSDefault()
+	tw.Start()
+	defer tw.Stop()
+	tw.AddOnceTimer(60000, func(int64, cbctx.Ctx) {})
+	for i := 0; i < 100 && tw.PendingLen() == 0; i++ {
+		time.Sleep(time.Millisecond)
+	}
+
+	var console = mgr.NewConsole()
+	console.AddTimeWheel("main", tw)
+	var server = httptest.NewServer(console)
+	defer server.Close()
+	var get = func(path string, v any) {
+		t.Helper()
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("GET %s status=%d", path, resp.StatusCode)
+		}
+		if v != nil {
+			if err = json.NewDecoder(resp.Body).Decode(v); err != nil {
+				t.Fatalf("GET %s decode err=%v", path, err)
+			}
+		}
+	}
+
+	var modules []ConsoleModule
+	get("/modules", &modules)
+	if len(modules) != 1 || modules[0].Name != "redis" || modules[0].Pending != 3 || modules[0].QueueDepth != 2 || modules[0].Working != 1 {
+		t.Errorf("modules=%+v", modules)
+	}
+
+	// 等待最久的在前，第一个正在处理
+	var callbacks []ConsoleReq
+	get("/callbacks?module=redis", &callbacks)
+	if len(callbacks) != 3 || !callbacks[0].Processing || callbacks[1].Processing || callbacks[0].WaitedMs < callbacks[2].WaitedMs {
+		t.Fatalf("callbacks=%+v", callbacks)
+	}
+	var queue []ConsoleReq
+	get("/queue?module=8&limit=1", &queue)
+	if len(queue) != 1 || queue[0].FcId != callbacks[1].FcId {
+		t.Errorf("queue=%+v, want fcId %d", queue, callbacks[1].FcId)
+	}
+	var wheels []ConsoleTimeWheel
+	get("/timers", &wheels)
+	if len(wheels) != 1 || wheels[0].Pending != 1 || len(wheels[0].Timers) != 1 || wheels[0].Timers[0].Callback == "" {
+		t.Errorf("wheels=%+v", wheels)
+	}
+	get("/", nil)
+
+	// 只能POST，回调在主逻辑中以ErrConsoleFailed执行
+	var failPath = "/fail?module=8&fcid=" + strconv.FormatUint(callbacks[0].FcId, 10)
+	if resp, err := http.Get(server.URL + failPath); err != nil || resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("GET fail resp=%v, err=%v", resp, err)
+	} else {
+		resp.Body.Close()
+	}
+	// 没有token或来自其他网页时拒绝
+	var post = func(path string, origin string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodPost, server.URL+path, nil)
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+	if resp := post(failPath, ""); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("POST fail without token status=%d", resp.StatusCode)
+	}
+	failPath += "&token=" + console.Token()
+	if resp := post(failPath, "http://evil.example"); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("POST fail from other origin status=%d", resp.StatusCode)
+	}
+	if len(results) != 0 {
+		t.Fatalf("results=%v after rejected POST", results)
+	}
+	resp, err := http.Post(server.URL+failPath, "", nil)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("POST fail resp=%v, err=%v", resp, err)
+	}
+	resp.Body.Close()
+	for len(results) == 0 && handleOne(mgr, time.Second) {
+	}
+	if err := results["a"]; !errors.Is(err, ErrConsoleFailed) || len(results) != 1 {
+		t.Errorf("results=%v, want a failed by console", results)
+	}
+
+	if err := console.ListenAndServe("0.0.0.0:0"); !errors.Is(err, ErrConsoleNotLocal) {
+		t.Errorf("listen err=%v, want ErrConsoleNotLocal", err)
+	}
+}
@@ -0,0 +1,210 @@
+package asyn_mgr
+
+import (
+	"errors"
+	"fmt"
+	"px/common/message"
+	"px/shared/asyn_mgr/asyn_msg"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"gitlab.sunborngame.com/base/log"
+)
+
+var (
+	ErrMsgDeliver    = errors.New("asyn msg deliver failed")
+	ErrClientOffline = errors.New("asyn msg client offline")
+)
+
+// ClientSender 发送推送消息，server_conn.ServerClusterMgr已实现
+// SendToClient没有返回值，只有发送panic(如集群未加载)时能发现失败，发出后对端不在线等失败无法上报
+type ClientSender interface {
+	SendToClient(userId uint64, msg message.Message)
+	BroadcastToGate(msg message.Message)
+}
+
+// ClientBatchSender 可选，一次发给同一router上的多个用户，返回的错误按整批失败上报
+// server_conn.ServerClusterMgr没有实现，未实现时同一批用户仍逐个调用SendToClient，分批只用于统计和上报
+type ClientBatchSender interface {
+	SendToClients(routerId int32, userIds []uint64, msg message.Message) error
+}
+
+// MsgDispatchOpt 推送消息分发设置
+type MsgDispatchOpt struct {
+	// 用户所在router，返回false表示不在线，按离线失败上报
+	// nil时所有用户视为router 0的同一批，server_conn没有按用户查询router的接口，需要业务提供
+	RouterOf func(userId uint64) (int32, bool)
+}
+
+// MsgDeliveryFailure 一条推送消息在一个router上发送失败的用户
+type MsgDeliveryFailure struct {
+	Msg       asyn_msg.RespMsgInf
+	RouterId  int32
+	UserIds   []uint64 // 广播时为空
+	Broadcast bool
+	Err       error
+}
+
+// 投递到主逻辑的发送失败
+type respMsgFailure struct {
+	asyn_msg.RespBase
+	MsgDeliveryFailure
+}
+
+func (this *respMsgFailure) GetModuleId() asyn_msg.AsynModuleId {
+	if msg, ok := this.Msg.(asyn_msg.RespInf); ok {
+		return msg.GetModuleId()
+	}
+	return 0
+}
+
+type msgDispatcher struct {
+	once      sync.Once
+	sender    ClientSender
+	opt       MsgDispatchOpt
+	delivered uint64 // 发送成功的用户数，广播计1
+	failed    uint64
+
+	lock      sync.RWMutex
+	listeners []func(MsgDeliveryFailure)
+}
+
+func newMsgDispatcher() *msgDispatcher {
+	return &msgDispatcher{}
+}
+
+// StartMsgDispatch 启动时调用，RespMsg()由内置goroutine消费并按router分批发送，调用后业务不要再读RespMsg()
+// 只有sender实现ClientBatchSender时才真正按router批量发送，否则逐个用户发送
+//
+//	asyn_mgr.GetAsynMgr().StartMsgDispatch(server_conn.GetServerClusterMgr(), nil)
+func (this *AsynMgr) StartMsgDispatch(sender ClientSender, opt *MsgDispatchOpt) {
+	this.dispatcher.once.Do(func() {
+		this.dispatcher.sender = sender
+		if opt != nil {
+			this.dispatcher.opt = *opt
+		}
+		go this.dispatchLoop()
+	})
+}
+
+// OnMsgDeliveryFailure 订阅推送发送失败，在主逻辑HandleResp中执行
+func (this *AsynMgr) OnMsgDeliveryFailure(fc func(failure MsgDeliveryFailure)) {
+	this.dispatcher.lock.Lock()
+	defer this.dispatcher.lock.Unlock()
+	this.dispatcher.listeners = append(this.dispatcher.listeners, fc)
+}
+
+// MsgDispatchStats 发送成功和失败的用户数，广播计为1
+func (this *AsynMgr) MsgDispatchStats() (delivered uint64, failed uint64) {
+	return atomic.LoadUint64(&this.dispatcher.delivered), atomic.LoadUint64(&this.dispatcher.failed)
+}
+
+func (this *msgDispatcher) notify(failure MsgDeliveryFailure) {
+	this.lock.RLock()
+	var listeners = this.listeners
+	this.lock.RUnlock()
+	for _, fc := range listeners {
+		fc(failure)
+	}
+}
+
+func (this *AsynMgr) dispatchLoop() {
+	for {
+		select {
+		case msg := <-this.respMsgChan.C():
+			for _, failure := range this.dispatcher.dispatch(msg) {
+				log.Error("asyn msg deliver failed, routerId=%d, broadcast=%v, users=%v, err=%v", failure.RouterId, failure.Broadcast, failure.UserIds, failure.Err)
+				this.respChan.Put(&respMsgFailure{MsgDeliveryFailure: failure})
+			}
+		case <-this.stopChan:
+			return
+		}
+	}
+}
+
+func (this *msgDispatcher) dispatch(msg asyn_msg.RespMsgInf) []MsgDeliveryFailure {
+	if msg.BroadcastAll() {
+		if err := this.call(func() error {
+			this.sender.BroadcastToGate(msg.GetMessage())
+			return nil
+		}); err != nil {
+			atomic.AddUint64(&this.failed, 1)
+			return []MsgDeliveryFailure{{Msg: msg, Broadcast: true, Err: err}}
+		}
+		atomic.AddUint64(&this.delivered, 1)
+		return nil
+	}
+
+	var failures []MsgDeliveryFailure
+	var batches = make(map[int32][]uint64)
+	var offline []uint64
+	for _, userId := range msg.GetUserIds() {
+		var routerId int32
+		if this.opt.RouterOf != nil {
+			var ok bool
+			if routerId, ok = this.opt.RouterOf(userId); !ok {
+				offline = append(offline, userId)
+				continue
+			}
+		}
+		batches[routerId] = append(batches[routerId], userId)
+	}
+	if len(offline) > 0 {
+		atomic.AddUint64(&this.failed, uint64(len(offline)))
+		failures = append(failures, MsgDeliveryFailure{Msg: msg, UserIds: offline, Err: ErrClientOffline})
+	}
+
+	var routerIds = make([]int32, 0, len(batches))
+	for routerId := range batches {
+		routerIds = append(routerIds, routerId)
+	}
+	sort.Slice(routerIds, func(i, j int) bool { return routerIds[i] < routerIds[j] })
+	for _, routerId := range routerIds {
+		var userIds = batches[routerId]
+		var failed, err = this.sendBatch(routerId, userIds, msg.GetMessage())
+		atomic.AddUint64(&this.delivered, uint64(len(userIds)-len(failed)))
+		if len(failed) > 0 {
+			atomic.AddUint64(&this.failed, uint64(len(failed)))
+			failures = append(failures, MsgDeliveryFailure{Msg: msg, RouterId: routerId, UserIds: failed, Err: err})
+		}
+	}
+	return failures
+}
+
+// 返回发送失败的用户，批量发送失败时整批失败
+// 逐个发送时SendToClient没有返回值，只有panic的用户记为失败
+func (this *msgDispatcher) sendBatch(routerId int32, userIds []uint64, msg message.Message) ([]uint64, error) {
+	if batch, ok := this.sender.(ClientBatchSender); ok {
+		if err := this.call(func() error { return batch.SendToClients(routerId, userIds, msg) }); err != nil {
+			return userIds, err
+		}
+		return nil, nil
+	}
+
+	var failed []uint64
+	var lastErr error
+	for _, userId := range userIds {
+		if err := this.call(func() error {
+			this.sender.SendToClient(userId, msg)
+			return nil
+		}); err != nil {
+			failed = append(failed, userId)
+			lastErr = err
+		}
+	}
+	return failed, lastErr
+}
+
+// 发送时panic(如集群未加载)视为失败，不影响后续消息
+func (this *msgDispatcher) call(send func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%w, panic=%v", ErrMsgDeliver, r)
+		}
+	}()
+	if err = send(); err != nil {
+		err = fmt.Errorf("%w, %w", ErrMsgDeliver, err)
+	}
+	return err
+}
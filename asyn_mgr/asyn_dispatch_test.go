@@ -0,0 +1,84 @@
+package asyn_mgr
+
+import (
+	"errors"
+	"px/common/message"
+	"px/shared/asyn_mgr/asyn_msg"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 按router记录发送，router 2上的用户发送时panic
+type testClientSender struct {
+	lock      sync.Mutex
+	sent      map[int32][]uint64
+	broadcast int
+}
+
+func (this *testClientSender) SendToClient(userId uint64, msg message.Message) {
+	panic("not batched")
+}
+
+func (this *testClientSender) BroadcastToGate(msg message.Message) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.broadcast++
+}
+
+func (this *testClientSender) SendToClients(routerId int32, userIds []uint64, msg message.Message) error {
+	if routerId == 2 {
+		panic("router closed")
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.sent[routerId] = append(this.sent[routerId], userIds...)
+	return nil
+}
+
+func TestMsgDispatch(t *testing.T) {
+	var mgr = newTestMgr(t)
+	var sender = &testClientSender{sent: make(map[int32][]uint64)}
+	mgr.StartMsgDispatch(sender, &MsgDispatchOpt{
+		RouterOf: func(userId uint64) (int32, bool) {
+			// 0不在线，偶数在router 1，奇数在router 2
+			return int32(userId%2 + 1), userId != 0
+		},
+	})
+	var failures []MsgDeliveryFailure
+	mgr.OnMsgDeliveryFailure(func(failure MsgDeliveryFailure) {
+		failures = append(failures, failure)
+	})
+
+	mgr.respMsgChan.Put(&asyn_msg.RespMsg{UserIds: []uint64{0, 1, 2, 3, 4}, ModuleId: asyn_msg.AoiModuleId})
+	mgr.respMsgChan.Put(&asyn_msg.RespMsg{Broadcast: true, ModuleId: asyn_msg.AoiModuleId})
+	for len(failures) < 2 && handleOne(mgr, time.Second) {
+	}
+
+	if len(failures) != 2 {
+		t.Fatalf("failures=%+v, want 2", failures)
+	}
+	if f := failures[0]; !errors.Is(f.Err, ErrClientOffline) || !reflect.DeepEqual(f.UserIds, []uint64{0}) {
+		t.Errorf("offline failure=%+v", f)
+	}
+	if f := failures[1]; !errors.Is(f.Err, ErrMsgDeliver) || f.RouterId != 2 || !reflect.DeepEqual(f.UserIds, []uint64{1, 3}) {
+		t.Errorf("router failure=%+v", f)
+	}
+
+	// 收到失败事件时广播可能还未发送
+	for i := 0; i < 100; i++ {
+		if delivered, _ := mgr.MsgDispatchStats(); delivered == 3 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	sender.lock.Lock()
+	defer sender.lock.Unlock()
+	if !reflect.DeepEqual(sender.sent, map[int32][]uint64{1: {2, 4}}) || sender.broadcast != 1 {
+		t.Errorf("sent=%v, broadcast=%d", sender.sent, sender.broadcast)
+	}
+	if delivered, failed := mgr.MsgDispatchStats(); delivered != 3 || failed != 3 {
+		t.Errorf("delivered=%d, failed=%d, want 3, 3", delivered, failed)
+	}
+}
@@ -0,0 +1,112 @@
+package asyn_fake
+
+import (
+	"px/shared/asyn_mgr/asyn_msg"
+	"reflect"
+	"sync"
+)
+
+// Handler 根据请求生成响应，返回nil表示不响应，请求一直挂起直到Timeout
+type Handler func(req asyn_msg.ReqInf) asyn_msg.RespInf
+
+// Return 总是返回resp的Handler，每次返回resp的浅拷贝，投递时设置的fcId等字段互不影响
+func Return(resp asyn_msg.RespInf) Handler {
+	return func(asyn_msg.ReqInf) asyn_msg.RespInf {
+		return asyn_msg.ShallowCopy(resp)
+	}
+}
+
+// FakeModule 脚本化的模块，收到的请求交给Harness按顺序处理
+type FakeModule struct {
+	*asyn_msg.AsynBase
+
+	harness  *Harness
+	moduleId asyn_msg.AsynModuleId
+
+	lock     sync.Mutex
+	handlers map[reflect.Type][]Handler // 同类型多个Handler依次使用，最后一个重复使用
+	fallback Handler
+}
+
+func newFakeModule(harness *Harness, moduleId asyn_msg.AsynModuleId) *FakeModule {
+	return &FakeModule{
+		AsynBase: asyn_msg.NewAsynBase(),
+		harness:  harness,
+		moduleId: moduleId,
+		handlers: make(map[reflect.Type][]Handler),
+	}
+}
+
+func (this *FakeModule) GetModuleId() asyn_msg.AsynModuleId {
+	return this.moduleId
+}
+
+func (this *FakeModule) Init() {
+}
+
+func (this *FakeModule) Close() {
+	this.Stop()
+}
+
+// On 设置某类请求的Handler，req只用于获取类型，多次调用时按顺序各使用一次
+func (this *FakeModule) On(req asyn_msg.ReqInf, handler Handler) *FakeModule {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	var tp = reflect.TypeOf(req)
+	this.handlers[tp] = append(this.handlers[tp], handler)
+	return this
+}
+
+// OnAny 没有匹配类型的Handler时使用
+func (this *FakeModule) OnAny(handler Handler) *FakeModule {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.fallback = handler
+	return this
+}
+
+func (this *FakeModule) hasHandler(req asyn_msg.ReqInf) bool {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return len(this.handlers[reflect.TypeOf(req)]) > 0 || this.fallback != nil
+}
+
+func (this *FakeModule) handler(req asyn_msg.ReqInf) Handler {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	var tp = reflect.TypeOf(req)
+	if handlers := this.handlers[tp]; len(handlers) > 0 {
+		if len(handlers) > 1 {
+			this.handlers[tp] = handlers[1:]
+		}
+		return handlers[0]
+	}
+	return this.fallback
+}
+
+func (this *FakeModule) SendReq(req asyn_msg.ReqInf, cb asyn_msg.AsynCallback) {
+	this.SendReqFrom(nil, req, cb)
+}
+
+// SendReqFrom 请求进入队列后立即取出，交给Harness
+// 模块已关闭或请求被拒绝时队列中没有该请求，回调已以RespError投递到RespChan
+func (this *FakeModule) SendReqFrom(owner asyn_msg.RespOwner, req asyn_msg.ReqInf, cb asyn_msg.AsynCallback) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.AsynBase.SendReqFrom(owner, req, cb)
+	var queued bool
+	for _, r := range this.ReqChan.Snapshot(0) {
+		queued = queued || r.GetFcId() == req.GetFcId()
+	}
+	if !queued {
+		return
+	}
+	// 队列中先到的请求(如Requeue转入的)按顺序交给Harness
+	for {
+		var r = <-this.ReqChan.C()
+		this.harness.push(this, r)
+		if r.GetFcId() == req.GetFcId() {
+			return
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package asyn_fake
+
+import (
+	"px/shared/asyn_mgr"
+	"px/shared/asyn_mgr/asyn_msg"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	FlushMaxSteps = 10000 // Flush最多处理的请求数，防止回调中无限发请求
+)
+
+// Sent 发到假模块的一个请求
+type Sent struct {
+	Module *FakeModule
+	Req    asyn_msg.ReqInf
+	Done   bool // 已响应或已超时
+	Held   bool // Handler未响应，等待Timeout或Reply
+}
+
+// Harness 用假模块替换AsynMgr中的模块，测试中同步、按顺序执行回调
+// 默认使用独立的AsynMgr，测试之间互不影响，可以并行
+//
+//	h := asyn_fake.New(t)
+//	h.Module(asyn_msg.RedisModuleId).On(&redis_proxy.ReqGet{}, asyn_fake.Return(&redis_proxy.RespGet{Ret: "v"}))
+//	logic.Load(h.Mgr(), uid)        // 内部asyn_mgr.SendVia
+//	h.Flush()                       // 执行所有回调，包括回调内发出的请求
+//	reqs := asyn_fake.SentOf[*redis_proxy.ReqGet](h)
+type Harness struct {
+	t   testing.TB
+	mgr *asyn_mgr.AsynMgr
+
+	lock    sync.Mutex
+	modules map[asyn_msg.AsynModuleId]*FakeModule
+	sent    []*Sent // 按发送顺序
+	pending []*Sent // 等待响应，按发送顺序
+}
+
+// New 使用独立的AsynMgr，测试结束时自动移除假模块
+func New(t testing.TB) *Harness {
+	return NewWith(t, asyn_mgr.NewAsynMgr())
+}
+
+// NewWith 使用指定的AsynMgr，如测试通过asyn_mgr.Send发请求的代码时用GetAsynMgr()，此时测试不能并行
+func NewWith(t testing.TB, mgr *asyn_mgr.AsynMgr) *Harness {
+	var h = &Harness{
+		t:       t,
+		mgr:     mgr,
+		modules: make(map[asyn_msg.AsynModuleId]*FakeModule),
+	}
+	t.Cleanup(h.Close)
+	return h
+}
+
+// Mgr 假模块所在的AsynMgr，被测代码通过它发请求
+func (this *Harness) Mgr() *asyn_mgr.AsynMgr {
+	return this.mgr
+}
+
+// Module 获取或注册moduleId的假模块，该id不能已有真实模块
+func (this *Harness) Module(moduleId asyn_msg.AsynModuleId) *FakeModule {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if module, ok := this.modules[moduleId]; ok {
+		return module
+	}
+	var module = newFakeModule(this, moduleId)
+	if err := this.mgr.AddModule(module); err != nil {
+		this.t.Fatalf("add fake module failed, moduleId=%d, err=%v", moduleId, err)
+	}
+	this.modules[moduleId] = module
+	return module
+}
+
+func (this *Harness) push(module *FakeModule, req asyn_msg.ReqInf) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	var sent = &Sent{Module: module, Req: req}
+	this.sent = append(this.sent, sent)
+	this.pending = append(this.pending, sent)
+}
+
+// Sent 所有发出的请求
+func (this *Harness) Sent() []*Sent {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return append([]*Sent(nil), this.sent...)
+}
+
+// Pending 还未响应的请求
+func (this *Harness) Pending() []*Sent {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return append([]*Sent(nil), this.pending...)
+}
+
+// SentOf 发出的某类请求
+func SentOf[Req asyn_msg.ReqInf](h *Harness) []Req {
+	var reqs []Req
+	for _, sent := range h.Sent() {
+		if req, ok := sent.Req.(Req); ok {
+			reqs = append(reqs, req)
+		}
+	}
+	return reqs
+}
+
+// ExpectSent 断言发出的某类请求数量
+func ExpectSent[Req asyn_msg.ReqInf](h *Harness, n int) []Req {
+	h.t.Helper()
+	var reqs = SentOf[Req](h)
+	if len(reqs) != n {
+		var zero Req
+		h.t.Fatalf("sent %d %T, want %d", len(reqs), zero, n)
+	}
+	return reqs
+}
+
+// Step 按发送顺序响应最早的一个请求，没有可响应的请求时返回false
+func (this *Harness) Step() bool {
+	if this.flushRejected() {
+		return true
+	}
+
+	var sent *Sent
+	for _, s := range this.Pending() {
+		if !s.Held && s.Module.hasHandler(s.Req) {
+			sent = s
+			break
+		}
+	}
+	if sent == nil {
+		return false
+	}
+	this.Deliver(sent.Req)
+	return true
+}
+
+// Flush 响应所有请求，包括回调中新发出的，返回处理的请求数
+func (this *Harness) Flush() int {
+	var n = 0
+	for ; n < FlushMaxSteps && this.Step(); n++ {
+	}
+	if n >= FlushMaxSteps {
+		this.t.Fatalf("flush exceed %d steps", FlushMaxSteps)
+	}
+	return n
+}
+
+// Deliver 用模块的Handler响应指定请求，可以控制响应顺序
+func (this *Harness) Deliver(req asyn_msg.ReqInf) {
+	this.t.Helper()
+	var sent = this.take(req)
+	if sent == nil {
+		this.t.Fatalf("deliver req not pending, req=%v", req)
+		return
+	}
+	var handler = sent.Module.handler(req)
+	if handler == nil {
+		this.t.Fatalf("no handler, moduleId=%d, req=%T", sent.Module.moduleId, req)
+		return
+	}
+	var resp = handler(req)
+	if resp == nil {
+		// 不响应，放回队尾等待Timeout或Reply
+		this.lock.Lock()
+		sent.Done, sent.Held = false, true
+		this.pending = append(this.pending, sent)
+		this.lock.Unlock()
+		return
+	}
+	this.Reply(req, resp)
+}
+
+// Reply 用指定的响应回复请求，忽略Handler
+func (this *Harness) Reply(req asyn_msg.ReqInf, resp asyn_msg.RespInf) {
+	this.take(req)
+	resp.SetFcId(req.GetFcId())
+	resp.SetRespTime(time.Now().UnixNano())
+	this.mgr.HandleResp(resp)
+}
+
+// Timeout 让请求以RespTimeout回调
+func (this *Harness) Timeout(req asyn_msg.ReqInf) {
+	this.t.Helper()
+	var sent = this.take(req)
+	if sent == nil {
+		this.t.Fatalf("timeout req not pending, req=%v", req)
+		return
+	}
+	this.mgr.HandleResp(asyn_msg.NewRespTimeout(sent.Module.moduleId, req))
+}
+
+func (this *Harness) take(req asyn_msg.ReqInf) *Sent {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	for i, sent := range this.pending {
+		if sent.Req == req {
+			this.pending = append(this.pending[:i], this.pending[i+1:]...)
+			sent.Done = true
+			return sent
+		}
+	}
+	return nil
+}
+
+// 请求被模块拒绝(关闭、队列满)时RespError投递到了模块的RespChan，被拦截器拒绝时投递到AsynMgr
+func (this *Harness) flushRejected() bool {
+	select {
+	case resp := <-this.mgr.Resp():
+		this.mgr.HandleResp(resp)
+		return true
+	default:
+	}
+
+	this.lock.Lock()
+	var modules = make([]*FakeModule, 0, len(this.modules))
+	for _, module := range this.modules {
+		modules = append(modules, module)
+	}
+	this.lock.Unlock()
+
+	for _, module := range modules {
+		select {
+		case resp := <-module.RespChan.C():
+			this.mgr.HandleResp(resp)
+			return true
+		default:
+		}
+	}
+	return false
+}
+
+// ExpectNoPending 断言所有请求都已响应
+func (this *Harness) ExpectNoPending() {
+	this.t.Helper()
+	for _, sent := range this.Pending() {
+		this.t.Errorf("pending req, moduleId=%d, type=%s, req=%v", sent.Module.moduleId, reflect.TypeOf(sent.Req), sent.Req)
+	}
+}
+
+// Close 丢弃未响应的回调并移除假模块
+func (this *Harness) Close() {
+	this.lock.Lock()
+	var modules = this.modules
+	this.modules = make(map[asyn_msg.AsynModuleId]*FakeModule)
+	this.pending = nil
+	this.lock.Unlock()
+
+	for moduleId, module := range modules {
+		module.TakePending()
+		if err := this.mgr.RemoveModule(moduleId); err != nil {
+			this.t.Errorf("remove fake module failed, moduleId=%d, err=%v", moduleId, err)
+		}
+	}
+}
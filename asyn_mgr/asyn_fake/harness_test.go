@@ -0,0 +1,127 @@
+package asyn_fake
+
+import (
+	"errors"
+	"px/shared/asyn_mgr"
+	"px/shared/asyn_mgr/asyn_msg"
+	"testing"
+)
+
+const testModuleId asyn_msg.AsynModuleId = 100
+
+type testGet struct {
+	asyn_msg.ReqBase
+	Key string
+}
+
+type testGetResp struct {
+	asyn_msg.RespBase
+	Value string
+}
+
+func (this *testGet) GetModuleId() asyn_msg.AsynModuleId {
+	return testModuleId
+}
+
+func (this *testGetResp) GetModuleId() asyn_msg.AsynModuleId {
+	return testModuleId
+}
+
+func TestHarness(t *testing.T) {
+	t.Parallel()
+	var h = New(t)
+	h.Module(testModuleId).On(&testGet{}, func(req asyn_msg.ReqInf) asyn_msg.RespInf {
+		return &testGetResp{Value: "v_" + req.(*testGet).Key}
+	})
+
+	var got []string
+	asyn_mgr.SendVia(h.Mgr(), &testGet{Key: "a"}, func(resp *testGetResp, err error) {
+		got = append(got, resp.Value)
+		// 回调内的请求在Flush中继续处理
+		asyn_mgr.SendVia(h.Mgr(), &testGet{Key: "c"}, func(resp *testGetResp, err error) {
+			got = append(got, resp.Value)
+		})
+	})
+	asyn_mgr.SendVia(h.Mgr(), &testGet{Key: "b"}, func(resp *testGetResp, err error) {
+		got = append(got, resp.Value)
+	})
+
+	var reqs = ExpectSent[*testGet](h, 2)
+	// 控制响应顺序
+	h.Deliver(reqs[1])
+	if n := h.Flush(); n != 2 {
+		t.Errorf("flush=%d, want 2", n)
+	}
+	if len(got) != 3 || got[0] != "v_b" || got[1] != "v_a" || got[2] != "v_c" {
+		t.Errorf("got=%v", got)
+	}
+	ExpectSent[*testGet](h, 3)
+	h.ExpectNoPending()
+}
+
+func TestHarnessTimeout(t *testing.T) {
+	t.Parallel()
+	var h = New(t)
+	h.Module(testModuleId).OnAny(func(req asyn_msg.ReqInf) asyn_msg.RespInf {
+		return nil
+	})
+
+	var gotErr error
+	asyn_mgr.SendVia(h.Mgr(), &testGet{Key: "a"}, func(resp *testGetResp, err error) {
+		gotErr = err
+	})
+	h.Flush()
+	var pending = h.Pending()
+	if len(pending) != 1 || !pending[0].Held {
+		t.Fatalf("pending=%v", pending)
+	}
+	h.Timeout(pending[0].Req)
+	var timeout *asyn_msg.RespTimeout
+	if !errors.As(gotErr, &timeout) {
+		t.Errorf("err=%v, want timeout", gotErr)
+	}
+}
+
+// Return的响应每次投递都是新对象
+func TestHarnessReturn(t *testing.T) {
+	t.Parallel()
+	var h = New(t)
+	var want = &testGetResp{Value: "v"}
+	h.Module(testModuleId).On(&testGet{}, Return(want))
+
+	var got []*testGetResp
+	for _, key := range []string{"a", "b"} {
+		asyn_mgr.SendVia(h.Mgr(), &testGet{Key: key}, func(resp *testGetResp, err error) {
+			got = append(got, resp)
+		})
+	}
+	var reqs = ExpectSent[*testGet](h, 2)
+	h.Flush()
+	if len(got) != 2 || got[0] == got[1] || got[0] == want {
+		t.Fatalf("got=%v", got)
+	}
+	for i, resp := range got {
+		if resp.Value != "v" || resp.GetFcId() != reqs[i].GetFcId() {
+			t.Errorf("resp %d=%+v, want fcId %d", i, resp, reqs[i].GetFcId())
+		}
+	}
+	if want.GetFcId() != 0 {
+		t.Errorf("template fcId=%d", want.GetFcId())
+	}
+}
+
+// 队列中已有的请求按顺序交给Harness，不会把它当成刚发出的请求
+func TestHarnessQueued(t *testing.T) {
+	t.Parallel()
+	var h = New(t)
+	var module = h.Module(testModuleId).OnAny(Return(&testGetResp{}))
+	var requeued = &testGet{Key: "requeued"}
+	requeued.SetFcId(1000)
+	module.Requeue(requeued, nil)
+
+	asyn_mgr.SendVia(h.Mgr(), &testGet{Key: "a"}, func(resp *testGetResp, err error) {})
+	var reqs = ExpectSent[*testGet](h, 2)
+	if reqs[0] != requeued || reqs[1].Key != "a" {
+		t.Errorf("sent=%v, %v", reqs[0].Key, reqs[1].Key)
+	}
+}
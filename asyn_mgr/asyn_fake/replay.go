@@ -0,0 +1,95 @@
+package asyn_fake
+
+import (
+	"errors"
+	"fmt"
+	"px/shared/asyn_mgr/asyn_journal"
+	"px/shared/asyn_mgr/asyn_msg"
+)
+
+var (
+	ErrReplayMismatch = errors.New("replay mismatch")
+)
+
+type replayKey struct {
+	moduleId asyn_msg.AsynModuleId
+	reqType  string
+	n        int // 该模块该类型的第n个请求
+}
+
+type recordKey struct {
+	moduleId asyn_msg.AsynModuleId
+	fcId     uint64
+}
+
+// Replay 记录中出现的模块注册为假模块后执行run，之后用记录中的响应回复run及回调发出的请求，按记录中响应的顺序执行回调
+// 请求按(模块, 类型, 第n个)与记录中的请求对应，响应类型需先asyn_journal.Register
+func (this *Harness) Replay(records []*asyn_journal.Record, run func()) error {
+	var reqKeys = make(map[recordKey]replayKey)
+	var counts = make(map[replayKey]int)
+	for _, record := range records {
+		if record.Kind != asyn_journal.KindReq {
+			continue
+		}
+		var key = replayKey{moduleId: record.ModuleId, reqType: record.Type}
+		key.n = counts[key]
+		counts[key]++
+		reqKeys[recordKey{record.ModuleId, record.FcId}] = key
+		this.Module(record.ModuleId)
+	}
+	run()
+
+	for _, record := range records {
+		if record.Kind == asyn_journal.KindReq {
+			continue
+		}
+		for this.flushRejected() {
+		}
+
+		key, ok := reqKeys[recordKey{record.ModuleId, record.FcId}]
+		if !ok {
+			return fmt.Errorf("%w, resp without req, moduleId=%d, fcId=%d", ErrReplayMismatch, record.ModuleId, record.FcId)
+		}
+		var req = this.nthSent(key)
+		if req == nil {
+			return fmt.Errorf("%w, req not sent, moduleId=%d, type=%s, n=%d", ErrReplayMismatch, key.moduleId, key.reqType, key.n)
+		}
+
+		var resp asyn_msg.RespInf
+		switch record.Kind {
+		case asyn_journal.KindTimeout:
+			resp = asyn_msg.NewRespTimeout(record.ModuleId, req)
+		case asyn_journal.KindError:
+			resp = asyn_msg.NewRespError(record.ModuleId, req, errors.New(record.Err))
+		default:
+			msg, err := record.Decode()
+			if err != nil {
+				return err
+			}
+			if resp, ok = msg.(asyn_msg.RespInf); !ok {
+				return fmt.Errorf("%w, type %s is not RespInf", ErrReplayMismatch, record.Type)
+			}
+		}
+		if this.take(req) == nil {
+			return fmt.Errorf("%w, req not pending, moduleId=%d, type=%s, n=%d", ErrReplayMismatch, key.moduleId, key.reqType, key.n)
+		}
+		this.Reply(req, resp)
+	}
+	for this.flushRejected() {
+	}
+	return nil
+}
+
+func (this *Harness) nthSent(key replayKey) asyn_msg.ReqInf {
+	var n = 0
+	for _, sent := range this.Sent() {
+		if sent.Module.moduleId != key.moduleId || asyn_msg.TypeName(sent.Req) != key.reqType {
+			continue
+		}
+		if n == key.n {
+			return sent.Req
+		}
+		n++
+	}
+	return nil
+}
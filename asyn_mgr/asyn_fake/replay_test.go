@@ -0,0 +1,40 @@
+package asyn_fake
+
+import (
+	"errors"
+	"px/shared/asyn_mgr"
+	"px/shared/asyn_mgr/asyn_journal"
+	"px/shared/asyn_mgr/asyn_msg"
+	"testing"
+)
+
+func TestReplay(t *testing.T) {
+	asyn_journal.Register(&testGetResp{})
+	var records = []*asyn_journal.Record{
+		{Kind: asyn_journal.KindReq, ModuleId: testModuleId, FcId: 7, Type: "testGet"},
+		{Kind: asyn_journal.KindReq, ModuleId: testModuleId, FcId: 8, Type: "testGet"},
+		{Kind: asyn_journal.KindTimeout, ModuleId: testModuleId, FcId: 8},
+		{Kind: asyn_journal.KindResp, ModuleId: testModuleId, FcId: 7, Type: "testGetResp", Body: []byte(`{"Value":"a"}`)},
+	}
+
+	var h = New(t)
+	var got []string
+	err := h.Replay(records, func() {
+		asyn_mgr.SendVia(h.Mgr(), &testGet{Key: "a"}, func(resp *testGetResp, err error) {
+			got = append(got, resp.Value)
+		})
+		asyn_mgr.SendVia(h.Mgr(), &testGet{Key: "b"}, func(resp *testGetResp, err error) {
+			var timeout *asyn_msg.RespTimeout
+			if errors.As(err, &timeout) {
+				got = append(got, "timeout")
+			}
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0] != "timeout" || got[1] != "a" {
+		t.Errorf("got=%v", got)
+	}
+	h.ExpectNoPending()
+}
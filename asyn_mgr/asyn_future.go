@@ -0,0 +1,138 @@
+package asyn_mgr
+
+import (
+	"errors"
+	"fmt"
+	"px/shared/asyn_mgr/asyn_msg"
+	"runtime"
+)
+
+var (
+	ErrRespType = errors.New("asyn resp type error")
+)
+
+// CastResp 将RespInf转成具体的响应类型，RespTimeout/RespError和类型不匹配转成error
+func CastResp[Resp asyn_msg.RespInf](inf asyn_msg.RespInf) (Resp, error) {
+	var zero Resp
+	resp, ok := inf.(Resp)
+	if ok {
+		return resp, nil
+	}
+	if err, ok := inf.(error); ok {
+		return zero, err
+	}
+	return zero, fmt.Errorf("%w, want=%T, resp=%T(%v)", ErrRespType, zero, inf, inf)
+}
+
+// WrapCB 带类型的回调转成AsynCallback，回调返回0时用回调自身做pprof
+func WrapCB[Resp asyn_msg.RespInf](cb func(Resp, error) asyn_msg.AsynCBPtr) asyn_msg.AsynCallback {
+	if cb == nil {
+		return nil
+	}
+	return func(inf asyn_msg.RespInf) asyn_msg.AsynCBPtr {
+		resp, err := CastResp[Resp](inf)
+		x := cb(resp, err)
+		if x == 0 {
+			x = asyn_msg.CBPtr(cb)
+		}
+		return x
+	}
+}
+
+// Wrap 带类型的回调转成AsynCallback
+func Wrap[Resp asyn_msg.RespInf](cb func(Resp, error)) asyn_msg.AsynCallback {
+	if cb == nil {
+		return nil
+	}
+	return func(inf asyn_msg.RespInf) asyn_msg.AsynCBPtr {
+		resp, err := CastResp[Resp](inf)
+		cb(resp, err)
+		return asyn_msg.CBPtr(cb)
+	}
+}
+
+// Send 带类型的SendReq，Resp可以由回调参数推导
+//
+//	asyn_mgr.Send(req, func(resp *dbclient.RespDbQuery, err error) {...})
+func Send[Req asyn_msg.ReqInf, Resp asyn_msg.RespInf](req Req, cb func(Resp, error)) {
+	SendVia(asynMgr, req, cb)
+}
+
+// SendCB 同Send，回调内有嵌套请求时返回内层回调指针用于pprof
+func SendCB[Req asyn_msg.ReqInf, Resp asyn_msg.RespInf](req Req, cb func(Resp, error) asyn_msg.AsynCBPtr) {
+	SendCBVia(asynMgr, req, cb)
+}
+
+// SendVia 同Send，通过指定的AsynMgr发送，用于测试和独立的管理器(Go不支持泛型方法)
+func SendVia[Req asyn_msg.ReqInf, Resp asyn_msg.RespInf](mgr *AsynMgr, req Req, cb func(Resp, error)) {
+	mgr.SendReq(req, Wrap[Resp](cb))
+}
+
+func SendCBVia[Req asyn_msg.ReqInf, Resp asyn_msg.RespInf](mgr *AsynMgr, req Req, cb func(Resp, error) asyn_msg.AsynCBPtr) {
+	mgr.SendReq(req, WrapCB[Resp](cb))
+}
+
+// Future 异步结果，和HandleResp在同一个goroutine中使用，非线程安全
+type Future[Resp asyn_msg.RespInf] struct {
+	done bool
+	resp Resp
+	err  error
+	cbs  []func(Resp, error)
+	ptr  asyn_msg.AsynCBPtr // 完成时还没有Then回调，pprof记到SendFuture的调用方
+}
+
+// SendFuture 发送请求，结果通过Future获取
+func SendFuture[Req asyn_msg.ReqInf, Resp asyn_msg.RespInf](req Req) *Future[Resp] {
+	return sendFuture[Req, Resp](asynMgr, req)
+}
+
+// SendFutureVia 同SendFuture，通过指定的AsynMgr发送
+func SendFutureVia[Req asyn_msg.ReqInf, Resp asyn_msg.RespInf](mgr *AsynMgr, req Req) *Future[Resp] {
+	return sendFuture[Req, Resp](mgr, req)
+}
+
+func sendFuture[Req asyn_msg.ReqInf, Resp asyn_msg.RespInf](mgr *AsynMgr, req Req) *Future[Resp] {
+	var future = &Future[Resp]{}
+	if pc, _, _, ok := runtime.Caller(2); ok {
+		if fn := runtime.FuncForPC(pc); fn != nil {
+			future.ptr = asyn_msg.AsynCBPtr(fn.Entry())
+		}
+	}
+	mgr.SendReq(req, func(inf asyn_msg.RespInf) asyn_msg.AsynCBPtr {
+		resp, err := CastResp[Resp](inf)
+		return future.resolve(resp, err)
+	})
+	return future
+}
+
+func (this *Future[Resp]) resolve(resp Resp, err error) asyn_msg.AsynCBPtr {
+	this.done, this.resp, this.err = true, resp, err
+
+	var x = this.ptr
+	if len(this.cbs) > 0 {
+		x = asyn_msg.CBPtr(this.cbs[0])
+	}
+	for _, cb := range this.cbs {
+		cb(resp, err)
+	}
+	this.cbs = nil
+	return x
+}
+
+// Then 注册结果回调，已完成时立即执行
+func (this *Future[Resp]) Then(cb func(Resp, error)) *Future[Resp] {
+	if this.done {
+		cb(this.resp, this.err)
+		return this
+	}
+	this.cbs = append(this.cbs, cb)
+	return this
+}
+
+func (this *Future[Resp]) Done() bool {
+	return this.done
+}
+
+func (this *Future[Resp]) Result() (Resp, error) {
+	return this.resp, this.err
+}
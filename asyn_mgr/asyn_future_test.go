@@ -0,0 +1,127 @@
+package asyn_mgr
+
+import (
+	"errors"
+	"px/shared/asyn_mgr/asyn_msg"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testOtherResp struct {
+	asyn_msg.RespBase
+}
+
+func (this *testOtherResp) GetModuleId() asyn_msg.AsynModuleId {
+	return asyn_msg.RedisModuleId
+}
+
+// key为other时返回其他类型，hang时不响应
+func newFutureMgr(t *testing.T) *AsynMgr {
+	var module = newTestModule(asyn_msg.RedisModuleId, func(req asyn_msg.ReqInf) asyn_msg.RespInf {
+		switch key := req.(*testReq).Key; key {
+		case "other":
+			return &testOtherResp{}
+		case "hang":
+			return nil
+		default:
+			return &testResp{Key: key}
+		}
+	})
+	return newTestMgr(t, module)
+}
+
+func TestCastResp(t *testing.T) {
+	var req = &testReq{Key: "k"}
+	if resp, err := CastResp[*testResp](&testResp{Key: "k"}); err != nil || resp.Key != "k" {
+		t.Errorf("cast resp=%v, err=%v", resp, err)
+	}
+	if resp, err := CastResp[*testResp](&testOtherResp{}); !errors.Is(err, ErrRespType) || resp != nil {
+		t.Errorf("cast other resp=%v, err=%v, want ErrRespType", resp, err)
+	}
+	var cause = errors.New("cause")
+	if _, err := CastResp[*testResp](asyn_msg.NewRespError(asyn_msg.RedisModuleId, req, cause)); !errors.Is(err, cause) {
+		t.Errorf("cast RespError err=%v, want cause", err)
+	}
+	var timeout *asyn_msg.RespTimeout
+	if _, err := CastResp[*testResp](asyn_msg.NewRespTimeout(asyn_msg.RedisModuleId, req)); !errors.As(err, &timeout) {
+		t.Errorf("cast RespTimeout err=%v", err)
+	}
+}
+
+func TestSendVia(t *testing.T) {
+	var mgr = newFutureMgr(t)
+	var results = make(map[string]error)
+	var send = func(key string, timeout int64) {
+		var req = &testReq{Key: key}
+		req.SetTimeout(timeout)
+		SendVia(mgr, req, func(resp *testResp, err error) {
+			if err == nil && resp.Key != key {
+				t.Errorf("resp key=%s, want %s", resp.Key, key)
+			}
+			results[key] = err
+		})
+	}
+	send("ok", 0)
+	send("other", 0)
+	send("hang", 50)
+	for len(results) < 3 && handleOne(mgr, time.Second) {
+	}
+	var timeout *asyn_msg.RespTimeout
+	if results["ok"] != nil || !errors.Is(results["other"], ErrRespType) || !errors.As(results["hang"], &timeout) {
+		t.Errorf("results=%v", results)
+	}
+
+	// 回调返回0时用回调自身做pprof
+	var cb = func(resp *testResp, err error) asyn_msg.AsynCBPtr { return 0 }
+	SendCBVia(mgr, &testReq{Key: "cb"}, cb)
+	select {
+	case resp := <-mgr.Resp():
+		if ptr := mgr.HandleResp(resp); ptr != asyn_msg.CBPtr(cb) {
+			t.Errorf("ptr=%x, want cb", ptr)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no resp")
+	}
+}
+
+func TestFuture(t *testing.T) {
+	var mgr = newFutureMgr(t)
+	var handle = func() asyn_msg.AsynCBPtr {
+		t.Helper()
+		select {
+		case resp := <-mgr.Resp():
+			return mgr.HandleResp(resp)
+		case <-time.After(time.Second):
+			t.Fatal("no resp")
+			return 0
+		}
+	}
+
+	var got []string
+	var then = func(resp *testResp, err error) { got = append(got, resp.Key) }
+	var future = SendFutureVia[*testReq, *testResp](mgr, &testReq{Key: "a"}).Then(then)
+	if future.Done() {
+		t.Fatal("future done before resp")
+	}
+	if ptr := handle(); ptr != asyn_msg.CBPtr(then) {
+		t.Errorf("ptr=%x, want Then callback", ptr)
+	}
+	if resp, err := future.Result(); !future.Done() || err != nil || resp.Key != "a" {
+		t.Errorf("result=%v, err=%v", resp, err)
+	}
+	// 已完成时Then立即执行
+	future.Then(then)
+	if strings.Join(got, ",") != "a,a" {
+		t.Errorf("then got=%v", got)
+	}
+
+	// 没有Then回调时记到发送方函数
+	var other = SendFutureVia[*testReq, *testResp](mgr, &testReq{Key: "other"})
+	if name, _, _ := funcInfo(handle()); !strings.Contains(name, "TestFuture") {
+		t.Errorf("ptr func=%s, want TestFuture", name)
+	}
+	if _, err := other.Result(); !errors.Is(err, ErrRespType) {
+		t.Errorf("other err=%v, want ErrRespType", err)
+	}
+}
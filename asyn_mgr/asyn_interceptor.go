@@ -0,0 +1,147 @@
+package asyn_mgr
+
+import (
+	"errors"
+	"fmt"
+	"px/shared/asyn_mgr/asyn_msg"
+	"sync"
+	"time"
+
+	"gitlab.sunborngame.com/base/log"
+)
+
+const (
+	AllModules asyn_msg.AsynModuleId = 0 // 注册全局拦截器
+)
+
+var (
+	ErrReqInvalid = errors.New("asyn req invalid")
+)
+
+type (
+	SendHandler func(owner asyn_msg.RespOwner, req asyn_msg.ReqInf, cb asyn_msg.AsynCallback)
+	// SendInterceptor 在SendReq的goroutine中执行，不调用next即拦截该请求，需用RejectReq让回调失败
+	SendInterceptor func(owner asyn_msg.RespOwner, req asyn_msg.ReqInf, cb asyn_msg.AsynCallback, next SendHandler)
+
+	RespHandler func(resp asyn_msg.RespInf) asyn_msg.AsynCBPtr
+	// RespInterceptor 在HandleResp的goroutine中执行，不调用next时回调不会执行
+	RespInterceptor func(resp asyn_msg.RespInf, next RespHandler) asyn_msg.AsynCBPtr
+
+	// 请求实现该接口时ValidateInterceptor在发送前检查
+	Validator interface {
+		Validate() error
+	}
+)
+
+// 一般在启动时注册，重试、合并等功能第一次设置时追加，已发出的请求不受影响
+type interceptors struct {
+	lock sync.RWMutex
+	send map[asyn_msg.AsynModuleId][]SendInterceptor
+	resp map[asyn_msg.AsynModuleId][]RespInterceptor
+}
+
+func newInterceptors() *interceptors {
+	return &interceptors{
+		send: make(map[asyn_msg.AsynModuleId][]SendInterceptor),
+		resp: make(map[asyn_msg.AsynModuleId][]RespInterceptor),
+	}
+}
+
+// AddSendInterceptor moduleId为AllModules时对所有模块生效，全局的先于模块的执行，同级按注册顺序执行
+func (this *AsynMgr) AddSendInterceptor(moduleId asyn_msg.AsynModuleId, interceptor SendInterceptor) {
+	this.interceptors.lock.Lock()
+	defer this.interceptors.lock.Unlock()
+	this.interceptors.send[moduleId] = append(this.interceptors.send[moduleId], interceptor)
+}
+
+// AddRespInterceptor 执行顺序同AddSendInterceptor
+func (this *AsynMgr) AddRespInterceptor(moduleId asyn_msg.AsynModuleId, interceptor RespInterceptor) {
+	this.interceptors.lock.Lock()
+	defer this.interceptors.lock.Unlock()
+	this.interceptors.resp[moduleId] = append(this.interceptors.resp[moduleId], interceptor)
+}
+
+func (this *interceptors) sendChain(moduleId asyn_msg.AsynModuleId, final SendHandler) SendHandler {
+	this.lock.RLock()
+	var global, module = this.send[AllModules], this.send[moduleId]
+	if len(global) == 0 && len(module) == 0 {
+		this.lock.RUnlock()
+		return final
+	}
+	var chain = make([]SendInterceptor, 0, len(global)+len(module))
+	chain = append(append(chain, global...), module...)
+	this.lock.RUnlock()
+	return chainSend(chain, 0, final)
+}
+
+func chainSend(chain []SendInterceptor, i int, final SendHandler) SendHandler {
+	if i == len(chain) {
+		return final
+	}
+	return func(owner asyn_msg.RespOwner, req asyn_msg.ReqInf, cb asyn_msg.AsynCallback) {
+		chain[i](owner, req, cb, chainSend(chain, i+1, final))
+	}
+}
+
+func (this *interceptors) respChain(moduleId asyn_msg.AsynModuleId, final RespHandler) RespHandler {
+	this.lock.RLock()
+	var global, module = this.resp[AllModules], this.resp[moduleId]
+	if len(global) == 0 && len(module) == 0 {
+		this.lock.RUnlock()
+		return final
+	}
+	var chain = make([]RespInterceptor, 0, len(global)+len(module))
+	chain = append(append(chain, global...), module...)
+	this.lock.RUnlock()
+	return chainResp(chain, 0, final)
+}
+
+func chainResp(chain []RespInterceptor, i int, final RespHandler) RespHandler {
+	if i == len(chain) {
+		return final
+	}
+	return func(resp asyn_msg.RespInf) asyn_msg.AsynCBPtr {
+		return chain[i](resp, chainResp(chain, i+1, final))
+	}
+}
+
+// RejectReq 拦截器中拒绝请求，回调以RespError(err)异步执行
+func (this *AsynMgr) RejectReq(owner asyn_msg.RespOwner, req asyn_msg.ReqInf, cb asyn_msg.AsynCallback, err error) {
+	log.Warning("asyn req rejected, moduleId=%d, req=%v, err=%v", req.GetModuleId(), req, err)
+	if cb == nil {
+		return
+	}
+	this.putDetached(owner, &respDetached{
+		RespError: asyn_msg.NewRespError(req.GetModuleId(), req, err),
+		cb:        cb,
+	})
+}
+
+// LogReqInterceptor 记录发出的请求
+func LogReqInterceptor(owner asyn_msg.RespOwner, req asyn_msg.ReqInf, cb asyn_msg.AsynCallback, next SendHandler) {
+	log.Info("asyn send req, moduleId=%d, type=%s, req=%v", req.GetModuleId(), asyn_msg.TypeName(req), req)
+	next(owner, req, cb)
+}
+
+// ValidateInterceptor 请求实现Validator时检查，不通过的以ErrReqInvalid回调
+func ValidateInterceptor(owner asyn_msg.RespOwner, req asyn_msg.ReqInf, cb asyn_msg.AsynCallback, next SendHandler) {
+	if v, ok := req.(Validator); ok {
+		if err := v.Validate(); err != nil {
+			asynMgr.RejectReq(owner, req, cb, fmt.Errorf("%w: %v", ErrReqInvalid, err))
+			return
+		}
+	}
+	next(owner, req, cb)
+}
+
+// SlowRespInterceptor 回调耗时超过threshold时记录
+func SlowRespInterceptor(threshold time.Duration) RespInterceptor {
+	return func(resp asyn_msg.RespInf, next RespHandler) asyn_msg.AsynCBPtr {
+		var start = time.Now()
+		x := next(resp)
+		if cost := time.Since(start); cost >= threshold {
+			log.Warning("asyn slow callback, moduleId=%d, fcId=%d, resp=%s, cost=%v", resp.GetModuleId(), resp.GetFcId(), asyn_msg.TypeName(resp), cost)
+		}
+		return x
+	}
+}
@@ -0,0 +1,92 @@
+package asyn_mgr
+
+import (
+	"errors"
+	"px/shared/asyn_mgr/asyn_msg"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestInterceptorChain(t *testing.T) {
+	var handled int32
+	var module = newTestModule(asyn_msg.RedisModuleId, func(req asyn_msg.ReqInf) asyn_msg.RespInf {
+		atomic.AddInt32(&handled, 1)
+		return &testResp{Key: req.(*testReq).Key}
+	})
+	var mgr = newTestMgr(t, module)
+
+	var order []string
+	var errDenied = errors.New("denied")
+	var sendStep = func(name string) SendInterceptor {
+		return func(owner asyn_msg.RespOwner, req asyn_msg.ReqInf, cb asyn_msg.AsynCallback, next SendHandler) {
+			order = append(order, name)
+			if name == "module" && req.(*testReq).Key == "deny" {
+				mgr.RejectReq(owner, req, cb, errDenied)
+				return
+			}
+			next(owner, req, cb)
+		}
+	}
+	var respStep = func(name string) RespInterceptor {
+		return func(resp asyn_msg.RespInf, next RespHandler) asyn_msg.AsynCBPtr {
+			order = append(order, name)
+			if r, ok := resp.(*testResp); ok && r.Key == "swallow" {
+				return 0
+			}
+			return next(resp)
+		}
+	}
+	// 模块的先注册，验证全局的仍然先执行
+	mgr.AddSendInterceptor(asyn_msg.RedisModuleId, sendStep("module"))
+	mgr.AddSendInterceptor(AllModules, sendStep("global1"))
+	mgr.AddSendInterceptor(AllModules, sendStep("global2"))
+	mgr.AddSendInterceptor(asyn_msg.DbPoolModuleId, sendStep("other"))
+	mgr.AddSendInterceptor(asyn_msg.RedisModuleId, sendStep("module2"))
+	mgr.AddRespInterceptor(asyn_msg.RedisModuleId, respStep("resp_module"))
+	mgr.AddRespInterceptor(AllModules, respStep("resp_global"))
+
+	var call = func(key string) (asyn_msg.RespInf, bool) {
+		order = nil
+		var result asyn_msg.RespInf
+		mgr.SendReq(&testReq{Key: key}, func(resp asyn_msg.RespInf) asyn_msg.AsynCBPtr {
+			result = resp
+			return 0
+		})
+		var ok = handleOne(mgr, time.Second)
+		return result, ok
+	}
+
+	resp, ok := call("ok")
+	if !ok || resp.(*testResp).Key != "ok" {
+		t.Fatalf("resp=%+v", resp)
+	}
+	var want = []string{"global1", "global2", "module", "module2", "resp_global", "resp_module"}
+	if !reflect.DeepEqual(order, want) {
+		t.Errorf("order=%v, want %v", order, want)
+	}
+
+	// 拦截的请求不进入模块，之后的拦截器不执行，回调以RespError异步执行
+	resp, ok = call("deny")
+	if !ok || !errors.Is(asyn_msg.RespErr(resp), errDenied) {
+		t.Fatalf("denied resp=%+v", resp)
+	}
+	want = []string{"global1", "global2", "module", "resp_global", "resp_module"}
+	if !reflect.DeepEqual(order, want) {
+		t.Errorf("order=%v, want %v", order, want)
+	}
+	if n := atomic.LoadInt32(&handled); n != 1 {
+		t.Errorf("module handled=%d, want 1", n)
+	}
+
+	// 响应拦截器不调用next时回调不执行
+	resp, ok = call("swallow")
+	if !ok || resp != nil {
+		t.Errorf("swallowed resp=%+v, handled=%v", resp, ok)
+	}
+	want = []string{"global1", "global2", "module", "module2", "resp_global"}
+	if !reflect.DeepEqual(order, want) {
+		t.Errorf("order=%v, want %v", order, want)
+	}
+}
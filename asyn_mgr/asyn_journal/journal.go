@@ -0,0 +1,228 @@
+package asyn_journal
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"px/shared/asyn_mgr/asyn_msg"
+	"px/utils/chanx"
+	"time"
+
+	"gitlab.sunborngame.com/base/log"
+)
+
+const (
+	LogTag = "[asyn_journal]"
+
+	RecordChanCap   = 10240
+	FlushTick       = time.Second
+	DefaultMaxSize  = 256 << 20 // 单个文件大小上限
+	DefaultMaxFiles = 8         // 保留的历史文件数，path.1最新
+
+	KindReq     = "req"
+	KindResp    = "resp"
+	KindTimeout = "timeout" // RespTimeout
+	KindError   = "error"   // RespError，模块关闭、队列满等
+)
+
+// Record journal中的一行
+type Record struct {
+	Time     int64                 `json:"ts"` // UnixNano
+	Kind     string                `json:"kind"`
+	ModuleId asyn_msg.AsynModuleId `json:"module"`
+	FcId     uint64                `json:"fc_id"`
+	Type     string                `json:"type,omitempty"`
+	Trace    string                `json:"trace,omitempty"`
+	Body     json.RawMessage       `json:"body,omitempty"`
+	Err      string                `json:"err,omitempty"`
+}
+
+// Journal 实现asyn_msg.AsynHook，记录发出的请求和回调收到的响应，文件按大小滚动
+type Journal struct {
+	path     string
+	maxSize  int64
+	maxFiles int
+
+	file *os.File
+	w    *bufio.Writer
+	size int64
+
+	recordChan *chanx.UnboundedChan[[]byte]
+	stopChan   chan struct{}
+	doneChan   chan struct{}
+}
+
+// NewJournal maxSize<=0或maxFiles<=0时使用默认值
+func NewJournal(path string, maxSize int64, maxFiles int) (*Journal, error) {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxSize
+	}
+	if maxFiles <= 0 {
+		maxFiles = DefaultMaxFiles
+	}
+	var journal = &Journal{
+		path:       path,
+		maxSize:    maxSize,
+		maxFiles:   maxFiles,
+		recordChan: chanx.NewUnboundedChan[[]byte](RecordChanCap),
+		stopChan:   make(chan struct{}),
+		doneChan:   make(chan struct{}),
+	}
+	if err := journal.open(); err != nil {
+		return nil, err
+	}
+	go journal.loop()
+	return journal, nil
+}
+
+func (this *Journal) OnSend(req asyn_msg.ReqInf) {
+	this.put(&Record{
+		Time:     req.GetSendTime(),
+		Kind:     KindReq,
+		ModuleId: req.GetModuleId(),
+		FcId:     req.GetFcId(),
+	}, req)
+}
+
+func (this *Journal) OnDequeue(req asyn_msg.ReqInf, wait time.Duration) {
+}
+
+func (this *Journal) OnDone(req asyn_msg.ReqInf, resp asyn_msg.RespInf, stat *asyn_msg.AsynStat) {
+	var record = &Record{
+		Time:     resp.GetRespTime(),
+		Kind:     KindResp,
+		ModuleId: req.GetModuleId(),
+		FcId:     req.GetFcId(),
+	}
+	if record.Time == 0 {
+		record.Time = time.Now().UnixNano()
+	}
+	switch r := resp.(type) {
+	case *asyn_msg.RespTimeout:
+		record.Kind = KindTimeout
+		this.put(record, nil)
+	case *asyn_msg.RespError:
+		record.Kind = KindError
+		record.Err = r.Err.Error()
+		this.put(record, nil)
+	default:
+		this.put(record, resp)
+	}
+}
+
+// 在调用方goroutine中序列化，之后消息可能被修改
+func (this *Journal) put(record *Record, msg any) {
+	if msg != nil {
+		record.Type = asyn_msg.TypeName(msg)
+		body, err := json.Marshal(msg)
+		if err != nil {
+			record.Err = fmt.Sprintf("marshal: %v", err)
+		} else {
+			record.Body = body
+		}
+		if req, ok := msg.(asyn_msg.ReqInf); ok {
+			record.Trace = traceId(req.GetTrace())
+		} else if resp, ok := msg.(asyn_msg.RespInf); ok {
+			record.Trace = traceId(resp.GetTrace())
+		}
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		log.Error("%s marshal record err:%v, record=%+v", LogTag, err, record)
+		return
+	}
+	this.recordChan.Put(append(line, '\n'))
+}
+
+func traceId(ctx asyn_msg.TraceCtx) string {
+	if ctx.IsZero() {
+		return ""
+	}
+	return ctx.TraceId.String()
+}
+
+// Close 写入剩余记录后关闭文件
+func (this *Journal) Close() {
+	close(this.stopChan)
+	<-this.doneChan
+}
+
+func (this *Journal) loop() {
+	defer close(this.doneChan)
+
+	var ticker = time.NewTicker(FlushTick)
+	defer ticker.Stop()
+	for {
+		select {
+		case line := <-this.recordChan.C():
+			this.write(line)
+		case <-ticker.C:
+			if err := this.w.Flush(); err != nil {
+				log.Error("%s flush err:%v", LogTag, err)
+			}
+		case <-this.stopChan:
+			for this.recordChan.Len() > 0 {
+				this.write(<-this.recordChan.C())
+			}
+			if err := this.w.Flush(); err != nil {
+				log.Error("%s flush err:%v", LogTag, err)
+			}
+			if err := this.file.Close(); err != nil {
+				log.Error("%s close err:%v", LogTag, err)
+			}
+			return
+		}
+	}
+}
+
+func (this *Journal) write(line []byte) {
+	if this.size+int64(len(line)) > this.maxSize && this.size > 0 {
+		if err := this.rotate(); err != nil {
+			log.Error("%s rotate err:%v", LogTag, err)
+		}
+	}
+	n, err := this.w.Write(line)
+	this.size += int64(n)
+	if err != nil {
+		log.Error("%s write err:%v", LogTag, err)
+	}
+}
+
+func (this *Journal) open() error {
+	file, err := os.OpenFile(this.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	this.file = file
+	this.w = bufio.NewWriter(file)
+	this.size = info.Size()
+	return nil
+}
+
+// rotate path -> path.1 -> path.2 ...，超过maxFiles的删除
+func (this *Journal) rotate() error {
+	if err := this.w.Flush(); err != nil {
+		return err
+	}
+	if err := this.file.Close(); err != nil {
+		return err
+	}
+	os.Remove(RotatedPath(this.path, this.maxFiles))
+	for i := this.maxFiles - 1; i >= 1; i-- {
+		os.Rename(RotatedPath(this.path, i), RotatedPath(this.path, i+1))
+	}
+	if err := os.Rename(this.path, RotatedPath(this.path, 1)); err != nil {
+		return err
+	}
+	return this.open()
+}
+
+func RotatedPath(path string, i int) string {
+	return fmt.Sprintf("%s.%d", path, i)
+}
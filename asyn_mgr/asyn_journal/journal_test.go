@@ -0,0 +1,81 @@
+package asyn_journal
+
+import (
+	"os"
+	"path/filepath"
+	"px/shared/asyn_mgr/asyn_msg"
+	"testing"
+	"time"
+)
+
+type testGet struct {
+	asyn_msg.ReqBase
+	Key string
+}
+
+type testGetResp struct {
+	asyn_msg.RespBase
+	Value string
+}
+
+func (this *testGet) GetModuleId() asyn_msg.AsynModuleId {
+	return asyn_msg.RedisModuleId
+}
+
+func (this *testGetResp) GetModuleId() asyn_msg.AsynModuleId {
+	return asyn_msg.RedisModuleId
+}
+
+func TestJournal(t *testing.T) {
+	var path = filepath.Join(t.TempDir(), "asyn.journal")
+	// 每个文件只能放下几条记录，验证滚动
+	journal, err := NewJournal(path, 512, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 10; i++ {
+		var req = &testGet{Key: "k"}
+		req.SetFcId(uint64(i + 1))
+		req.SetSendTime(time.Now().UnixNano())
+		journal.OnSend(req)
+
+		var resp = &testGetResp{Value: "v"}
+		resp.SetFcId(req.GetFcId())
+		if i == 9 {
+			journal.OnDone(req, asyn_msg.NewRespTimeout(asyn_msg.RedisModuleId, req), &asyn_msg.AsynStat{})
+		} else {
+			journal.OnDone(req, resp, &asyn_msg.AsynStat{})
+		}
+	}
+	journal.Close()
+
+	if _, err := os.Stat(RotatedPath(path, 3)); err == nil {
+		t.Errorf("rotated file exceed maxFiles")
+	}
+	records, err := ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) == 0 || len(records) >= 20 {
+		t.Fatalf("records=%d, want rotated out some of 20", len(records))
+	}
+	var last = records[len(records)-1]
+	if last.Kind != KindTimeout || last.FcId != 10 {
+		t.Errorf("last record=%+v", last)
+	}
+
+	Register(&testGetResp{})
+	for _, record := range records {
+		if record.Kind != KindResp {
+			continue
+		}
+		msg, err := record.Decode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp, ok := msg.(*testGetResp); !ok || resp.Value != "v" {
+			t.Errorf("decode=%+v", msg)
+		}
+	}
+}
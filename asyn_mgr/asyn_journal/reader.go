@@ -0,0 +1,99 @@
+package asyn_journal
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"px/shared/asyn_mgr/asyn_msg"
+	"reflect"
+	"sync"
+)
+
+const (
+	MaxLineSize = 16 << 20
+)
+
+var (
+	ErrTypeNotRegistered = errors.New("journal msg type not registered")
+
+	typeLock sync.RWMutex
+	types    = make(map[string]reflect.Type)
+)
+
+// Register 注册可以从journal还原的消息类型，参数为消息指针，如&redis_proxy.RespGet{}
+func Register(msgs ...any) {
+	typeLock.Lock()
+	defer typeLock.Unlock()
+	for _, msg := range msgs {
+		var tp = reflect.TypeOf(msg)
+		if tp.Kind() == reflect.Ptr {
+			tp = tp.Elem()
+		}
+		types[asyn_msg.TypeName(msg)] = tp
+	}
+}
+
+// Decode 还原消息，返回消息指针
+func (this *Record) Decode() (any, error) {
+	typeLock.RLock()
+	tp, ok := types[this.Type]
+	typeLock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w, type=%s", ErrTypeNotRegistered, this.Type)
+	}
+	var msg = reflect.New(tp).Interface()
+	if len(this.Body) > 0 {
+		if err := json.Unmarshal(this.Body, msg); err != nil {
+			return nil, err
+		}
+	}
+	return msg, nil
+}
+
+// Read 按行读取所有记录
+func Read(r io.Reader) ([]*Record, error) {
+	var records []*Record
+	var scanner = bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64<<10), MaxLineSize)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var record = &Record{}
+		if err := json.Unmarshal(scanner.Bytes(), record); err != nil {
+			return records, fmt.Errorf("line %d: %w", len(records)+1, err)
+		}
+		records = append(records, record)
+	}
+	return records, scanner.Err()
+}
+
+// ReadFile 读取journal及其滚动文件，按时间从旧到新返回
+func ReadFile(path string) ([]*Record, error) {
+	var paths []string
+	for i := 1; ; i++ {
+		if _, err := os.Stat(RotatedPath(path, i)); err != nil {
+			break
+		}
+		paths = append([]string{RotatedPath(path, i)}, paths...)
+	}
+	paths = append(paths, path)
+
+	var records []*Record
+	for _, p := range paths {
+		file, err := os.Open(p)
+		if err != nil {
+			return records, err
+		}
+		rs, err := Read(file)
+		file.Close()
+		records = append(records, rs...)
+		if err != nil {
+			return records, fmt.Errorf("%s: %w", p, err)
+		}
+	}
+	return records, nil
+}
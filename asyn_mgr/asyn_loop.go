@@ -0,0 +1,38 @@
+package asyn_mgr
+
+import (
+	"px/shared/asyn_mgr/asyn_msg"
+	"px/utils/chanx"
+)
+
+// AsynLoop 主逻辑以外的goroutine（场景、登录等）发请求时使用
+// 通过AsynLoop发出的请求，响应只会投递到该loop的Resp()，需在该goroutine中调用HandleResp
+type AsynLoop struct {
+	respChan *chanx.UnboundedChan[asyn_msg.RespInf]
+}
+
+func NewAsynLoop() *AsynLoop {
+	return &AsynLoop{
+		respChan: chanx.NewUnboundedChan[asyn_msg.RespInf](MessageChanCap),
+	}
+}
+
+func (this *AsynLoop) PutResp(resp asyn_msg.RespInf) {
+	this.respChan.Put(resp)
+}
+
+func (this *AsynLoop) Resp() <-chan asyn_msg.RespInf {
+	return this.respChan.C()
+}
+
+func (this *AsynLoop) RespLen() int {
+	return this.respChan.Len()
+}
+
+func (this *AsynLoop) SendReq(req asyn_msg.ReqInf, cb asyn_msg.AsynCallback) {
+	asynMgr.SendReqFrom(this, req, cb)
+}
+
+func (this *AsynLoop) HandleResp(resp asyn_msg.RespInf) asyn_msg.AsynCBPtr {
+	return asynMgr.HandleResp(resp)
+}
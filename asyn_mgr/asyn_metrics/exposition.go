@@ -0,0 +1,104 @@
+package asyn_metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"px/shared/asyn_mgr/asyn_msg"
+	"sort"
+	"strconv"
+
+	"gitlab.sunborngame.com/base/log"
+)
+
+// WritePrometheus 按Prometheus文本格式输出
+func (this *Metrics) WritePrometheus(w io.Writer) error {
+	var bw = bufio.NewWriter(w)
+
+	this.lock.Lock()
+	for _, name := range []string{MetricSent, MetricCompleted, MetricErrors} {
+		counter, ok := this.counters[name]
+		if !ok {
+			continue
+		}
+		writeHead(bw, name, "counter")
+		for _, key := range sortedKeys(counter) {
+			fmt.Fprintf(bw, "%s{%s} %s\n", name, labels(key), formatFloat(counter[key]))
+		}
+	}
+	for _, name := range []string{MetricQueueWait, MetricExec, MetricCallback} {
+		hists, ok := this.histograms[name]
+		if !ok {
+			continue
+		}
+		writeHead(bw, name, "histogram")
+		for _, key := range sortedKeys(hists) {
+			var h = hists[key]
+			var cumulative uint64
+			for i, bound := range this.buckets {
+				cumulative += h.counts[i]
+				fmt.Fprintf(bw, "%s_bucket{%s,le=\"%s\"} %d\n", name, labels(key), formatFloat(bound), cumulative)
+			}
+			fmt.Fprintf(bw, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels(key), h.count)
+			fmt.Fprintf(bw, "%s_sum{%s} %s\n", name, labels(key), formatFloat(h.sum))
+			fmt.Fprintf(bw, "%s_count{%s} %d\n", name, labels(key), h.count)
+		}
+	}
+	var fc = this.gaugeFunc
+	this.lock.Unlock()
+
+	if fc != nil {
+		var gauges = fc()
+		var moduleIds = make([]asyn_msg.AsynModuleId, 0, len(gauges))
+		for moduleId := range gauges {
+			moduleIds = append(moduleIds, moduleId)
+		}
+		sort.Slice(moduleIds, func(i, j int) bool { return moduleIds[i] < moduleIds[j] })
+
+		writeHead(bw, MetricDepth, "gauge")
+		for _, moduleId := range moduleIds {
+			fmt.Fprintf(bw, "%s{module=\"%s\"} %d\n", MetricDepth, moduleLabel(moduleId), gauges[moduleId].Depth)
+		}
+		writeHead(bw, MetricLaneDepth, "gauge")
+		for _, moduleId := range moduleIds {
+			for lane, depth := range gauges[moduleId].LaneDepth {
+				fmt.Fprintf(bw, "%s{module=\"%s\",priority=\"%s\"} %d\n", MetricLaneDepth, moduleLabel(moduleId), asyn_msg.LanePriority(lane), depth)
+			}
+		}
+		writeHead(bw, MetricPending, "gauge")
+		for _, moduleId := range moduleIds {
+			fmt.Fprintf(bw, "%s{module=\"%s\"} %d\n", MetricPending, moduleLabel(moduleId), gauges[moduleId].Pending)
+		}
+	}
+
+	return bw.Flush()
+}
+
+func (this *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if err := this.WritePrometheus(w); err != nil {
+		log.Error("%s write metrics err:%v", LogTag, err)
+	}
+}
+
+// Serve 在本地地址上提供/metrics，阻塞直到出错
+func (this *Metrics) Serve(addr string) error {
+	var mux = http.NewServeMux()
+	mux.Handle("/metrics", this)
+	log.Info("%s serve metrics on %s/metrics", LogTag, addr)
+	return http.ListenAndServe(addr, mux)
+}
+
+func writeHead(w io.Writer, name string, tp string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, metricHelps[name])
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, tp)
+}
+
+func labels(key labelKey) string {
+	return fmt.Sprintf("module=\"%s\",req=%s", moduleLabel(key.moduleId), strconv.Quote(key.reqType))
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,203 @@
+package asyn_metrics
+
+import (
+	"px/shared/asyn_mgr/asyn_msg"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	LogTag = "[asyn_metrics]"
+
+	MetricSent      = "asyn_requests_sent_total"
+	MetricCompleted = "asyn_requests_completed_total"
+	MetricErrors    = "asyn_requests_errors_total"
+	MetricQueueWait = "asyn_queue_wait_seconds"
+	MetricExec      = "asyn_exec_seconds"
+	MetricCallback  = "asyn_callback_seconds"
+	MetricDepth     = "asyn_queue_depth"
+	MetricLaneDepth = "asyn_queue_lane_depth"
+	MetricPending   = "asyn_pending_callbacks"
+)
+
+// 秒
+var defaultBuckets = []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5, 10}
+
+var metricHelps = map[string]string{
+	MetricSent:      "Async requests sent.",
+	MetricCompleted: "Async requests whose callback has run.",
+	MetricErrors:    "Async requests completed with an error, timeout included.",
+	MetricQueueWait: "Time a request waited in the module request queue.",
+	MetricExec:      "Time from dequeue to the module producing a response.",
+	MetricCallback:  "Time spent in the callback.",
+	MetricDepth:     "Module request queue depth.",
+	MetricLaneDepth: "Module request queue depth by priority.",
+	MetricPending:   "Module callbacks waiting for a response.",
+}
+
+type labelKey struct {
+	moduleId asyn_msg.AsynModuleId
+	reqType  string
+}
+
+type histogram struct {
+	counts []uint64 // 与buckets一一对应，非累计
+	count  uint64
+	sum    float64
+}
+
+// ModuleGauge 抓取时获取的模块实时数据
+type ModuleGauge struct {
+	Depth     int
+	LaneDepth [asyn_msg.ReqLaneCount]int // 按lane，见asyn_msg.LanePriority
+	Pending   int
+}
+
+// Metrics 实现asyn_msg.AsynHook，多个goroutine并发写入
+type Metrics struct {
+	lock       sync.Mutex
+	buckets    []float64
+	counters   map[string]map[labelKey]float64
+	histograms map[string]map[labelKey]*histogram
+
+	gaugeFunc func() map[asyn_msg.AsynModuleId]ModuleGauge
+}
+
+var metrics = NewMetrics()
+
+func GetMetrics() *Metrics {
+	return metrics
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		buckets:    defaultBuckets,
+		counters:   make(map[string]map[labelKey]float64),
+		histograms: make(map[string]map[labelKey]*histogram),
+	}
+}
+
+// SetGaugeFunc 设置队列深度等实时数据来源
+func (this *Metrics) SetGaugeFunc(fc func() map[asyn_msg.AsynModuleId]ModuleGauge) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.gaugeFunc = fc
+}
+
+func (this *Metrics) OnSend(req asyn_msg.ReqInf) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.incCounter(MetricSent, labelKey{req.GetModuleId(), asyn_msg.TypeName(req)}, 1)
+}
+
+func (this *Metrics) OnDequeue(req asyn_msg.ReqInf, wait time.Duration) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.observe(MetricQueueWait, labelKey{req.GetModuleId(), asyn_msg.TypeName(req)}, wait)
+}
+
+func (this *Metrics) OnDone(req asyn_msg.ReqInf, resp asyn_msg.RespInf, stat *asyn_msg.AsynStat) {
+	var key = labelKey{req.GetModuleId(), asyn_msg.TypeName(req)}
+	var err = asyn_msg.RespErr(resp)
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.incCounter(MetricCompleted, key, 1)
+	if err != nil {
+		this.incCounter(MetricErrors, key, 1)
+	}
+	if stat.Exec > 0 {
+		this.observe(MetricExec, key, stat.Exec)
+	}
+	this.observe(MetricCallback, key, stat.Callback)
+}
+
+// 需持有lock
+func (this *Metrics) incCounter(name string, key labelKey, v float64) {
+	counter, ok := this.counters[name]
+	if !ok {
+		counter = make(map[labelKey]float64)
+		this.counters[name] = counter
+	}
+	counter[key] += v
+}
+
+// 需持有lock
+func (this *Metrics) observe(name string, key labelKey, d time.Duration) {
+	hists, ok := this.histograms[name]
+	if !ok {
+		hists = make(map[labelKey]*histogram)
+		this.histograms[name] = hists
+	}
+	h, ok := hists[key]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(this.buckets))}
+		hists[key] = h
+	}
+
+	var v = d.Seconds()
+	for i, bound := range this.buckets {
+		if v <= bound {
+			h.counts[i]++
+			break
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+// CounterValue 供测试读取计数
+func (this *Metrics) CounterValue(name string, moduleId asyn_msg.AsynModuleId, reqType string) float64 {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.counters[name][labelKey{moduleId, reqType}]
+}
+
+// HistogramValue 供测试读取直方图的样本数和总和(秒)
+func (this *Metrics) HistogramValue(name string, moduleId asyn_msg.AsynModuleId, reqType string) (count uint64, sum float64) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	h, ok := this.histograms[name][labelKey{moduleId, reqType}]
+	if !ok {
+		return 0, 0
+	}
+	return h.count, h.sum
+}
+
+// GaugeValue 供测试读取模块实时数据
+func (this *Metrics) GaugeValue(moduleId asyn_msg.AsynModuleId) ModuleGauge {
+	this.lock.Lock()
+	var fc = this.gaugeFunc
+	this.lock.Unlock()
+	if fc == nil {
+		return ModuleGauge{}
+	}
+	return fc()[moduleId]
+}
+
+func (this *Metrics) Reset() {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.counters = make(map[string]map[labelKey]float64)
+	this.histograms = make(map[string]map[labelKey]*histogram)
+}
+
+func sortedKeys[V any](m map[labelKey]V) []labelKey {
+	var keys = make([]labelKey, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].moduleId != keys[j].moduleId {
+			return keys[i].moduleId < keys[j].moduleId
+		}
+		return keys[i].reqType < keys[j].reqType
+	})
+	return keys
+}
+
+func moduleLabel(moduleId asyn_msg.AsynModuleId) string {
+	return strconv.FormatInt(int64(moduleId), 10)
+}
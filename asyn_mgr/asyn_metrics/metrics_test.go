@@ -0,0 +1,76 @@
+package asyn_metrics
+
+import (
+	"bytes"
+	"px/shared/asyn_mgr/asyn_msg"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testReq struct {
+	asyn_msg.ReqBase
+}
+
+type testResp struct {
+	asyn_msg.RespBase
+	Err string
+}
+
+func (this *testReq) GetModuleId() asyn_msg.AsynModuleId {
+	return asyn_msg.RedisModuleId
+}
+
+func (this *testResp) GetModuleId() asyn_msg.AsynModuleId {
+	return asyn_msg.RedisModuleId
+}
+
+func TestMetrics(t *testing.T) {
+	var m = NewMetrics()
+	m.SetGaugeFunc(func() map[asyn_msg.AsynModuleId]ModuleGauge {
+		return map[asyn_msg.AsynModuleId]ModuleGauge{asyn_msg.RedisModuleId: {Depth: 3, LaneDepth: [asyn_msg.ReqLaneCount]int{1, 2, 0}, Pending: 2}}
+	})
+
+	var req = &testReq{}
+	m.OnSend(req)
+	m.OnSend(req)
+	m.OnDequeue(req, 2*time.Millisecond)
+	m.OnDone(req, &testResp{}, &asyn_msg.AsynStat{Exec: time.Millisecond, Callback: time.Millisecond})
+	m.OnDone(req, &testResp{Err: "redis: nil"}, &asyn_msg.AsynStat{Callback: time.Millisecond})
+
+	if v := m.CounterValue(MetricSent, asyn_msg.RedisModuleId, "testReq"); v != 2 {
+		t.Errorf("sent=%v, want 2", v)
+	}
+	if v := m.CounterValue(MetricCompleted, asyn_msg.RedisModuleId, "testReq"); v != 2 {
+		t.Errorf("completed=%v, want 2", v)
+	}
+	if v := m.CounterValue(MetricErrors, asyn_msg.RedisModuleId, "testReq"); v != 1 {
+		t.Errorf("errors=%v, want 1", v)
+	}
+	if count, _ := m.HistogramValue(MetricExec, asyn_msg.RedisModuleId, "testReq"); count != 1 {
+		t.Errorf("exec count=%v, want 1", count)
+	}
+	if count, _ := m.HistogramValue(MetricCallback, asyn_msg.RedisModuleId, "testReq"); count != 2 {
+		t.Errorf("callback count=%v, want 2", count)
+	}
+	if g := m.GaugeValue(asyn_msg.RedisModuleId); g.Depth != 3 || g.Pending != 2 {
+		t.Errorf("gauge=%+v", g)
+	}
+
+	var buf bytes.Buffer
+	if err := m.WritePrometheus(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		`asyn_requests_sent_total{module="8",req="testReq"} 2`,
+		`asyn_queue_wait_seconds_bucket{module="8",req="testReq",le="0.005"} 1`,
+		`asyn_queue_wait_seconds_count{module="8",req="testReq"} 1`,
+		`asyn_queue_depth{module="8"} 3`,
+		`asyn_queue_lane_depth{module="8",priority="high"} 1`,
+		`asyn_queue_lane_depth{module="8",priority="low"} 0`,
+	} {
+		if !strings.Contains(buf.String(), line) {
+			t.Errorf("exposition missing %q\n%s", line, buf.String())
+		}
+	}
+}
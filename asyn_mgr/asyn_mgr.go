@@ -1,8 +1,17 @@
 package asyn_mgr
 
 import (
+	"errors"
+	"fmt"
+	"px/framebase"
+	"px/shared/asyn_mgr/asyn_journal"
+	"px/shared/asyn_mgr/asyn_metrics"
 	"px/shared/asyn_mgr/asyn_msg"
+	"px/shared/asyn_mgr/asyn_trace"
+	"px/utils"
 	"px/utils/chanx"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"gitlab.sunborngame.com/base/log"
@@ -11,61 +20,311 @@ import (
 type AsynMgr struct {
 	fcId        uint64
 	respChan    *chanx.UnboundedChan[asyn_msg.RespInf]
-	modules     map[asyn_msg.AsynModuleId]asyn_msg.AsynModInf
 	respMsgChan *chanx.UnboundedChan[asyn_msg.RespMsgInf]
+
+	// Init之后模块可以增删、重启，需加锁
+	lock    sync.RWMutex
+	modules map[asyn_msg.AsynModuleId]asyn_msg.AsynModInf
+	inited  bool
+
+	closing  int32
+	stopChan chan struct{}
+
+	profiler     *CBProfiler
+	metrics      *asyn_metrics.Metrics
+	supervisor   *supervisor
+	tracer       *asyn_trace.Tracer
+	journal      *asyn_journal.Journal
+	interceptors *interceptors
+	retrier      *retrier
+	coalescer    *coalescer
+	breakers     *breakers
+	dispatcher   *msgDispatcher
+}
+
+var asynMgr = newAsynMgr()
+
+func newAsynMgr() *AsynMgr {
+	return &AsynMgr{
+		modules:      make(map[asyn_msg.AsynModuleId]asyn_msg.AsynModInf),
+		respChan:     chanx.NewUnboundedChan[asyn_msg.RespInf](MessageChanCap),
+		respMsgChan:  chanx.NewUnboundedChan[asyn_msg.RespMsgInf](MessageChanCap),
+		stopChan:     make(chan struct{}),
+		supervisor:   newSupervisor(),
+		interceptors: newInterceptors(),
+		retrier:      newRetrier(),
+		coalescer:    newCoalescer(),
+		breakers:     newBreakers(),
+		dispatcher:   newMsgDispatcher(),
+	}
 }
 
-var asynMgr = &AsynMgr{
-	modules:     make(map[asyn_msg.AsynModuleId]asyn_msg.AsynModInf),
-	respChan:    chanx.NewUnboundedChan[asyn_msg.RespInf](MessageChanCap),
-	respMsgChan: chanx.NewUnboundedChan[asyn_msg.RespMsgInf](MessageChanCap),
+// NewAsynMgr 独立的管理器，用于测试，Send等全局函数使用GetAsynMgr()，需用SendVia等发送
+func NewAsynMgr() *AsynMgr {
+	return newAsynMgr()
 }
 
 func GetAsynMgr() *AsynMgr {
 	return asynMgr
 }
 
-func (this *AsynMgr) RegisterAsynModule(modules ...asyn_msg.AsynModInf) {
+// RegisterAsynModule Init之前注册，Init之后使用AddModule，ID重复的模块不注册并返回ErrModuleExist
+func (this *AsynMgr) RegisterAsynModule(modules ...asyn_msg.AsynModInf) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	var errs []error
 	for _, module := range modules {
-		this.modules[module.GetModuleId()] = module
+		var moduleId = module.GetModuleId()
+		if old, ok := this.modules[moduleId]; ok {
+			var err = fmt.Errorf("%w, moduleId=%d(%s), registered=%T, new=%T", ErrModuleExist, moduleId, asyn_msg.ModuleName(moduleId), old, module)
+			log.Error("register module failed, err=%v", err)
+			errs = append(errs, err)
+			continue
+		}
+		this.modules[moduleId] = module
 	}
+	return errors.Join(errs...)
 }
 
 func (this *AsynMgr) Init() {
-	for _, module := range this.modules {
-		log.Info("init modules %v", module.GetModuleId())
+	this.lock.Lock()
+	this.inited = true
+	this.lock.Unlock()
+
+	for _, module := range this.allModules() {
+		var name = asyn_msg.ModuleName(module.GetModuleId())
+		if name == "" {
+			log.Warning("module id not registered, moduleId=%d, module=%T", module.GetModuleId(), module)
+		}
+		log.Info("init modules %v(%s)", module.GetModuleId(), name)
 		module.Init()
 
 		go this.loop(module)
 	}
+
+	go this.timeoutLoop()
+	go this.watchdogLoop()
+}
+
+func (this *AsynMgr) getModule(moduleId asyn_msg.AsynModuleId) (asyn_msg.AsynModInf, bool) {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	module, ok := this.modules[moduleId]
+	return module, ok
+}
+
+func (this *AsynMgr) allModules() map[asyn_msg.AsynModuleId]asyn_msg.AsynModInf {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	var modules = make(map[asyn_msg.AsynModuleId]asyn_msg.AsynModInf, len(this.modules))
+	for moduleId, module := range this.modules {
+		modules[moduleId] = module
+	}
+	return modules
+}
+
+// 模块已被移除或替换
+func (this *AsynMgr) isRetired(module asyn_msg.AsynModInf) bool {
+	current, ok := this.getModule(module.GetModuleId())
+	return !ok || current != module
 }
 
 func (this *AsynMgr) Start() {
 
 }
 
+// EnableMetrics 启动时调用，addr非空时在本地提供Prometheus抓取
+func (this *AsynMgr) EnableMetrics(addr string) {
+	if this.metrics != nil {
+		log.Warning("AsynMgr metrics already enabled")
+		return
+	}
+	var metrics = asyn_metrics.GetMetrics()
+	this.metrics = metrics
+	metrics.SetGaugeFunc(func() map[asyn_msg.AsynModuleId]asyn_metrics.ModuleGauge {
+		var modules = this.allModules()
+		var gauges = make(map[asyn_msg.AsynModuleId]asyn_metrics.ModuleGauge, len(modules))
+		for moduleId, module := range modules {
+			var gauge = asyn_metrics.ModuleGauge{
+				Depth:   module.ReqLen(),
+				Pending: module.CallBackLen(),
+			}
+			for lane := range gauge.LaneDepth {
+				gauge.LaneDepth[lane] = module.GetAsynBase().ReqLaneLen(asyn_msg.LanePriority(lane))
+			}
+			gauges[moduleId] = gauge
+		}
+		return gauges
+	})
+	asyn_msg.AddHook(metrics)
+
+	if addr == "" {
+		return
+	}
+	go func() {
+		if err := metrics.Serve(addr); err != nil {
+			log.Error("serve metrics failed, addr=%s, err=%v", addr, err)
+		}
+	}()
+}
+
+// EnableTrace 启动时调用，请求自动携带追踪上下文，span导出到exporter
+func (this *AsynMgr) EnableTrace(exporter asyn_trace.Exporter) *asyn_trace.Tracer {
+	if this.tracer != nil {
+		return this.tracer
+	}
+	this.tracer = asyn_trace.NewTracer(exporter)
+	asyn_msg.AddHook(this.tracer)
+	asyn_msg.EnableTrace()
+	return this.tracer
+}
+
+// GetTracer 未开启时返回nil
+func (this *AsynMgr) GetTracer() *asyn_trace.Tracer {
+	return this.tracer
+}
+
+// EnableJournal 启动时调用，记录所有请求和响应，用于asyn_fake.Replay复现
+func (this *AsynMgr) EnableJournal(path string, maxSize int64, maxFiles int) (*asyn_journal.Journal, error) {
+	if this.journal != nil {
+		return this.journal, nil
+	}
+	journal, err := asyn_journal.NewJournal(path, maxSize, maxFiles)
+	if err != nil {
+		return nil, err
+	}
+	this.journal = journal
+	asyn_msg.AddHook(journal)
+	return journal, nil
+}
+
+// GetJournal 未开启时返回nil
+func (this *AsynMgr) GetJournal() *asyn_journal.Journal {
+	return this.journal
+}
+
 func (this *AsynMgr) OnClose() {
-	for _, module := range this.modules {
+	this.Shutdown(ShutdownTimeout)
+}
+
+// Shutdown 主逻辑goroutine调用
+// 1. 所有模块停止接收新请求
+// 2. 在timeout内等待已发出的请求完成，期间到达的响应正常执行回调
+// 3. 执行已到达但还未处理的响应
+// 4. 关闭模块，返回各模块未完成回调的fcId
+func (this *AsynMgr) Shutdown(timeout time.Duration) map[asyn_msg.AsynModuleId][]uint64 {
+	if !atomic.CompareAndSwapInt32(&this.closing, 0, 1) {
+		log.Warning("AsynMgr already shutdown")
+		return nil
+	}
+
+	for _, module := range this.allModules() {
+		module.StopAccept()
+	}
+
+	var deadline = time.NewTimer(timeout)
+	defer deadline.Stop()
+	var ticker = time.NewTicker(TimeoutTick)
+	defer ticker.Stop()
+drain:
+	for this.pendingLen() > 0 {
+		select {
+		case resp := <-this.respChan.C():
+			this.HandleResp(resp)
+		case <-ticker.C:
+		case <-deadline.C:
+			break drain
+		}
+	}
+
+	for flush := true; flush; {
+		select {
+		case resp := <-this.respChan.C():
+			this.HandleResp(resp)
+		default:
+			flush = false
+		}
+	}
+
+	var abandoned = make(map[asyn_msg.AsynModuleId][]uint64)
+	for moduleId, module := range this.allModules() {
+		if fcIds := module.PendingFcIds(); len(fcIds) > 0 {
+			abandoned[moduleId] = fcIds
+			log.Error("AsynMgr shutdown abandoned, moduleId=%d, count=%d, fcIds=%v", moduleId, len(fcIds), fcIds)
+		}
 		module.Close()
 	}
+	close(this.stopChan)
+	if this.tracer != nil {
+		this.tracer.Close()
+	}
+	if this.journal != nil {
+		this.journal.Close()
+	}
+
+	return abandoned
+}
+
+// 包括等待重试的请求
+func (this *AsynMgr) pendingLen() int {
+	l := this.retrier.waitingLen()
+	for _, module := range this.allModules() {
+		l += module.CallBackLen()
+	}
+	return l
 }
 
 func (this *AsynMgr) Resp() <-chan asyn_msg.RespInf {
 	return this.respChan.C()
 }
 
+// RespMsg 推送给客户端的消息，StartMsgDispatch之后由内置goroutine消费
 func (this *AsynMgr) RespMsg() <-chan asyn_msg.RespMsgInf {
 	return this.respMsgChan.C()
 }
 
 func (this *AsynMgr) ReqLen() int {
 	l := 0
-	for _, module := range this.modules {
+	for _, module := range this.allModules() {
 		l += module.ReqLen()
 	}
 	return l
 }
 
+// QueueDepth 各模块请求队列的实时深度
+func (this *AsynMgr) QueueDepth() map[asyn_msg.AsynModuleId]int {
+	var modules = this.allModules()
+	var depth = make(map[asyn_msg.AsynModuleId]int, len(modules))
+	for moduleId, module := range modules {
+		depth[moduleId] = module.ReqLen()
+	}
+	return depth
+}
+
+// QueueLaneDepth 模块请求队列各lane的实时深度，下标见asyn_msg.LanePriority
+func (this *AsynMgr) QueueLaneDepth(moduleId asyn_msg.AsynModuleId) [asyn_msg.ReqLaneCount]int {
+	var depth [asyn_msg.ReqLaneCount]int
+	module, ok := this.getModule(moduleId)
+	if !ok {
+		return depth
+	}
+	for lane := range depth {
+		depth[lane] = module.GetAsynBase().ReqLaneLen(asyn_msg.LanePriority(lane))
+	}
+	return depth
+}
+
+// SetQueueOpt 设置模块请求队列的容量和溢出策略
+func (this *AsynMgr) SetQueueOpt(moduleId asyn_msg.AsynModuleId, capacity int, policy asyn_msg.QueuePolicy) bool {
+	module, ok := this.GetASynModule(moduleId)
+	if !ok {
+		return false
+	}
+	module.SetReqQueueOpt(capacity, policy)
+	log.Info("set queue opt, moduleId=%d, capacity=%d, policy=%d", moduleId, capacity, policy)
+	return true
+}
+
 func (this *AsynMgr) RespLen() int {
 	return this.respChan.Len()
 }
@@ -83,25 +342,86 @@ func (this *AsynMgr) loop(module asyn_msg.AsynModInf) {
 			case *asyn_msg.RespMsg:
 				this.respMsgChan.Put(respMsg)
 			default:
-				this.respChan.Put(respMsg)
+				if this.isRetired(module) {
+					// 模块移除或重启时回调已失败，迟到的响应丢弃
+					log.Warning("drop resp of retired module, moduleId=%d, fcId=%d", module.GetModuleId(), respMsg.GetFcId())
+					continue
+				}
+				// 响应投递回发请求的goroutine
+				if owner := module.GetRespOwner(respMsg.GetFcId()); owner != nil {
+					owner.PutResp(respMsg)
+				} else {
+					this.respChan.Put(respMsg)
+				}
 			}
+		case <-module.Done():
+			return
+		}
+	}
+}
+
+// 所有模块共用一个超时检查，超时响应走模块自己的Resp()
+func (this *AsynMgr) timeoutLoop() {
+	defer utils.Recover(framebase.SendWeChatMsg(), framebase.IsReleaseEnv())
+
+	var ticker = time.NewTicker(TimeoutTick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			var now = utils.NowUnixMilli()
+			for _, module := range this.allModules() {
+				module.CheckTimeout(now)
+			}
+		case <-this.stopChan:
+			return
 		}
 	}
 }
 
 func (this *AsynMgr) SendReq(req asyn_msg.ReqInf, cb asyn_msg.AsynCallback) {
-	module, ok := this.modules[req.GetModuleId()]
+	this.SendReqFrom(nil, req, cb)
+}
+
+// SendReqFrom 可在任意goroutine调用，响应投递给owner，owner为nil时投递到Resp()
+func (this *AsynMgr) SendReqFrom(owner asyn_msg.RespOwner, req asyn_msg.ReqInf, cb asyn_msg.AsynCallback) {
+	this.interceptors.sendChain(req.GetModuleId(), this.sendToModule)(owner, req, cb)
+}
+
+func (this *AsynMgr) sendToModule(owner asyn_msg.RespOwner, req asyn_msg.ReqInf, cb asyn_msg.AsynCallback) {
+	module, ok := this.getModule(req.GetModuleId())
 	if !ok {
-		log.Error("not found modules, req=%v", req)
+		var err = fmt.Errorf("%w, moduleId=%d(%s)", ErrModuleNotFound, req.GetModuleId(), asyn_msg.ModuleName(req.GetModuleId()))
+		log.Error("not found modules, err=%v, req=%v", err, req)
+		if cb != nil {
+			this.putDetached(owner, &respDetached{
+				RespError: asyn_msg.NewRespError(req.GetModuleId(), req, err),
+				cb:        cb,
+			})
+		}
 		return
 	}
-	module.SendReq(req, cb)
+	// 不持锁发送，队列满时发送方可能阻塞
+	module.SendReqFrom(owner, req, cb)
+	if cb == nil || !this.isRetired(module) {
+		return
+	}
+
+	// 发送期间模块被移除或重启，旧实例已取出回调的由移除/重启处理，剩下的在这里失败
+	if fc, ok := module.GetAsynBase().TakeCallBack(req.GetFcId()); ok {
+		var err = ErrModuleRemoved
+		if _, ok := this.getModule(req.GetModuleId()); ok {
+			err = ErrModuleRestarted
+		}
+		log.Warning("module retired while sending, moduleId=%d, fcId=%d, err=%v", req.GetModuleId(), req.GetFcId(), err)
+		this.failDetached(req.GetModuleId(), []*asyn_msg.AsynFc{fc}, err)
+	}
 }
 
 func (this *AsynMgr) GetASynModule(moduleId asyn_msg.AsynModuleId) (asyn_msg.AsynModInf, bool) {
-	module, ok := this.modules[moduleId]
+	module, ok := this.getModule(moduleId)
 	if !ok {
-		log.Error("not found modules, moduleId=%d, all modules=%v", moduleId, this.modules)
+		log.Error("not found modules, moduleId=%d, all modules=%v", moduleId, this.allModules())
 		return nil, false
 	}
 
@@ -109,17 +429,49 @@ func (this *AsynMgr) GetASynModule(moduleId asyn_msg.AsynModuleId) (asyn_msg.Asy
 }
 
 func (this *AsynMgr) HandleResp(respInf asyn_msg.RespInf) asyn_msg.AsynCBPtr {
-	start := time.Now().UnixMilli()
-	module, ok := this.modules[respInf.GetModuleId()]
+	start := time.Now()
+	cb := this.interceptors.respChain(respInf.GetModuleId(), this.handleResp)(respInf)
+
+	cost := time.Since(start)
+	if this.profiler != nil {
+		this.profiler.Record(cb, cost)
+	}
+	if cost >= time.Millisecond {
+		log.Info("HandleResp:%d modules:%d cost:%dms", respInf.GetFcId(), int64(respInf.GetModuleId()), cost.Milliseconds())
+	}
+	return cb
+}
+
+func (this *AsynMgr) handleResp(respInf asyn_msg.RespInf) asyn_msg.AsynCBPtr {
+	switch resp := respInf.(type) {
+	case *respDetached:
+		return resp.handle()
+	case *respCoalesced:
+		return resp.handle()
+	case *respBreakerEvent:
+		this.breakers.notify(resp.BreakerEvent)
+		return 0
+	case *respMsgFailure:
+		this.dispatcher.notify(resp.MsgDeliveryFailure)
+		return 0
+	}
+	module, ok := this.getModule(respInf.GetModuleId())
 	if !ok {
 		log.Error("modules not found, moduleId=%v", respInf.GetModuleId())
 		return 0
 	}
-	cb := module.HandleResp(respInf)
+	return module.HandleResp(respInf)
+}
 
-	end := time.Now().UnixMilli()
-	if end-start > 0 {
-		log.Info("HandleResp:%d modules:%d cost:%dms", respInf.GetFcId(), int64(respInf.GetModuleId()), end-start)
+// EnableProfiler 启动时调用，按回调统计HandleResp耗时
+func (this *AsynMgr) EnableProfiler() *CBProfiler {
+	if this.profiler == nil {
+		this.profiler = NewCBProfiler()
 	}
-	return cb
+	return this.profiler
+}
+
+// GetProfiler 未开启时返回nil
+func (this *AsynMgr) GetProfiler() *CBProfiler {
+	return this.profiler
 }
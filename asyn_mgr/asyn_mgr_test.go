@@ -0,0 +1,221 @@
+package asyn_mgr
+
+import (
+	"errors"
+	"px/shared/asyn_mgr/asyn_msg"
+	"testing"
+	"time"
+)
+
+// go test -race ./shared/asyn_mgr/
+
+type testResp struct {
+	asyn_msg.RespBase
+	Key string
+}
+
+func (this *testResp) GetModuleId() asyn_msg.AsynModuleId {
+	return asyn_msg.RedisModuleId
+}
+
+// 测试模块，handle返回nil时不响应
+type testModule struct {
+	*asyn_msg.AsynBase
+	moduleId asyn_msg.AsynModuleId
+	handle   func(req asyn_msg.ReqInf) asyn_msg.RespInf
+}
+
+func newTestModule(moduleId asyn_msg.AsynModuleId, handle func(req asyn_msg.ReqInf) asyn_msg.RespInf) *testModule {
+	return &testModule{
+		AsynBase: asyn_msg.NewAsynBase(),
+		moduleId: moduleId,
+		handle:   handle,
+	}
+}
+
+func (this *testModule) GetModuleId() asyn_msg.AsynModuleId {
+	return this.moduleId
+}
+
+func (this *testModule) Init() {
+	this.Go(this.loop)
+}
+
+func (this *testModule) Close() {
+	this.Stop()
+}
+
+func (this *testModule) loop() {
+	for {
+		select {
+		case req := <-this.ReqChan.C():
+			this.Process(req, this.handleReq)
+		case <-this.Done():
+			return
+		}
+	}
+}
+
+func (this *testModule) handleReq(req asyn_msg.ReqInf) {
+	if resp := this.handle(req); resp != nil {
+		resp.SetFcId(req.GetFcId())
+		this.RespChan.Put(resp)
+	}
+}
+
+func newTestMgr(t *testing.T, modules ...asyn_msg.AsynModInf) *AsynMgr {
+	var mgr = newAsynMgr()
+	if err := mgr.RegisterAsynModule(modules...); err != nil {
+		t.Fatal(err)
+	}
+	mgr.Init()
+	t.Cleanup(func() { mgr.Shutdown(0) })
+	return mgr
+}
+
+// 模拟主逻辑，执行一个到达的响应
+func handleOne(mgr *AsynMgr, wait time.Duration) bool {
+	select {
+	case resp := <-mgr.Resp():
+		mgr.HandleResp(resp)
+		return true
+	case <-time.After(wait):
+		return false
+	}
+}
+
+func TestTimeoutLoop(t *testing.T) {
+	var release = make(chan struct{})
+	var module = newTestModule(asyn_msg.RedisModuleId, func(req asyn_msg.ReqInf) asyn_msg.RespInf {
+		<-release
+		return &testResp{Key: req.(*testReq).Key}
+	})
+	var mgr = newTestMgr(t, module)
+
+	var resps []asyn_msg.RespInf
+	var req = &testReq{Key: "k"}
+	req.SetTimeout(100)
+	var start = time.Now()
+	mgr.SendReq(req, func(resp asyn_msg.RespInf) asyn_msg.AsynCBPtr {
+		resps = append(resps, resp)
+		return 0
+	})
+
+	if !handleOne(mgr, time.Second) {
+		t.Fatal("timeout not delivered")
+	}
+	if cost := time.Since(start); cost < 100*time.Millisecond {
+		t.Errorf("timeout delivered after %v, want >= 100ms", cost)
+	}
+	if len(resps) != 1 {
+		t.Fatalf("callbacks=%d, want 1", len(resps))
+	}
+	if _, ok := resps[0].(*asyn_msg.RespTimeout); !ok {
+		t.Fatalf("resp=%T, want RespTimeout", resps[0])
+	}
+
+	// 超时后模块的响应丢弃，不再回调
+	close(release)
+	if !handleOne(mgr, time.Second) {
+		t.Fatal("late resp not delivered")
+	}
+	if len(resps) != 1 {
+		t.Errorf("callbacks=%d after late resp, want 1", len(resps))
+	}
+	if module.CallBackLen() != 0 || module.TakeTimedOut(req.GetFcId()) {
+		t.Errorf("callbacks=%d, timed out fcId not cleared", module.CallBackLen())
+	}
+}
+
+func TestShutdownDrain(t *testing.T) {
+	var slow = newTestModule(asyn_msg.RedisModuleId, func(req asyn_msg.ReqInf) asyn_msg.RespInf {
+		time.Sleep(50 * time.Millisecond)
+		return &testResp{}
+	})
+	var hang = make(chan struct{})
+	defer close(hang)
+	var stuck = newTestModule(asyn_msg.DbPoolModuleId, func(req asyn_msg.ReqInf) asyn_msg.RespInf {
+		<-hang
+		return nil
+	})
+	var mgr = newTestMgr(t, slow, stuck)
+
+	var done = 0
+	var cb = func(resp asyn_msg.RespInf) asyn_msg.AsynCBPtr {
+		if err := asyn_msg.RespErr(resp); err != nil {
+			t.Errorf("resp err=%v", err)
+		}
+		done++
+		return 0
+	}
+	for i := 0; i < 3; i++ {
+		mgr.SendReq(&testReq{Key: "k"}, cb)
+	}
+	var hung = &testReq{Key: "hung", Module: asyn_msg.DbPoolModuleId}
+	mgr.SendReq(hung, cb)
+
+	var start = time.Now()
+	var abandoned = mgr.Shutdown(500 * time.Millisecond)
+	if cost := time.Since(start); cost < 500*time.Millisecond || cost > 2*time.Second {
+		t.Errorf("shutdown cost %v, want about 500ms", cost)
+	}
+	if done != 3 {
+		t.Errorf("callbacks=%d before deadline, want 3", done)
+	}
+	if len(abandoned) != 1 || len(abandoned[asyn_msg.DbPoolModuleId]) != 1 || abandoned[asyn_msg.DbPoolModuleId][0] != hung.GetFcId() {
+		t.Errorf("abandoned=%v, want only fcId %d of module %d", abandoned, hung.GetFcId(), asyn_msg.DbPoolModuleId)
+	}
+}
+
+func TestQueueDepth(t *testing.T) {
+	var release = make(chan struct{})
+	var module = newTestModule(asyn_msg.RedisModuleId, func(req asyn_msg.ReqInf) asyn_msg.RespInf {
+		<-release
+		return &testResp{}
+	})
+	var mgr = newTestMgr(t, module)
+	defer close(release)
+
+	for i := 0; i < 5; i++ {
+		mgr.SendReq(&testReq{Key: "k"}, nil)
+	}
+	// 一个在处理中，其余在队列中
+	var depth int
+	for i := 0; i < 100; i++ {
+		if depth = mgr.QueueDepth()[asyn_msg.RedisModuleId]; depth == 4 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if depth != 4 || mgr.ReqLen() != 4 {
+		t.Errorf("depth=%d, reqLen=%d, want 4", depth, mgr.ReqLen())
+	}
+}
+
+func TestModuleRegister(t *testing.T) {
+	var mgr = newTestMgr(t, newTestModule(asyn_msg.RedisModuleId, func(req asyn_msg.ReqInf) asyn_msg.RespInf {
+		return &testResp{Key: req.(*testReq).Key}
+	}))
+	// ID重复时保留先注册的模块
+	var dup = newTestModule(asyn_msg.RedisModuleId, func(req asyn_msg.ReqInf) asyn_msg.RespInf {
+		return &testResp{Key: "dup"}
+	})
+	if err := mgr.RegisterAsynModule(dup); !errors.Is(err, ErrModuleExist) {
+		t.Errorf("register duplicate err=%v, want ErrModuleExist", err)
+	}
+	if resp := testCall(t, mgr, "k"); resp.(*testResp).Key != "k" {
+		t.Errorf("resp key=%s, want k", resp.(*testResp).Key)
+	}
+
+	// 未注册的模块以错误响应回调
+	var result asyn_msg.RespInf
+	mgr.SendReq(&testReq{Key: "k", Module: asyn_msg.DbPoolModuleId}, func(resp asyn_msg.RespInf) asyn_msg.AsynCBPtr {
+		result = resp
+		return 0
+	})
+	for result == nil && handleOne(mgr, time.Second) {
+	}
+	if err := asyn_msg.RespErr(result); !errors.Is(err, ErrModuleNotFound) {
+		t.Errorf("err=%v, want ErrModuleNotFound", err)
+	}
+}
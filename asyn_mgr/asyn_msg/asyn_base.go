@@ -2,65 +2,461 @@ package asyn_msg
 
 import (
 	"gitlab.sunborngame.com/base/log"
-	"math"
-	"px/utils/chanx"
-	"reflect"
+	"px/utils"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
+type AsynFc struct {
+	Req   ReqInf
+	Cb    AsynCallback
+	Owner RespOwner // nil表示投递到主逻辑
+}
+
+type AsynDeadline struct {
+	fcId        uint64
+	req         ReqInf
+	expiredTime int64
+}
+
+// AsynBase 可以在任意goroutine中SendReq，响应投递回发送方的RespOwner
 type AsynBase struct {
 	FcId uint64
 
-	CallBacks map[uint64]AsynCallback
+	lock      sync.Mutex
+	callBacks map[uint64]*AsynFc
+	deadlines *utils.SortedSet[uint64, *AsynDeadline]
+	timedOut  map[uint64]int64 // 已投递RespTimeout的fcId -> 超时时间(ms)，用于识别迟到的响应
+
+	ReqChan  *Queue[ReqInf]
+	RespChan *Queue[RespInf]
+
+	closing  int32
+	stopOnce sync.Once
+	stopChan chan struct{}
+
+	loopState
+}
+
+func (this *AsynDeadline) Key() uint64 {
+	return this.fcId
+}
 
-	ReqChan  *chanx.UnboundedChan[ReqInf]
-	RespChan *chanx.UnboundedChan[RespInf]
+func (this *AsynDeadline) Less(other utils.SortedSetData[uint64]) bool {
+	o2 := other.(*AsynDeadline)
+	return this.expiredTime < o2.expiredTime
 }
 
 func NewAsynBase() *AsynBase {
-	return &AsynBase{
-		ReqChan:   chanx.NewUnboundedChan[ReqInf](MessageChanCap),
-		RespChan:  chanx.NewUnboundedChan[RespInf](MessageChanCap),
-		CallBacks: make(map[uint64]AsynCallback),
+	return NewAsynBaseWithQueue(DefaultReqQueueCap, QueuePolicyBlock)
+}
+
+// NewAsynBaseWithQueue 指定请求队列容量和溢出策略，响应队列满时总是阻塞
+func NewAsynBaseWithQueue(reqCap int, policy QueuePolicy) *AsynBase {
+	base := &AsynBase{
+		ReqChan:   NewReqQueue(reqCap, policy),
+		RespChan:  NewQueue[RespInf](DefaultRespQueueCap, QueuePolicyBlock),
+		callBacks: make(map[uint64]*AsynFc),
+		deadlines: utils.NewSortedSet[uint64, *AsynDeadline](),
+		timedOut:  make(map[uint64]int64),
+		stopChan:  make(chan struct{}),
+	}
+	base.working = make(map[*AsynWorking]struct{})
+	base.ReqChan.SetDropFunc(func(req ReqInf) bool {
+		return req.GetPriority() < PriorityNormal
+	}, base.onReqDropped)
+	base.ReqChan.SetHookFunc(nil, base.onReqDequeue)
+	base.RespChan.SetHookFunc(func(resp RespInf) {
+		resp.SetRespTime(time.Now().UnixNano())
+	}, nil)
+	return base
+}
+
+func (this *AsynBase) SetReqQueueOpt(capacity int, policy QueuePolicy) {
+	this.ReqChan.SetOpt(capacity, policy)
+}
+
+func (this *AsynBase) ReqQueueCap() int {
+	return this.ReqChan.Cap()
+}
+
+func (this *AsynBase) ReqLen() int {
+	return this.ReqChan.Len()
+}
+
+// ReqLaneLen 某优先级等待处理的请求数
+func (this *AsynBase) ReqLaneLen(priority ReqPriority) int {
+	return this.ReqChan.LaneLen(priority.Lane())
+}
+
+func (this *AsynBase) onReqDequeue(req ReqInf) {
+	var now = time.Now().UnixNano()
+	req.SetDequeueTime(now)
+	onDequeue(req, time.Duration(now-req.GetSendTime()))
+}
+
+func (this *AsynBase) onReqDropped(req ReqInf) {
+	log.Warning("asyn req queue full, drop req, moduleId=%d, fcid=%d, req=%v", req.GetModuleId(), req.GetFcId(), req)
+	this.failReq(req, ErrQueueDropped)
+}
+
+// 请求未进入模块处理，有回调的直接投递RespError
+func (this *AsynBase) failReq(req ReqInf, err error) {
+	if _, ok := this.GetCallBack(req.GetFcId()); !ok {
+		return
 	}
+	this.RespChan.Put(NewRespError(req.GetModuleId(), req, err))
 }
 
 func (this *AsynBase) nextFcId() uint64 {
-	if this.FcId == math.MaxUint64 {
-		this.FcId = 0
+	fcId := atomic.AddUint64(&this.FcId, 1)
+	if fcId == 0 { // 溢出回绕，跳过0
+		fcId = atomic.AddUint64(&this.FcId, 1)
 	}
-	this.FcId++
-	return this.FcId
+	return fcId
 }
 
 func (this *AsynBase) SendReq(req ReqInf, cb AsynCallback) {
+	this.SendReqFrom(nil, req, cb)
+}
+
+// SendReqFrom 响应通过owner投递，owner为nil时投递到主逻辑
+func (this *AsynBase) SendReqFrom(owner RespOwner, req ReqInf, cb AsynCallback) {
 	req.SetFcId(this.nextFcId())
+	req.SetSendTime(time.Now().UnixNano())
+	if TraceEnabled() {
+		var trace = ChildOf(CurrentTrace(owner))
+		trace.Oneway = cb == nil
+		req.SetTrace(trace)
+	}
+	onSend(req)
+
+	var closing = this.IsClosing()
 	if cb != nil {
-		this.CallBacks[req.GetFcId()] = cb
+		this.lock.Lock()
+		this.callBacks[req.GetFcId()] = &AsynFc{
+			Req:   req,
+			Cb:    cb,
+			Owner: owner,
+		}
+		if !closing {
+			this.addDeadline(req)
+		}
+		this.lock.Unlock()
+	}
+
+	if closing {
+		log.Warning("asyn module closing, reject req, moduleId=%d, req=%v", req.GetModuleId(), req)
+		this.failReq(req, ErrClosed)
+		return
+	}
+
+	if err := this.ReqChan.Put(req); err != nil {
+		log.Warning("asyn req rejected, moduleId=%d, len=%d, err=%v, req=%v", req.GetModuleId(), this.ReqChan.Len(), err, req)
+		this.failReq(req, err)
 	}
+}
 
-	this.ReqChan.Put(req)
+// Requeue 接收同一模块旧实例的请求，保留fcId和回调，不触发OnSend，模块重启时调用
+func (this *AsynBase) Requeue(req ReqInf, fc *AsynFc) {
+	if fc != nil {
+		this.lock.Lock()
+		this.callBacks[req.GetFcId()] = fc
+		this.addDeadline(req)
+		this.lock.Unlock()
+	}
+	req.SetDequeueTime(0)
+	if err := this.ReqChan.PutUnbounded(req); err != nil {
+		this.failReq(req, err)
+	}
+}
+
+// ContinueFcId fcId从旧实例之后开始，旧实例迟到的响应不会对应到新请求
+func (this *AsynBase) ContinueFcId(from *AsynBase) {
+	var last = atomic.LoadUint64(&from.FcId)
+	for {
+		var cur = atomic.LoadUint64(&this.FcId)
+		if cur >= last || atomic.CompareAndSwapUint64(&this.FcId, cur, last) {
+			return
+		}
+	}
+}
+
+func (this *AsynBase) StopAccept() {
+	atomic.StoreInt32(&this.closing, 1)
+}
+
+func (this *AsynBase) IsClosing() bool {
+	return atomic.LoadInt32(&this.closing) != 0
+}
+
+// Stop 通知模块loop退出并关闭队列，模块Close时调用
+// 之后模块的响应直接丢弃，未完成的回调由调用方处理(TakePending)
+func (this *AsynBase) Stop() {
+	this.StopAccept()
+	this.stopOnce.Do(func() {
+		close(this.stopChan)
+		this.ReqChan.Close()
+		this.RespChan.Close()
+	})
+}
+
+func (this *AsynBase) Done() <-chan struct{} {
+	return this.stopChan
 }
 
 func (this *AsynBase) Resp() <-chan RespInf {
 	return this.RespChan.C()
 }
 
+func (this *AsynBase) GetCallBack(fcId uint64) (AsynCallback, bool) {
+	fc, ok := this.getFc(fcId)
+	if !ok {
+		return nil, false
+	}
+	return fc.Cb, true
+}
+
+func (this *AsynBase) getFc(fcId uint64) (*AsynFc, bool) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	fc, ok := this.callBacks[fcId]
+	return fc, ok
+}
+
+func (this *AsynBase) GetRespOwner(fcId uint64) RespOwner {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	fc, ok := this.callBacks[fcId]
+	if !ok {
+		return nil
+	}
+	return fc.Owner
+}
+
+// PendingFcIds 还未回调的请求
+func (this *AsynBase) PendingFcIds() []uint64 {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	var fcIds = make([]uint64, 0, len(this.callBacks))
+	for fcId := range this.callBacks {
+		fcIds = append(fcIds, fcId)
+	}
+	sort.Slice(fcIds, func(i, j int) bool { return fcIds[i] < fcIds[j] })
+	return fcIds
+}
+
+// PendingCallBacks 还未回调的请求，等待最久的在前，limit<=0时返回全部
+func (this *AsynBase) PendingCallBacks(limit int) []AsynFc {
+	this.lock.Lock()
+	var fcs = make([]AsynFc, 0, len(this.callBacks))
+	for _, fc := range this.callBacks {
+		fcs = append(fcs, *fc)
+	}
+	this.lock.Unlock()
+
+	sort.Slice(fcs, func(i, j int) bool {
+		if ti, tj := fcs[i].Req.GetSendTime(), fcs[j].Req.GetSendTime(); ti != tj {
+			return ti < tj
+		}
+		return fcs[i].Req.GetFcId() < fcs[j].Req.GetFcId()
+	})
+	if limit > 0 && len(fcs) > limit {
+		fcs = fcs[:limit]
+	}
+	return fcs
+}
+
+// FailCallBack 以RespError(err)回调还未回调的请求，之后模块的响应找不到回调会被丢弃
+func (this *AsynBase) FailCallBack(fcId uint64, err error) bool {
+	fc, ok := this.getFc(fcId)
+	if !ok {
+		return false
+	}
+	// 正在处理的请求标记为卡住，panic时不再重复回调
+	this.workLock.Lock()
+	for w := range this.working {
+		if w.Req.GetFcId() == fcId {
+			w.Stuck = true
+		}
+	}
+	this.workLock.Unlock()
+
+	this.lock.Lock()
+	this.markTimedOut(fcId)
+	this.lock.Unlock()
+
+	this.RespChan.Put(NewRespError(fc.Req.GetModuleId(), fc.Req, err))
+	return true
+}
+
+// QueuedReqs 请求队列中等待处理的请求，不出队
+func (this *AsynBase) QueuedReqs(limit int) []ReqInf {
+	return this.ReqChan.Snapshot(limit)
+}
+
+// TakeCallBack 取出回调，之后的响应不再回调
+func (this *AsynBase) TakeCallBack(fcId uint64) (*AsynFc, bool) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	fc, ok := this.callBacks[fcId]
+	if ok {
+		delete(this.callBacks, fcId)
+		this.deadlines.RemoveByKey(fcId)
+	}
+	return fc, ok
+}
+
+// TakePending 取出所有还未回调的请求，用于模块移除或重启
+func (this *AsynBase) TakePending() []*AsynFc {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	var fcs = make([]*AsynFc, 0, len(this.callBacks))
+	for fcId, fc := range this.callBacks {
+		fcs = append(fcs, fc)
+		delete(this.callBacks, fcId)
+		this.deadlines.RemoveByKey(fcId)
+	}
+	sort.Slice(fcs, func(i, j int) bool { return fcs[i].Req.GetFcId() < fcs[j].Req.GetFcId() })
+	return fcs
+}
+
+func (this *AsynBase) GetAsynBase() *AsynBase {
+	return this
+}
+
+func (this *AsynBase) CallBackLen() int {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return len(this.callBacks)
+}
+
+// HandleResp 在发送方goroutine中执行，回调执行期间不持有锁
 func (this *AsynBase) HandleResp(resp RespInf) AsynCBPtr {
-	cb, ok := this.CallBacks[resp.GetFcId()]
+	fc, ok := this.getFc(resp.GetFcId())
 	if !ok {
+		if _, timeout := resp.(*RespTimeout); timeout {
+			// 超时与正常响应同时到达，正常响应已处理
+			log.Warning("timeout callback already handled, fcid=%d", resp.GetFcId())
+			return 0
+		}
+		if this.TakeTimedOut(resp.GetFcId()) {
+			log.Warning("resp after timeout, fcid=%d, resp=%v", resp.GetFcId(), resp)
+			return 0
+		}
 		log.Error("callback not found, fcid=%d, resp=%v", resp.GetFcId(), resp)
 		return 0
 	}
 
-	x := cb(resp)
+	var cbSpan SpanId
+	var prevTrace TraceCtx
+	if trace := fc.Req.GetTrace(); !trace.IsZero() {
+		resp.SetTrace(trace)
+		var cbTrace = ChildOf(trace)
+		cbSpan = cbTrace.SpanId
+		prevTrace = SetCurrentTrace(fc.Owner, cbTrace)
+	}
+
+	var start = time.Now()
+	x := fc.Cb(resp)
+	var cost = time.Since(start)
 	if x == 0 {
-		x = AsynCBPtr(reflect.ValueOf(cb).Pointer())
+		x = CBPtr(fc.Cb)
+	}
+
+	if !cbSpan.IsZero() {
+		SetCurrentTrace(fc.Owner, prevTrace)
 	}
 
 	this.DeleteCallBack(resp)
+
+	if len(hooks) > 0 {
+		var stat = &AsynStat{
+			ModuleId: resp.GetModuleId(),
+			Callback: cost,
+			Ptr:      x,
+
+			CallbackSpan: cbSpan,
+		}
+		if dequeueTs := fc.Req.GetDequeueTime(); dequeueTs > 0 {
+			stat.Wait = time.Duration(dequeueTs - fc.Req.GetSendTime())
+			stat.Exec = time.Duration(resp.GetRespTime() - dequeueTs)
+		}
+		onDone(fc.Req, resp, stat)
+	}
 	return x
 }
 
 func (this *AsynBase) DeleteCallBack(resp RespInf) {
-	delete(this.CallBacks, resp.GetFcId())
+	this.RemoveCallBack(resp.GetFcId())
+}
+
+func (this *AsynBase) RemoveCallBack(fcId uint64) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	delete(this.callBacks, fcId)
+	this.deadlines.RemoveByKey(fcId)
+}
+
+// 需持有lock
+func (this *AsynBase) addDeadline(req ReqInf) {
+	if req.GetTimeout() <= 0 {
+		return
+	}
+
+	// 从发送时开始计算，重启转移的请求不重新计时
+	var elapsed = (time.Now().UnixNano() - req.GetSendTime()) / int64(time.Millisecond)
+	this.deadlines.Push(&AsynDeadline{
+		fcId:        req.GetFcId(),
+		req:         req,
+		expiredTime: utils.NowUnixMilli() + req.GetTimeout() - elapsed,
+	})
+}
+
+// CheckTimeout 超时的请求回调不再等待模块响应，直接投递RespTimeout，不受响应队列容量限制
+func (this *AsynBase) CheckTimeout(now int64) {
+	var expired []*AsynDeadline
+	this.lock.Lock()
+	for {
+		deadline, ok := this.deadlines.Front()
+		if !ok {
+			break
+		}
+		if deadline.expiredTime > now {
+			break
+		}
+		expired = append(expired, deadline)
+		this.deadlines.Remove(deadline)
+		this.timedOut[deadline.fcId] = now
+	}
+	for fcId, ts := range this.timedOut {
+		if now-ts > TimedOutKeep.Milliseconds() {
+			delete(this.timedOut, fcId)
+		}
+	}
+	this.lock.Unlock()
+
+	for _, deadline := range expired {
+		// 请求可能还在模块中处理，不打印请求内容
+		log.Warning("asyn req timeout, moduleId=%d, fcid=%d, type=%s", deadline.req.GetModuleId(), deadline.fcId, TypeName(deadline.req))
+		// 所有模块共用超时检查，一个模块的响应队列满时不能阻塞其他模块
+		this.RespChan.PutUnbounded(NewRespTimeout(deadline.req.GetModuleId(), deadline.req))
+	}
+}
+
+// 回调已提前以错误投递，模块迟到的响应按超时后的响应丢弃，需持有lock
+func (this *AsynBase) markTimedOut(fcId uint64) {
+	this.timedOut[fcId] = utils.NowUnixMilli()
+	this.deadlines.RemoveByKey(fcId)
+}
+
+// TakeTimedOut fcId最近已超时回调，用于区分迟到的响应和错误的fcId
+func (this *AsynBase) TakeTimedOut(fcId uint64) bool {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	_, ok := this.timedOut[fcId]
+	delete(this.timedOut, fcId)
+	return ok
 }
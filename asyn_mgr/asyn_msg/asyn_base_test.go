@@ -0,0 +1,303 @@
+package asyn_msg
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"px/utils/chanx"
+)
+
+// go test -race ./shared/asyn_mgr/asyn_msg/
+
+type testReq struct {
+	ReqBase
+	Sender int
+}
+
+type testResp struct {
+	RespBase
+	Sender int
+}
+
+func (this *testReq) GetModuleId() AsynModuleId {
+	return RedisModuleId
+}
+
+func (this *testResp) GetModuleId() AsynModuleId {
+	return RedisModuleId
+}
+
+type testOwner struct {
+	respChan *chanx.UnboundedChan[RespInf]
+}
+
+func (this *testOwner) PutResp(resp RespInf) {
+	this.respChan.Put(resp)
+}
+
+// 模拟模块goroutine，原样回包
+func testEcho(base *AsynBase, stop chan struct{}) {
+	for {
+		select {
+		case req := <-base.ReqChan.C():
+			var resp = &testResp{Sender: req.(*testReq).Sender}
+			resp.SetFcId(req.GetFcId())
+			base.RespChan.Put(resp)
+		case <-stop:
+			return
+		}
+	}
+}
+
+// 模拟AsynMgr.loop，按owner分发响应
+func testRoute(base *AsynBase, stop chan struct{}) {
+	for {
+		select {
+		case resp := <-base.Resp():
+			owner := base.GetRespOwner(resp.GetFcId())
+			if owner == nil {
+				panic("resp owner not found")
+			}
+			owner.PutResp(resp)
+		case <-stop:
+			return
+		}
+	}
+}
+
+func TestAsynBaseConcurrentSend(t *testing.T) {
+	const senders = 16
+	const reqs = 500
+
+	var base = NewAsynBase()
+	var stop = make(chan struct{})
+	defer close(stop)
+	go testEcho(base, stop)
+	go testRoute(base, stop)
+
+	var fcIds sync.Map
+	var dupFcId int32
+	var wg sync.WaitGroup
+	for i := 0; i < senders; i++ {
+		wg.Add(1)
+		go func(sender int) {
+			defer wg.Done()
+			var owner = &testOwner{respChan: chanx.NewUnboundedChan[RespInf](MessageChanCap)}
+			var handled = 0
+			for j := 0; j < reqs; j++ {
+				var req = &testReq{Sender: sender}
+				base.SendReqFrom(owner, req, func(inf RespInf) AsynCBPtr {
+					if inf.(*testResp).Sender != sender {
+						t.Errorf("resp routed to wrong sender, want=%d, got=%d", sender, inf.(*testResp).Sender)
+					}
+					handled++
+					return 0
+				})
+				if _, loaded := fcIds.LoadOrStore(req.GetFcId(), sender); loaded {
+					atomic.AddInt32(&dupFcId, 1)
+				}
+			}
+			for handled < reqs {
+				select {
+				case resp := <-owner.respChan.C():
+					base.HandleResp(resp)
+				case <-time.After(5 * time.Second):
+					t.Errorf("sender %d wait resp timeout, handled=%d", sender, handled)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if dupFcId != 0 {
+		t.Errorf("duplicate fcId, count=%d", dupFcId)
+	}
+	if n := base.CallBackLen(); n != 0 {
+		t.Errorf("callbacks leaked, count=%d", n)
+	}
+}
+
+func TestAsynBaseConcurrentTimeout(t *testing.T) {
+	const reqs = 200
+
+	var base = NewAsynBase()
+	var owner = &testOwner{respChan: chanx.NewUnboundedChan[RespInf](MessageChanCap)}
+	var stop = make(chan struct{})
+	defer close(stop)
+	go testRoute(base, stop)
+
+	var timeouts int32
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < reqs/4; j++ {
+				var req = &testReq{}
+				req.SetTimeout(1)
+				base.SendReqFrom(owner, req, func(inf RespInf) AsynCBPtr {
+					if _, ok := inf.(*RespTimeout); ok {
+						atomic.AddInt32(&timeouts, 1)
+					}
+					return 0
+				})
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for j := 0; j < 10; j++ {
+			base.CheckTimeout(time.Now().UnixMilli() + 10)
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	wg.Wait()
+	base.CheckTimeout(time.Now().UnixMilli() + 10)
+
+	var deadline = time.After(5 * time.Second)
+	for atomic.LoadInt32(&timeouts) < reqs {
+		select {
+		case resp := <-owner.respChan.C():
+			base.HandleResp(resp)
+		case <-deadline:
+			t.Fatalf("wait timeout resp failed, got=%d", timeouts)
+		}
+	}
+	if n := base.CallBackLen(); n != 0 {
+		t.Errorf("callbacks leaked, count=%d", n)
+	}
+}
+
+// 响应队列满时超时检查不阻塞
+func TestAsynBaseTimeoutRespFull(t *testing.T) {
+	var base = NewAsynBase()
+	base.RespChan.SetOpt(1, QueuePolicyBlock)
+	for i := 0; i < 3; i++ {
+		base.RespChan.PutUnbounded(&testResp{})
+	}
+	var req = &testReq{}
+	req.SetTimeout(1)
+	base.SendReq(req, func(RespInf) AsynCBPtr { return 0 })
+	<-base.ReqChan.C()
+
+	var done = make(chan struct{})
+	go func() {
+		base.CheckTimeout(time.Now().UnixMilli() + 10)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("CheckTimeout blocked by full resp queue")
+	}
+	for i := 0; i < 4; i++ {
+		if resp := <-base.Resp(); i == 3 {
+			if _, ok := resp.(*RespTimeout); !ok || resp.GetRespTime() == 0 {
+				t.Errorf("resp=%T, respTime=%d, want timeout", resp, resp.GetRespTime())
+			}
+		}
+	}
+}
+
+// 提前失败的回调，模块迟到的响应识别为超时后的响应
+func TestAsynBaseFailEarly(t *testing.T) {
+	var base = NewAsynBase()
+	var calls int
+	var cb = func(resp RespInf) AsynCBPtr {
+		if _, ok := resp.(*RespError); !ok {
+			t.Errorf("resp=%T, want RespError", resp)
+		}
+		calls++
+		return 0
+	}
+
+	var failed = &testReq{}
+	failed.SetTimeout(1)
+	base.SendReq(failed, cb)
+	<-base.ReqChan.C()
+	if !base.FailCallBack(failed.GetFcId(), ErrStuck) {
+		t.Fatal("FailCallBack returned false")
+	}
+
+	var stuck = &testReq{}
+	base.SendReq(stuck, cb)
+	var release = make(chan struct{})
+	var processed = make(chan struct{})
+	go func() {
+		base.Process(<-base.ReqChan.C(), func(ReqInf) { <-release })
+		close(processed)
+	}()
+	for !base.FailStuck(stuck.GetFcId()) {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	<-processed
+
+	for _, req := range []*testReq{failed, stuck} {
+		base.HandleResp(<-base.Resp())
+		if !base.TakeTimedOut(req.GetFcId()) {
+			t.Errorf("fcId=%d not marked timed out", req.GetFcId())
+		}
+	}
+	// 不再投递RespTimeout
+	base.CheckTimeout(time.Now().UnixMilli() + 10)
+	select {
+	case resp := <-base.Resp():
+		t.Errorf("unexpected resp %T", resp)
+	case <-time.After(10 * time.Millisecond):
+	}
+	if calls != 2 || base.CallBackLen() != 0 {
+		t.Errorf("calls=%d, callbacks=%d", calls, base.CallBackLen())
+	}
+}
+
+type testStatHook struct {
+	lock  sync.Mutex
+	stats []*AsynStat
+}
+
+func (this *testStatHook) OnSend(req ReqInf) {}
+
+func (this *testStatHook) OnDequeue(req ReqInf, wait time.Duration) {}
+
+func (this *testStatHook) OnDone(req ReqInf, resp RespInf, stat *AsynStat) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.stats = append(this.stats, stat)
+}
+
+// 模块立即响应时执行耗时也不能为负
+func TestAsynBaseStat(t *testing.T) {
+	const reqs = 200
+
+	var hook = &testStatHook{}
+	AddHook(hook)
+	AddHook(hook)
+	defer func() { hooks = nil }()
+
+	var base = NewAsynBase()
+	defer base.Stop()
+	var stop = make(chan struct{})
+	defer close(stop)
+	go testEcho(base, stop)
+
+	for i := 0; i < reqs; i++ {
+		base.SendReq(&testReq{Sender: i}, func(RespInf) AsynCBPtr { return 0 })
+	}
+	for i := 0; i < reqs; i++ {
+		base.HandleResp(<-base.Resp())
+	}
+
+	if len(hook.stats) != reqs {
+		t.Fatalf("stats=%d, want %d, hook registered twice", len(hook.stats), reqs)
+	}
+	for _, stat := range hook.stats {
+		if stat.Wait < 0 || stat.Exec <= 0 {
+			t.Fatalf("wait=%v, exec=%v", stat.Wait, stat.Exec)
+		}
+	}
+}
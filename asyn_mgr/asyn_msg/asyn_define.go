@@ -1,7 +1,11 @@
 package asyn_msg
 
+import "time"
+
+// AsynModuleId 新模块用MustRegisterModuleId按名字注册，不再增加常量
 type AsynModuleId int32
 
+// 内置模块，启动时已注册，AStar和Dsa只保留ID
 const (
 	AStarModuleId    AsynModuleId = 1
 	DbClientModuleId AsynModuleId = 2
@@ -11,8 +15,65 @@ const (
 	EtcdModuleId     AsynModuleId = 6
 	DsaModuleId      AsynModuleId = 7
 	RedisModuleId    AsynModuleId = 8
+
+	DynamicModuleIdStart AsynModuleId = 100 // 注册时分配的ID从这里开始
 )
 
 const (
 	MessageChanCap = 1024
+
+	DefaultReqQueueCap  = 100000 // 模块请求队列默认容量
+	DefaultRespQueueCap = 100000 // 模块响应队列默认容量
+
+	TimedOutKeep = time.Minute // 超时后该时间内到达的响应视为迟到，只记警告
+
+	LoopMinBackoff  = 100 * time.Millisecond // 模块loop异常退出后重启的初始等待
+	LoopMaxBackoff  = 30 * time.Second
+	LoopStableAfter = time.Minute // 运行超过该时间后退避重置
+
+	LaneStarveLimit = 8 // 低优先级请求被高优先级插队该次数后先处理一个
+
+	WorkerPoolQueueCap = MessageChanCap // WorkerPool中等待执行的请求上限，超出的留在模块队列
 )
+
+// 请求优先级，默认PriorityNormal
+type ReqPriority int32
+
+const (
+	PriorityLow    ReqPriority = -1
+	PriorityNormal ReqPriority = 0
+	PriorityHigh   ReqPriority = 1
+)
+
+// 请求队列按优先级分lane，高优先级先处理
+const ReqLaneCount = 3
+
+var priorityNames = [ReqLaneCount]string{"high", "normal", "low"}
+
+// Lane 优先级对应的lane，超出范围的归到最近的优先级
+func (this ReqPriority) Lane() int {
+	switch {
+	case this >= PriorityHigh:
+		return 0
+	case this <= PriorityLow:
+		return 2
+	default:
+		return 1
+	}
+}
+
+func (this ReqPriority) String() string {
+	return priorityNames[this.Lane()]
+}
+
+// LanePriority lane对应的优先级
+func LanePriority(lane int) ReqPriority {
+	return PriorityHigh - ReqPriority(lane)
+}
+
+// NewReqQueue 按请求优先级分lane的请求队列
+func NewReqQueue(capacity int, policy QueuePolicy) *Queue[ReqInf] {
+	return NewLaneQueue[ReqInf](capacity, policy, ReqLaneCount, func(req ReqInf) int {
+		return req.GetPriority().Lane()
+	})
+}
@@ -0,0 +1,103 @@
+package asyn_msg
+
+import (
+	"errors"
+	"reflect"
+	"time"
+)
+
+// AsynStat 一次请求各阶段耗时
+type AsynStat struct {
+	ModuleId AsynModuleId
+	Wait     time.Duration // 请求队列中等待
+	Exec     time.Duration // 出队到模块产出响应
+	Callback time.Duration // 回调执行
+	Ptr      AsynCBPtr
+
+	CallbackSpan SpanId // 开启追踪时回调所在的span，回调内发出的请求以其为父span
+}
+
+// AsynHook 请求各阶段的观察者，用于统计、追踪等，不能修改消息
+type AsynHook interface {
+	// 发送方goroutine
+	OnSend(req ReqInf)
+	// 请求队列goroutine，和模块处理请求并发，只能读取请求
+	OnDequeue(req ReqInf, wait time.Duration)
+	// 发送方goroutine，回调执行完成后
+	OnDone(req ReqInf, resp RespInf, stat *AsynStat)
+}
+
+// 启动时注册，运行期间不可修改
+var hooks []AsynHook
+
+// AddHook 同一hook重复注册时忽略
+func AddHook(hook AsynHook) {
+	for _, h := range hooks {
+		if h == hook {
+			return
+		}
+	}
+	hooks = append(hooks, hook)
+}
+
+func onSend(req ReqInf) {
+	for _, hook := range hooks {
+		hook.OnSend(req)
+	}
+}
+
+func onDequeue(req ReqInf, wait time.Duration) {
+	for _, hook := range hooks {
+		hook.OnDequeue(req, wait)
+	}
+}
+
+func onDone(req ReqInf, resp RespInf, stat *AsynStat) {
+	for _, hook := range hooks {
+		hook.OnDone(req, resp, stat)
+	}
+}
+
+// TypeName 消息类型名，用于统计
+func TypeName(msg any) string {
+	tp := reflect.TypeOf(msg)
+	if tp == nil {
+		return ""
+	}
+	if tp.Kind() == reflect.Ptr {
+		tp = tp.Elem()
+	}
+	return tp.Name()
+}
+
+// RespErr 获取响应中的错误，包括RespTimeout/RespError以及模块响应的Err/ErrMsg字段
+func RespErr(resp RespInf) error {
+	if err, ok := resp.(error); ok {
+		return err
+	}
+
+	v := reflect.ValueOf(resp)
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+	for _, name := range []string{"Err", "ErrMsg"} {
+		f := v.FieldByName(name)
+		if !f.IsValid() {
+			continue
+		}
+		switch e := f.Interface().(type) {
+		case string:
+			if e != "" {
+				return errors.New(e)
+			}
+		case error:
+			if e != nil {
+				return e
+			}
+		}
+	}
+	return nil
+}
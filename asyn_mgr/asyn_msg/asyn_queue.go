@@ -0,0 +1,372 @@
+package asyn_msg
+
+import (
+	"errors"
+	"sync"
+)
+
+type QueuePolicy int32
+
+const (
+	QueuePolicyBlock      QueuePolicy = iota // 队列满时阻塞发送方
+	QueuePolicyReject                        // 队列满时拒绝新消息
+	QueuePolicyDropOldest                    // 队列满时丢弃最早的可丢弃(低优先级)消息，没有可丢弃的则拒绝
+)
+
+var (
+	ErrQueueFull    = errors.New("asyn queue full")
+	ErrQueueDropped = errors.New("asyn queue dropped")
+)
+
+// Queue 有界队列，用法同chanx.UnboundedChan，通过C()读取
+// 可分为多个lane，编号小的先出队，低lane被跳过LaneStarveLimit次后出队一个
+type Queue[T any] struct {
+	lock     sync.Mutex
+	notFull  *sync.Cond
+	lanes    [][]T
+	skipped  []int // 各lane有消息时被更高lane插队的次数
+	size     int
+	laneOf   func(T) int
+	capacity int // <=0 不限制
+	policy   QueuePolicy
+	canDrop  func(T) bool
+	onDrop   func(T)
+	onIn     func(T)
+	onOut    func(T)
+
+	notify  chan struct{}
+	out     chan T
+	head    T    // 已从lane取出，还未被读走
+	holding bool // head有效
+	closed  bool
+	done    chan struct{} // Close时关闭，通知pump退出
+	exited  chan struct{} // pump已退出
+}
+
+func NewQueue[T any](capacity int, policy QueuePolicy) *Queue[T] {
+	return NewLaneQueue[T](capacity, policy, 1, nil)
+}
+
+// NewLaneQueue laneOf返回消息所在lane[0, n)，超出范围的归到最近的lane
+func NewLaneQueue[T any](capacity int, policy QueuePolicy, n int, laneOf func(T) int) *Queue[T] {
+	if n < 1 {
+		n = 1
+	}
+	q := &Queue[T]{
+		lanes:    make([][]T, n),
+		skipped:  make([]int, n),
+		laneOf:   laneOf,
+		capacity: capacity,
+		policy:   policy,
+		notify:   make(chan struct{}, 1),
+		out:      make(chan T),
+		done:     make(chan struct{}),
+		exited:   make(chan struct{}),
+	}
+	q.notFull = sync.NewCond(&q.lock)
+
+	go q.pump()
+	return q
+}
+
+// SetOpt 修改容量和溢出策略，运行期间可调用
+func (q *Queue[T]) SetOpt(capacity int, policy QueuePolicy) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.capacity, q.policy = capacity, policy
+	q.notFull.Broadcast()
+}
+
+// SetDropFunc canDrop判断消息是否可丢弃，onDrop在消息被丢弃后调用（不持有锁）
+func (q *Queue[T]) SetDropFunc(canDrop func(T) bool, onDrop func(T)) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.canDrop, q.onDrop = canDrop, onDrop
+}
+
+// SetHookFunc onIn在入队前由发送方调用，onOut在消息出队后、被读走前由队列goroutine调用
+func (q *Queue[T]) SetHookFunc(onIn func(T), onOut func(T)) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.onIn, q.onOut = onIn, onOut
+}
+
+func (q *Queue[T]) Put(v T) error {
+	var dropped []T
+	var onDrop func(T)
+
+	q.lock.Lock()
+	for q.capacity > 0 && q.size >= q.capacity && !q.closed {
+		if q.policy == QueuePolicyReject {
+			q.lock.Unlock()
+			return ErrQueueFull
+		}
+		if q.policy == QueuePolicyDropOldest {
+			lane, idx := q.oldestDroppable()
+			if idx < 0 {
+				q.lock.Unlock()
+				return ErrQueueFull
+			}
+			dropped = append(dropped, q.lanes[lane][idx])
+			q.lanes[lane] = append(q.lanes[lane][:idx], q.lanes[lane][idx+1:]...)
+			q.size--
+			onDrop = q.onDrop
+			continue
+		}
+		q.notFull.Wait()
+	}
+	if q.closed {
+		q.lock.Unlock()
+		return ErrClosed
+	}
+	if q.onIn != nil {
+		q.onIn(v)
+	}
+	q.push(v)
+	q.lock.Unlock()
+
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+
+	if onDrop != nil {
+		for _, d := range dropped {
+			onDrop(d)
+		}
+	}
+	return nil
+}
+
+// PutUnbounded 不受容量限制，不会阻塞，用于模块重启时转移请求、投递超时响应
+func (q *Queue[T]) PutUnbounded(v T) error {
+	q.lock.Lock()
+	if q.closed {
+		q.lock.Unlock()
+		return ErrClosed
+	}
+	if q.onIn != nil {
+		q.onIn(v)
+	}
+	q.push(v)
+	q.lock.Unlock()
+
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// 需持有lock
+func (q *Queue[T]) lane(v T) int {
+	if q.laneOf == nil {
+		return 0
+	}
+	lane := q.laneOf(v)
+	if lane < 0 {
+		return 0
+	}
+	if lane >= len(q.lanes) {
+		return len(q.lanes) - 1
+	}
+	return lane
+}
+
+// 需持有lock
+func (q *Queue[T]) push(v T) {
+	lane := q.lane(v)
+	q.lanes[lane] = append(q.lanes[lane], v)
+	q.size++
+}
+
+// 需持有lock，从最低的lane开始找
+func (q *Queue[T]) oldestDroppable() (int, int) {
+	if q.canDrop == nil {
+		return -1, -1
+	}
+	for lane := len(q.lanes) - 1; lane >= 0; lane-- {
+		for i, v := range q.lanes[lane] {
+			if q.canDrop(v) {
+				return lane, i
+			}
+		}
+	}
+	return -1, -1
+}
+
+// 需持有lock，取最高的非空lane，更低的lane被跳过LaneStarveLimit次后优先
+func (q *Queue[T]) nextLane() int {
+	var next = -1
+	for lane := range q.lanes {
+		if len(q.lanes[lane]) > 0 {
+			next = lane
+			break
+		}
+	}
+	if next < 0 {
+		return -1
+	}
+	for lane := len(q.lanes) - 1; lane > next; lane-- {
+		if len(q.lanes[lane]) > 0 && q.skipped[lane] >= LaneStarveLimit {
+			next = lane
+			break
+		}
+	}
+	for lane := range q.lanes {
+		if lane != next && len(q.lanes[lane]) > 0 {
+			q.skipped[lane]++
+		}
+	}
+	q.skipped[next] = 0
+	return next
+}
+
+func (q *Queue[T]) pop() (T, func(T), bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	var zero T
+	lane := q.nextLane()
+	if lane < 0 {
+		return zero, nil, false
+	}
+	v := q.lanes[lane][0]
+	q.lanes[lane][0] = zero
+	q.lanes[lane] = q.lanes[lane][1:]
+	q.size--
+	q.head, q.holding = v, true
+	q.notFull.Signal()
+	return v, q.onOut, true
+}
+
+func (q *Queue[T]) pump() {
+	defer close(q.exited)
+	for {
+		v, onOut, ok := q.pop()
+		if !ok {
+			select {
+			case <-q.notify:
+				continue
+			case <-q.done:
+				return
+			}
+		}
+		// 读取方可能立即处理完，出队时间需在交出前记录
+		if onOut != nil {
+			onOut(v)
+		}
+		select {
+		case q.out <- v:
+		case <-q.done:
+			// head保留，由Drain取出
+			return
+		}
+
+		q.lock.Lock()
+		var zero T
+		q.head, q.holding = zero, false
+		q.lock.Unlock()
+	}
+}
+
+// Close 停止pump，阻塞在Put的发送方以ErrClosed返回，之后的Put都返回ErrClosed
+func (q *Queue[T]) Close() {
+	q.lock.Lock()
+	if q.closed {
+		q.lock.Unlock()
+		return
+	}
+	q.closed = true
+	q.notFull.Broadcast()
+	q.lock.Unlock()
+
+	close(q.done)
+	<-q.exited
+}
+
+// Drain 按lane顺序取出队列中所有还未被读走的消息，Close之后包括pump中等待读走的消息
+func (q *Queue[T]) Drain() []T {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	var drained = make([]T, 0, q.size+1)
+	if q.closed && q.holding {
+		var zero T
+		drained = append(drained, q.head)
+		q.head, q.holding = zero, false
+	}
+	for lane := range q.lanes {
+		drained = append(drained, q.lanes[lane]...)
+		q.lanes[lane] = nil
+		q.skipped[lane] = 0
+	}
+	q.size = 0
+	q.notFull.Broadcast()
+	return drained
+}
+
+func (q *Queue[T]) C() <-chan T {
+	return q.out
+}
+
+// Len 当前队列深度
+func (q *Queue[T]) Len() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.holding {
+		return q.size + 1
+	}
+	return q.size
+}
+
+// Snapshot 按lane顺序返回还未被读走的消息，不出队，limit<=0时返回全部
+func (q *Queue[T]) Snapshot(limit int) []T {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if limit <= 0 || limit > q.size+1 {
+		limit = q.size + 1
+	}
+	var items = make([]T, 0, limit)
+	if q.holding {
+		items = append(items, q.head)
+	}
+	for lane := range q.lanes {
+		for _, v := range q.lanes[lane] {
+			if len(items) >= limit {
+				return items
+			}
+			items = append(items, v)
+		}
+	}
+	return items
+}
+
+// LaneLen lane的队列深度
+func (q *Queue[T]) LaneLen(lane int) int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if lane < 0 || lane >= len(q.lanes) {
+		return 0
+	}
+	var n = len(q.lanes[lane])
+	if q.holding && q.lane(q.head) == lane {
+		n++
+	}
+	return n
+}
+
+// LaneCount lane数量
+func (q *Queue[T]) LaneCount() int {
+	return len(q.lanes)
+}
+
+func (q *Queue[T]) Cap() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return q.capacity
+}
+
+func (q *Queue[T]) Policy() QueuePolicy {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return q.policy
+}
@@ -0,0 +1,188 @@
+package asyn_msg
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// 等待pump取出队首，之后buf中的元素才计入容量
+func waitHolding[T any](t *testing.T, q *Queue[T]) {
+	t.Helper()
+	for i := 0; i < 1000; i++ {
+		q.lock.Lock()
+		var holding = q.holding
+		q.lock.Unlock()
+		if holding {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("pump not holding")
+}
+
+func TestQueuePolicy(t *testing.T) {
+	var tests = []struct {
+		name        string
+		policy      QueuePolicy
+		capacity    int
+		buf         []int // 队首之后已在队列中的元素
+		put         int
+		wantErr     error
+		wantBuf     []int
+		wantDropped []int
+	}{
+		{name: "reject", policy: QueuePolicyReject, capacity: 2, buf: []int{1, 2}, put: 3, wantErr: ErrQueueFull, wantBuf: []int{1, 2}},
+		{name: "drop oldest", policy: QueuePolicyDropOldest, capacity: 3, buf: []int{1, -2, -3}, put: 4, wantBuf: []int{1, -3, 4}, wantDropped: []int{-2}},
+		{name: "no droppable", policy: QueuePolicyDropOldest, capacity: 2, buf: []int{1, 2}, put: -3, wantErr: ErrQueueFull, wantBuf: []int{1, 2}},
+		{name: "not full", policy: QueuePolicyReject, capacity: 2, buf: []int{1}, put: 2, wantBuf: []int{1, 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var q = NewQueue[int](tt.capacity, tt.policy)
+			defer q.Close()
+			var dropped []int
+			q.SetDropFunc(func(v int) bool { return v < 0 }, func(v int) { dropped = append(dropped, v) })
+
+			if err := q.Put(0); err != nil {
+				t.Fatal(err)
+			}
+			waitHolding(t, q)
+			for _, v := range tt.buf {
+				if err := q.Put(v); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := q.Put(tt.put); !errors.Is(err, tt.wantErr) {
+				t.Errorf("put err=%v, want %v", err, tt.wantErr)
+			}
+			if q.Len() != len(tt.wantBuf)+1 {
+				t.Errorf("len=%d, want %d", q.Len(), len(tt.wantBuf)+1)
+			}
+			if !reflect.DeepEqual(dropped, tt.wantDropped) {
+				t.Errorf("dropped=%v, want %v", dropped, tt.wantDropped)
+			}
+			for _, want := range append([]int{0}, tt.wantBuf...) {
+				if v := <-q.C(); v != want {
+					t.Errorf("recv %d, want %d", v, want)
+				}
+			}
+		})
+	}
+}
+
+func TestQueueBlock(t *testing.T) {
+	var q = NewQueue[int](1, QueuePolicyBlock)
+	q.Put(1)
+	waitHolding(t, q)
+	q.Put(2)
+
+	var put = make(chan error, 1)
+	go func() { put <- q.Put(3) }()
+	select {
+	case err := <-put:
+		t.Fatalf("put not blocked, err=%v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if v := <-q.C(); v != 1 {
+		t.Fatalf("recv %d, want 1", v)
+	}
+	if err := <-put; err != nil {
+		t.Fatalf("put after recv err=%v", err)
+	}
+
+	// Close唤醒阻塞的发送方，pump中未读走的元素由Drain取出
+	waitHolding(t, q)
+	go func() { put <- q.Put(4) }()
+	time.Sleep(20 * time.Millisecond)
+	q.Close()
+	if err := <-put; !errors.Is(err, ErrClosed) {
+		t.Errorf("blocked put err=%v, want ErrClosed", err)
+	}
+	if err := q.Put(5); !errors.Is(err, ErrClosed) {
+		t.Errorf("put after close err=%v, want ErrClosed", err)
+	}
+	if drained := q.Drain(); !reflect.DeepEqual(drained, []int{2, 3}) {
+		t.Errorf("drain=%v, want [2 3]", drained)
+	}
+	if q.Len() != 0 {
+		t.Errorf("len=%d after drain", q.Len())
+	}
+}
+
+func TestQueueFailReq(t *testing.T) {
+	var tests = []struct {
+		name     string
+		policy   QueuePolicy
+		priority ReqPriority // 已在队列中的请求
+		wantErr  error
+	}{
+		{name: "reject", policy: QueuePolicyReject, priority: PriorityLow, wantErr: ErrQueueFull},
+		{name: "no droppable", policy: QueuePolicyDropOldest, priority: PriorityNormal, wantErr: ErrQueueFull},
+		{name: "dropped", policy: QueuePolicyDropOldest, priority: PriorityLow, wantErr: ErrQueueDropped},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var base = NewAsynBaseWithQueue(1, tt.policy)
+			defer base.Stop()
+
+			var errs = make(map[int]error)
+			var send = func(sender int, priority ReqPriority) {
+				var req = &testReq{Sender: sender}
+				req.SetPriority(priority)
+				base.SendReq(req, func(resp RespInf) AsynCBPtr {
+					errs[sender] = RespErr(resp)
+					return 0
+				})
+			}
+			send(0, PriorityNormal)
+			waitHolding(t, base.ReqChan)
+			send(1, tt.priority)
+			send(2, PriorityNormal)
+
+			base.HandleResp(<-base.Resp())
+			// 丢弃的是队列中的请求，拒绝的是新请求
+			var failed = 2
+			if tt.wantErr == ErrQueueDropped {
+				failed = 1
+			}
+			if len(errs) != 1 || !errors.Is(errs[failed], tt.wantErr) {
+				t.Errorf("errs=%v, want sender %d failed with %v", errs, failed, tt.wantErr)
+			}
+			if base.CallBackLen() != 2 {
+				t.Errorf("callbacks=%d, want 2", base.CallBackLen())
+			}
+		})
+	}
+}
+
+// 高lane先出队，低lane被跳过LaneStarveLimit次后出队一个
+func TestQueueLane(t *testing.T) {
+	var q = NewLaneQueue[int](0, QueuePolicyBlock, 3, func(v int) int { return v / 100 })
+	defer q.Close()
+
+	q.Put(1000)
+	waitHolding(t, q)
+	for _, v := range []int{200, 100, 101, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12} {
+		if err := q.Put(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 超出范围的归到最后一个lane
+	for lane, want := range []int{12, 2, 2} {
+		if n := q.LaneLen(lane); n != want {
+			t.Errorf("lane %d len=%d, want %d", lane, n, want)
+		}
+	}
+
+	var got []int
+	for len(got) < 16 {
+		got = append(got, <-q.C())
+	}
+	var want = []int{1000, 1, 2, 3, 4, 5, 6, 7, 8, 200, 100, 9, 10, 11, 12, 101}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got=%v, want %v", got, want)
+	}
+}
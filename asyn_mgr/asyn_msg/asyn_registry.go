@@ -0,0 +1,109 @@
+package asyn_msg
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+var (
+	ErrModuleIdConflict = errors.New("asyn module id conflict")
+)
+
+// 模块名和ID的注册表，启动时注册，用于检查ID冲突和按名字查找
+type moduleRegistry struct {
+	lock   sync.RWMutex
+	byName map[string]AsynModuleId
+	byId   map[AsynModuleId]string
+	next   AsynModuleId // 下一个分配的ID
+}
+
+var registry = &moduleRegistry{
+	byName: make(map[string]AsynModuleId),
+	byId:   make(map[AsynModuleId]string),
+	next:   DynamicModuleIdStart,
+}
+
+func init() {
+	for _, m := range []struct {
+		name string
+		id   AsynModuleId
+	}{
+		{"astar", AStarModuleId},
+		{"dbclient", DbClientModuleId},
+		{"db_pool", DbPoolModuleId},
+		{"http_svr", HttpSvrModuleId},
+		{"aoi", AoiModuleId},
+		{"etcd", EtcdModuleId},
+		{"dsa", DsaModuleId},
+		{"redis", RedisModuleId},
+	} {
+		MustRegisterModuleId(m.name, m.id)
+	}
+}
+
+// RegisterModuleId 按名字注册模块ID，id为0时从DynamicModuleIdStart开始分配
+// 同名同ID重复注册返回原ID，名字或ID已被占用时返回ErrModuleIdConflict
+func RegisterModuleId(name string, id AsynModuleId) (AsynModuleId, error) {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+	if old, ok := registry.byName[name]; ok {
+		if id == 0 || id == old {
+			return old, nil
+		}
+		return 0, fmt.Errorf("%w, name=%s, id=%d, registered id=%d", ErrModuleIdConflict, name, id, old)
+	}
+	if id == 0 {
+		for {
+			id = registry.next
+			registry.next++
+			if _, ok := registry.byId[id]; !ok {
+				break
+			}
+		}
+	} else if old, ok := registry.byId[id]; ok {
+		return 0, fmt.Errorf("%w, name=%s, id=%d, registered name=%s", ErrModuleIdConflict, name, id, old)
+	}
+	registry.byName[name] = id
+	registry.byId[id] = name
+	return id, nil
+}
+
+// MustRegisterModuleId 用于包初始化，冲突时panic
+//
+//	var MyModuleId = asyn_msg.MustRegisterModuleId("my_module", 0)
+func MustRegisterModuleId(name string, id AsynModuleId) AsynModuleId {
+	id, err := RegisterModuleId(name, id)
+	if err != nil {
+		panic(err)
+	}
+	return id
+}
+
+// ModuleIdByName 按名字查找已注册的模块ID
+func ModuleIdByName(name string) (AsynModuleId, bool) {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+	id, ok := registry.byName[name]
+	return id, ok
+}
+
+// ModuleName 未注册时返回""
+func ModuleName(id AsynModuleId) string {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+	return registry.byId[id]
+}
+
+// RegisteredModuleIds 已注册的模块ID，从小到大
+func RegisteredModuleIds() []AsynModuleId {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+	var ids = make([]AsynModuleId, 0, len(registry.byId))
+	for id := range registry.byId {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
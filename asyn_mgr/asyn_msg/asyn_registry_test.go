@@ -0,0 +1,42 @@
+package asyn_msg
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestModuleRegistry(t *testing.T) {
+	if id, ok := ModuleIdByName("redis"); !ok || id != RedisModuleId {
+		t.Errorf("redis id=%d, ok=%v", id, ok)
+	}
+	if name := ModuleName(DbPoolModuleId); name != "db_pool" {
+		t.Errorf("name=%s, want db_pool", name)
+	}
+
+	var id = MustRegisterModuleId("test_dynamic", 0)
+	if id < DynamicModuleIdStart {
+		t.Errorf("dynamic id=%d, want >= %d", id, DynamicModuleIdStart)
+	}
+	// 同名重复注册返回原ID
+	if again, err := RegisterModuleId("test_dynamic", 0); err != nil || again != id {
+		t.Errorf("register again id=%d, err=%v, want %d", again, err, id)
+	}
+	if _, err := RegisterModuleId("test_dynamic", id+1); !errors.Is(err, ErrModuleIdConflict) {
+		t.Errorf("rename id err=%v, want ErrModuleIdConflict", err)
+	}
+	if _, err := RegisterModuleId("test_other", RedisModuleId); !errors.Is(err, ErrModuleIdConflict) {
+		t.Errorf("duplicate id err=%v, want ErrModuleIdConflict", err)
+	}
+	// 分配时跳过已指定的ID
+	MustRegisterModuleId("test_fixed", id+1)
+	if next := MustRegisterModuleId("test_next", 0); next != id+2 {
+		t.Errorf("next id=%d, want %d", next, id+2)
+	}
+
+	var ids = RegisteredModuleIds()
+	for i := 1; i < len(ids); i++ {
+		if ids[i-1] >= ids[i] {
+			t.Fatalf("ids not sorted: %v", ids)
+		}
+	}
+}
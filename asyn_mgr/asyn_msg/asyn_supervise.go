@@ -0,0 +1,174 @@
+package asyn_msg
+
+import (
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gitlab.sunborngame.com/base/log"
+)
+
+var (
+	ErrPanic = errors.New("asyn module panic")
+	ErrStuck = errors.New("asyn req stuck")
+)
+
+// AsynWorking 模块正在处理的请求
+type AsynWorking struct {
+	Req   ReqInf
+	Start time.Time
+	Stuck bool // 已被watchdog判定卡住，回调已失败
+}
+
+// LoopStat 模块loop的运行情况
+type LoopStat struct {
+	Restarts  int32     // loop异常退出后重启的次数
+	LastCrash time.Time // 最近一次异常退出时间
+	Down      int32     // 正在退避等待重启的loop数
+}
+
+type loopState struct {
+	workLock sync.Mutex
+	working  map[*AsynWorking]struct{}
+
+	restarts  int32
+	lastCrash int64
+	down      int32
+}
+
+// Process 模块loop中处理请求时调用，panic时以RespError(ErrPanic)回调该请求，之后继续panic由loop处理
+func (this *AsynBase) Process(req ReqInf, handle func(ReqInf)) {
+	var working = &AsynWorking{
+		Req:   req,
+		Start: time.Now(),
+	}
+	this.workLock.Lock()
+	this.working[working] = struct{}{}
+	this.workLock.Unlock()
+
+	defer func() {
+		this.workLock.Lock()
+		delete(this.working, working)
+		var stuck = working.Stuck
+		this.workLock.Unlock()
+
+		if r := recover(); r != nil {
+			if !stuck {
+				this.failReq(req, fmt.Errorf("%w: %v", ErrPanic, r))
+			}
+			panic(r)
+		}
+	}()
+
+	handle(req)
+}
+
+// Working 正在处理的请求，按开始时间排序
+func (this *AsynBase) Working() []*AsynWorking {
+	this.workLock.Lock()
+	var working = make([]*AsynWorking, 0, len(this.working))
+	for w := range this.working {
+		var c = *w
+		working = append(working, &c)
+	}
+	this.workLock.Unlock()
+
+	sort.Slice(working, func(i, j int) bool { return working[i].Start.Before(working[j].Start) })
+	return working
+}
+
+// FailStuck 请求处理超时，以RespError(ErrStuck)回调，之后模块的响应找不到回调会被丢弃
+func (this *AsynBase) FailStuck(fcId uint64) bool {
+	this.workLock.Lock()
+	var req ReqInf
+	for w := range this.working {
+		if w.Req.GetFcId() == fcId && !w.Stuck {
+			w.Stuck = true
+			req = w.Req
+		}
+	}
+	this.workLock.Unlock()
+
+	if req == nil {
+		return false
+	}
+	this.lock.Lock()
+	if _, ok := this.callBacks[fcId]; ok {
+		this.markTimedOut(fcId)
+	}
+	this.lock.Unlock()
+	this.failReq(req, ErrStuck)
+	return true
+}
+
+func (this *AsynBase) GetLoopStat() LoopStat {
+	var stat = LoopStat{
+		Restarts: atomic.LoadInt32(&this.restarts),
+		Down:     atomic.LoadInt32(&this.down),
+	}
+	if ts := atomic.LoadInt64(&this.lastCrash); ts > 0 {
+		stat.LastCrash = time.Unix(0, ts)
+	}
+	return stat
+}
+
+// Go 启动模块loop，loop在模块Stop前退出(包括panic)时按退避时间重启
+func (this *AsynBase) Go(loop func()) {
+	this.GoUntil(this.Done(), loop)
+}
+
+// GoUntil 同Go，stop关闭后loop退出不再重启，用于模块内有独立生命周期的loop
+func (this *AsynBase) GoUntil(stop <-chan struct{}, loop func()) {
+	go this.supervise(stop, loop)
+}
+
+func (this *AsynBase) supervise(stop <-chan struct{}, loop func()) {
+	var backoff = LoopMinBackoff
+	for {
+		var start = time.Now()
+		this.runLoop(loop)
+
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		if time.Since(start) >= LoopStableAfter {
+			backoff = LoopMinBackoff
+		}
+		atomic.AddInt32(&this.restarts, 1)
+		atomic.StoreInt64(&this.lastCrash, time.Now().UnixNano())
+		log.Error("asyn module loop exit, restart after %v, restarts=%d", backoff, atomic.LoadInt32(&this.restarts))
+
+		atomic.AddInt32(&this.down, 1)
+		var timer = time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-stop:
+			timer.Stop()
+			atomic.AddInt32(&this.down, -1)
+			return
+		}
+		atomic.AddInt32(&this.down, -1)
+
+		backoff *= 2
+		if backoff > LoopMaxBackoff {
+			backoff = LoopMaxBackoff
+		}
+	}
+}
+
+// 模块loop内一般已有utils.Recover，这里兜底
+func (this *AsynBase) runLoop(loop func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Error("asyn module loop panic, err=%v, stack=%s", r, debug.Stack())
+		}
+	}()
+	loop()
+}
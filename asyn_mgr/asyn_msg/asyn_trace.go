@@ -0,0 +1,111 @@
+package asyn_msg
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+)
+
+type (
+	TraceId [16]byte
+	SpanId  [8]byte
+
+	// TraceCtx 请求的追踪上下文，SendReq时由AsynBase生成
+	TraceCtx struct {
+		TraceId  TraceId
+		SpanId   SpanId
+		ParentId SpanId // 为空表示根span
+		Oneway   bool   // 请求没有回调，不会有OnDone，出队时结束
+	}
+)
+
+func (this TraceId) IsZero() bool {
+	return this == TraceId{}
+}
+
+func (this TraceId) String() string {
+	return hex.EncodeToString(this[:])
+}
+
+func (this SpanId) IsZero() bool {
+	return this == SpanId{}
+}
+
+func (this SpanId) String() string {
+	if this.IsZero() {
+		return ""
+	}
+	return hex.EncodeToString(this[:])
+}
+
+func (this TraceCtx) IsZero() bool {
+	return this.TraceId.IsZero()
+}
+
+func NewTraceId() TraceId {
+	var id TraceId
+	binary.BigEndian.PutUint64(id[:8], rand.Uint64())
+	binary.BigEndian.PutUint64(id[8:], rand.Uint64())
+	return id
+}
+
+func NewSpanId() SpanId {
+	var id SpanId
+	for id.IsZero() {
+		binary.BigEndian.PutUint64(id[:], rand.Uint64())
+	}
+	return id
+}
+
+// ChildOf 以parent为父span创建新的上下文，parent为空时开始新的trace
+func ChildOf(parent TraceCtx) TraceCtx {
+	var ctx = TraceCtx{
+		TraceId:  parent.TraceId,
+		SpanId:   NewSpanId(),
+		ParentId: parent.SpanId,
+	}
+	if ctx.TraceId.IsZero() {
+		ctx.TraceId = NewTraceId()
+	}
+	return ctx
+}
+
+var (
+	traceEnabled int32
+
+	// 每个发送方goroutine(以RespOwner区分，主逻辑为nil)当前所在的span
+	// 回调执行期间为回调span，回调内发出的请求自动成为其子span
+	traceLock    sync.Mutex
+	traceCurrent = make(map[RespOwner]TraceCtx)
+)
+
+// EnableTrace 启动时调用，开启后SendReq和HandleResp自动维护追踪上下文
+func EnableTrace() {
+	atomic.StoreInt32(&traceEnabled, 1)
+}
+
+func TraceEnabled() bool {
+	return atomic.LoadInt32(&traceEnabled) != 0
+}
+
+// CurrentTrace owner当前所在的span
+func CurrentTrace(owner RespOwner) TraceCtx {
+	traceLock.Lock()
+	defer traceLock.Unlock()
+	return traceCurrent[owner]
+}
+
+// SetCurrentTrace 设置owner当前所在的span，返回之前的span用于恢复
+func SetCurrentTrace(owner RespOwner, ctx TraceCtx) TraceCtx {
+	traceLock.Lock()
+	defer traceLock.Unlock()
+	var prev = traceCurrent[owner]
+	if ctx.IsZero() {
+		delete(traceCurrent, owner)
+	} else {
+		traceCurrent[owner] = ctx
+	}
+	return prev
+}
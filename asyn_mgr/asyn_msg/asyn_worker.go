@@ -0,0 +1,200 @@
+package asyn_msg
+
+import (
+	"runtime/debug"
+	"sync"
+
+	"gitlab.sunborngame.com/base/log"
+)
+
+// OrderKeyFunc 请求的顺序键，相同键的请求按到达顺序依次处理，""表示没有顺序要求
+type OrderKeyFunc func(req ReqInf) string
+
+// OrderKeysFunc 涉及多个键的请求，排在每个键之前的请求之后执行，空表示没有顺序要求
+type OrderKeysFunc func(req ReqInf) []string
+
+// 分发的一个请求，在所有键上都排到队首时可以执行
+type orderTask struct {
+	req     ReqInf
+	keys    []string
+	waiting int // 还没排到队首的键数
+}
+
+// WorkerPool 模块loop取出请求后交给多个worker并发处理，相同顺序键的请求保持FIFO
+// 键之间轮流执行，一个键的请求慢不会阻塞其他键
+type WorkerPool struct {
+	base   *AsynBase
+	size   int
+	keysOf OrderKeysFunc
+	handle func(ReqInf)
+
+	lock     sync.Mutex
+	cond     *sync.Cond
+	ready    []*orderTask            // 可以执行的请求
+	chains   map[string][]*orderTask // 键上等待或正在执行的请求，队首在执行或等待其他键
+	queued   int                     // 已分发未执行的请求数
+	capacity int
+	closed   bool
+}
+
+// NewWorkerPool size为worker数，keyOf为nil时请求之间没有顺序要求
+func NewWorkerPool(base *AsynBase, size int, keyOf OrderKeyFunc, handle func(ReqInf)) *WorkerPool {
+	var keysOf OrderKeysFunc
+	if keyOf != nil {
+		keysOf = func(req ReqInf) []string {
+			if key := keyOf(req); key != "" {
+				return []string{key}
+			}
+			return nil
+		}
+	}
+	return NewWorkerPoolKeys(base, size, keysOf, handle)
+}
+
+// NewWorkerPoolKeys 请求可以有多个顺序键，如MGet、MSet
+func NewWorkerPoolKeys(base *AsynBase, size int, keysOf OrderKeysFunc, handle func(ReqInf)) *WorkerPool {
+	if size < 1 {
+		size = 1
+	}
+	var pool = &WorkerPool{
+		base:     base,
+		size:     size,
+		keysOf:   keysOf,
+		handle:   handle,
+		chains:   make(map[string][]*orderTask),
+		capacity: WorkerPoolQueueCap,
+	}
+	pool.cond = sync.NewCond(&pool.lock)
+	return pool
+}
+
+// Start 启动worker，模块Stop后退出
+func (this *WorkerPool) Start() {
+	for i := 0; i < this.size; i++ {
+		this.base.Go(this.loop)
+	}
+	go func() {
+		<-this.base.Done()
+		this.lock.Lock()
+		this.closed = true
+		this.cond.Broadcast()
+		this.lock.Unlock()
+	}()
+}
+
+func (this *WorkerPool) Size() int {
+	return this.size
+}
+
+// Queued 已分发、等待worker执行的请求数
+func (this *WorkerPool) Queued() int {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.queued
+}
+
+// Dispatch 在模块loop中调用，等待执行的请求达到WorkerPoolQueueCap时阻塞，其余请求留在模块队列中按优先级排队
+func (this *WorkerPool) Dispatch(req ReqInf) {
+	var task = &orderTask{req: req}
+	if this.keysOf != nil {
+		task.keys = uniqueKeys(this.keysOf(req))
+	}
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	for this.queued >= this.capacity && !this.closed {
+		this.cond.Wait()
+	}
+	if this.closed {
+		// 回调由模块关闭流程处理
+		return
+	}
+	this.queued++
+
+	for _, key := range task.keys {
+		if len(this.chains[key]) > 0 {
+			task.waiting++
+		}
+		this.chains[key] = append(this.chains[key], task)
+	}
+	if task.waiting == 0 {
+		this.ready = append(this.ready, task)
+		this.cond.Broadcast()
+	}
+}
+
+// 去掉重复和空的键，同一个键只排一次
+func uniqueKeys(keys []string) []string {
+	var ret = keys[:0:0]
+	for i, key := range keys {
+		var dup = key == ""
+		for _, prev := range keys[:i] {
+			dup = dup || prev == key
+		}
+		if !dup {
+			ret = append(ret, key)
+		}
+	}
+	return ret
+}
+
+// 取出一个可执行的请求，关闭时返回nil
+func (this *WorkerPool) take() *orderTask {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	for len(this.ready) == 0 && !this.closed {
+		this.cond.Wait()
+	}
+	if this.closed {
+		return nil
+	}
+	var task = this.ready[0]
+	this.ready[0] = nil
+	this.ready = this.ready[1:]
+	this.queued--
+	this.cond.Broadcast()
+	return task
+}
+
+// 请求执行完，各个键的下一个请求排到队首，所有键都排到时排到最后
+func (this *WorkerPool) done(task *orderTask) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	for _, key := range task.keys {
+		var chain = this.chains[key]
+		chain[0] = nil
+		chain = chain[1:]
+		if len(chain) == 0 {
+			delete(this.chains, key)
+			continue
+		}
+		this.chains[key] = chain
+		var next = chain[0]
+		next.waiting--
+		if next.waiting == 0 {
+			this.ready = append(this.ready, next)
+			this.cond.Broadcast()
+		}
+	}
+}
+
+func (this *WorkerPool) loop() {
+	for {
+		task := this.take()
+		if task == nil {
+			return
+		}
+		this.run(task)
+	}
+}
+
+// panic时Process已以ErrPanic回调请求，worker继续处理
+func (this *WorkerPool) run(task *orderTask) {
+	defer func() {
+		this.done(task)
+		if r := recover(); r != nil {
+			log.Error("asyn worker panic, moduleId=%d, err=%v, stack=%s", task.req.GetModuleId(), r, debug.Stack())
+		}
+	}()
+	this.base.Process(task.req, this.handle)
+}
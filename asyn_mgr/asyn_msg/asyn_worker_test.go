@@ -0,0 +1,144 @@
+package asyn_msg
+
+import (
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWorkerPool(t *testing.T) {
+	var base = NewAsynBase()
+	defer base.Stop()
+
+	var block = make(chan struct{})
+	var lock sync.Mutex
+	var handled = make(map[string][]int)
+	var running = make(map[string]int)
+	var keyOf = func(req ReqInf) string {
+		return strconv.Itoa(req.(*testReq).Sender % 3)
+	}
+	var pool = NewWorkerPool(base, 4, keyOf, func(req ReqInf) {
+		var sender, key = req.(*testReq).Sender, keyOf(req)
+		lock.Lock()
+		running[key]++
+		if running[key] > 1 {
+			t.Errorf("key %s running concurrently", key)
+		}
+		lock.Unlock()
+		defer func() {
+			lock.Lock()
+			running[key]--
+			handled[key] = append(handled[key], sender)
+			lock.Unlock()
+		}()
+
+		if sender == 0 {
+			<-block
+		}
+		if sender == 30 {
+			panic("test panic")
+		}
+	})
+	pool.Start()
+
+	var waitHandled = func(want map[string]int) {
+		t.Helper()
+		for i := 0; i < 1000; i++ {
+			lock.Lock()
+			var ok = true
+			for key, n := range want {
+				ok = ok && len(handled[key]) == n
+			}
+			lock.Unlock()
+			if ok {
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+		lock.Lock()
+		defer lock.Unlock()
+		t.Fatalf("handled=%v, want counts %v", handled, want)
+	}
+
+	for i := 0; i < 30; i++ {
+		pool.Dispatch(&testReq{Sender: i})
+	}
+	// key 0阻塞时其他key不受影响
+	waitHandled(map[string]int{"0": 0, "1": 10, "2": 10})
+	if n := pool.Queued(); n != 9 {
+		t.Errorf("queued=%d, want 9", n)
+	}
+
+	close(block)
+	// panic的请求之后同一个key继续执行
+	pool.Dispatch(&testReq{Sender: 30})
+	pool.Dispatch(&testReq{Sender: 33})
+	waitHandled(map[string]int{"0": 12})
+
+	lock.Lock()
+	defer lock.Unlock()
+	for key, senders := range handled {
+		if !sort.IntsAreSorted(senders) {
+			t.Errorf("key %s handled out of order: %v", key, senders)
+		}
+	}
+	if want := []int{0, 3, 6, 9, 12, 15, 18, 21, 24, 27, 30, 33}; !reflect.DeepEqual(handled["0"], want) {
+		t.Errorf("key 0 handled=%v", handled["0"])
+	}
+}
+
+// 多键请求排在每个键之前的请求之后，之后的请求也排在它之后
+func TestWorkerPoolKeys(t *testing.T) {
+	var base = NewAsynBase()
+	defer base.Stop()
+
+	var keys = map[int][]string{0: {"a"}, 1: {"a", "b", "a"}, 2: {"b"}, 3: {"c"}, 4: nil}
+	var block = make(chan struct{})
+	var lock sync.Mutex
+	var handled []int
+	var pool = NewWorkerPoolKeys(base, 4, func(req ReqInf) []string {
+		return keys[req.(*testReq).Sender]
+	}, func(req ReqInf) {
+		var sender = req.(*testReq).Sender
+		if sender == 0 {
+			<-block
+		}
+		lock.Lock()
+		handled = append(handled, sender)
+		lock.Unlock()
+	})
+	pool.Start()
+
+	var waitHandled = func(n int) []int {
+		t.Helper()
+		for i := 0; i < 1000; i++ {
+			lock.Lock()
+			var ret = append([]int(nil), handled...)
+			lock.Unlock()
+			if len(ret) >= n {
+				return ret
+			}
+			time.Sleep(time.Millisecond)
+		}
+		t.Fatalf("handled=%v, want %d", handled, n)
+		return nil
+	}
+	for i := 0; i < 5; i++ {
+		pool.Dispatch(&testReq{Sender: i})
+	}
+	// b上的请求等待a、b上的多键请求
+	if got := waitHandled(2); len(got) != 2 || got[0]+got[1] != 7 {
+		t.Errorf("handled=%v before release, want 3 and 4", got)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if n := pool.Queued(); n != 2 {
+		t.Errorf("queued=%d, want 2", n)
+	}
+	close(block)
+	if got := waitHandled(5); !reflect.DeepEqual(got[2:], []int{0, 1, 2}) {
+		t.Errorf("handled=%v, want 0, 1, 2 in order", got)
+	}
+}
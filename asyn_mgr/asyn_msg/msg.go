@@ -1,7 +1,16 @@
 package asyn_msg
 
 import (
+	"errors"
+	"fmt"
 	"px/common/message"
+	"reflect"
+	"strings"
+	"sync/atomic"
+)
+
+var (
+	ErrClosed = errors.New("asyn module closed")
 )
 
 type (
@@ -11,23 +20,63 @@ type (
 
 	AsynModInf interface {
 		GetModuleId() AsynModuleId
+		// 队列中等待处理的请求数
 		ReqLen() int
+		// 请求队列容量和溢出策略
+		SetReqQueueOpt(capacity int, policy QueuePolicy)
+		ReqQueueCap() int
 		Resp() <-chan RespInf
 		Init()
 		// 如果有嵌套，可以很方便的获取到最内层的回调
 		SendReq(ReqInf, AsynCallback)
+		// 非主逻辑goroutine发请求，响应投递给owner
+		SendReqFrom(RespOwner, ReqInf, AsynCallback)
+		GetRespOwner(fcId uint64) RespOwner
 		HandleResp(inf RespInf) AsynCBPtr
+		// 检查超时请求，超时的以RespTimeout投递到Resp()
+		CheckTimeout(now int64)
+		// 停止接收新请求，之后的请求直接以RespError(ErrClosed)返回
+		StopAccept()
+		CallBackLen() int
+		PendingFcIds() []uint64
+		// 模块loop退出信号，Close时关闭
+		Done() <-chan struct{}
+		GetAsynBase() *AsynBase
 		Close()
 	}
+	// 响应的投递目标，需线程安全
+	RespOwner interface {
+		PutResp(RespInf)
+	}
 	ReqInf interface {
 		SetFcId(uint64)
 		GetFcId() uint64
 		GetModuleId() AsynModuleId
+		// 超时时间(ms)，<=0表示不超时
+		SetTimeout(int64)
+		GetTimeout() int64
+		// 高优先级先出队，队列满时低优先级请求可能被丢弃
+		SetPriority(ReqPriority)
+		GetPriority() ReqPriority
+		// 发送、出队时间(UnixNano)，由AsynBase设置
+		SetSendTime(int64)
+		GetSendTime() int64
+		SetDequeueTime(int64)
+		GetDequeueTime() int64
+		// 追踪上下文，开启追踪后由AsynBase设置
+		SetTrace(TraceCtx)
+		GetTrace() TraceCtx
 	}
 	RespInf interface {
 		SetFcId(uint64)
 		GetFcId() uint64
 		GetModuleId() AsynModuleId
+		// 响应产出时间(UnixNano)，由AsynBase设置
+		SetRespTime(int64)
+		GetRespTime() int64
+		// 与请求相同的追踪上下文，回调前由AsynBase设置
+		SetTrace(TraceCtx)
+		GetTrace() TraceCtx
 	}
 	RespMsgInf interface {
 		GetMessage() message.Message
@@ -36,10 +85,17 @@ type (
 	}
 
 	ReqBase struct {
-		fcId uint64
+		fcId      uint64
+		timeout   int64 // ms
+		priority  ReqPriority
+		sendTs    int64
+		dequeueTs int64 // 队列goroutine写入，原子操作
+		trace     TraceCtx
 	}
 	RespBase struct {
-		fcId uint64
+		fcId   uint64
+		respTs int64
+		trace  TraceCtx
 	}
 	RespMsg struct {
 		RespBase
@@ -48,8 +104,71 @@ type (
 		Broadcast bool
 		ModuleId  AsynModuleId
 	}
+	// 请求失败响应，模块关闭等情况下由AsynBase投递给原回调
+	RespError struct {
+		RespBase
+		ModuleId AsynModuleId
+		Req      ReqInf
+		Err      error
+	}
+	// 请求超时响应，超过deadline后由AsynBase投递给原回调
+	RespTimeout struct {
+		RespBase
+		ModuleId AsynModuleId
+		Req      ReqInf
+	}
 )
 
+// CBPtr 获取回调函数指针，用于pprof
+func CBPtr(cb any) AsynCBPtr {
+	return AsynCBPtr(reflect.ValueOf(cb).Pointer())
+}
+
+// ShallowCopy 浅拷贝结构体指针，用于重发请求、共享响应时互不影响fcId等字段，其他类型原样返回
+func ShallowCopy[T any](msg T) T {
+	var v = reflect.ValueOf(msg)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return msg
+	}
+	var c = reflect.New(v.Elem().Type())
+	c.Elem().Set(v.Elem())
+	return c.Interface().(T)
+}
+
+// ScalarKey 由标量拼接唯一的key，用于请求合并等，每部分带类型和长度，内容中的分隔符不会产生歧义
+// 有非标量参数(nil、指针、结构体、[]byte以外的切片等)时返回false，这类请求不应合并
+func ScalarKey(parts ...any) (string, bool) {
+	var b strings.Builder
+	for _, part := range parts {
+		var s string
+		switch v := part.(type) {
+		case string:
+			s = v
+		case []byte:
+			s = string(v)
+		case bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+			s = fmt.Sprint(v)
+		default:
+			return "", false
+		}
+		fmt.Fprintf(&b, "%T:%d:%s", part, len(s), s)
+	}
+	return b.String(), true
+}
+
+// ReqCloner 有模块内部状态的请求实现，重发时返回重置了内部状态的新请求
+type ReqCloner interface {
+	CloneReq() ReqInf
+}
+
+// CloneReq 重发请求时使用新的请求对象，实现ReqCloner时由请求自己拷贝，否则浅拷贝
+func CloneReq(req ReqInf) ReqInf {
+	if c, ok := req.(ReqCloner); ok {
+		return c.CloneReq()
+	}
+	return ShallowCopy(req)
+}
+
 func (this *ReqBase) SetFcId(fcId uint64) {
 	this.fcId = fcId
 }
@@ -58,6 +177,62 @@ func (this *ReqBase) GetFcId() uint64 {
 	return this.fcId
 }
 
+func (this *ReqBase) SetTimeout(timeout int64) {
+	this.timeout = timeout
+}
+
+func (this *ReqBase) GetTimeout() int64 {
+	return this.timeout
+}
+
+func (this *ReqBase) SetPriority(priority ReqPriority) {
+	this.priority = priority
+}
+
+func (this *ReqBase) GetPriority() ReqPriority {
+	return this.priority
+}
+
+func (this *ReqBase) SetSendTime(ts int64) {
+	this.sendTs = ts
+}
+
+func (this *ReqBase) GetSendTime() int64 {
+	return this.sendTs
+}
+
+func (this *ReqBase) SetDequeueTime(ts int64) {
+	atomic.StoreInt64(&this.dequeueTs, ts)
+}
+
+func (this *ReqBase) GetDequeueTime() int64 {
+	return atomic.LoadInt64(&this.dequeueTs)
+}
+
+func (this *ReqBase) SetTrace(ctx TraceCtx) {
+	this.trace = ctx
+}
+
+func (this *ReqBase) GetTrace() TraceCtx {
+	return this.trace
+}
+
+func (this *RespBase) SetTrace(ctx TraceCtx) {
+	this.trace = ctx
+}
+
+func (this *RespBase) GetTrace() TraceCtx {
+	return this.trace
+}
+
+func (this *RespBase) SetRespTime(ts int64) {
+	this.respTs = ts
+}
+
+func (this *RespBase) GetRespTime() int64 {
+	return this.respTs
+}
+
 func (this *RespBase) SetFcId(fcId uint64) {
 	this.fcId = fcId
 }
@@ -81,3 +256,42 @@ func (this *RespMsg) BroadcastAll() bool {
 func (this *RespMsg) GetModuleId() AsynModuleId {
 	return this.ModuleId
 }
+
+func NewRespTimeout(moduleId AsynModuleId, req ReqInf) *RespTimeout {
+	var resp = &RespTimeout{
+		ModuleId: moduleId,
+		Req:      req,
+	}
+	resp.SetFcId(req.GetFcId())
+	return resp
+}
+
+func (this *RespTimeout) GetModuleId() AsynModuleId {
+	return this.ModuleId
+}
+
+func (this *RespTimeout) Error() string {
+	return fmt.Sprintf("asyn req timeout, moduleId=%d, fcId=%d, timeout=%dms", this.ModuleId, this.GetFcId(), this.Req.GetTimeout())
+}
+
+func NewRespError(moduleId AsynModuleId, req ReqInf, err error) *RespError {
+	var resp = &RespError{
+		ModuleId: moduleId,
+		Req:      req,
+		Err:      err,
+	}
+	resp.SetFcId(req.GetFcId())
+	return resp
+}
+
+func (this *RespError) GetModuleId() AsynModuleId {
+	return this.ModuleId
+}
+
+func (this *RespError) Error() string {
+	return fmt.Sprintf("asyn req failed, moduleId=%d, fcId=%d, err=%v", this.ModuleId, this.GetFcId(), this.Err)
+}
+
+func (this *RespError) Unwrap() error {
+	return this.Err
+}
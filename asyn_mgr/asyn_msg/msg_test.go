@@ -0,0 +1,41 @@
+package asyn_msg
+
+import (
+	"testing"
+)
+
+func TestScalarKey(t *testing.T) {
+	var key = func(parts ...any) string {
+		t.Helper()
+		k, ok := ScalarKey(parts...)
+		if !ok {
+			t.Fatalf("parts %v not scalar", parts)
+		}
+		return k
+	}
+	// %v拼接时相同的参数
+	var collisions = [][2][]any{
+		{{"select ?", "a b", "c"}, {"select ?", "a", "b c"}},
+		{{"select a|b", "c"}, {"select a", "b|c"}},
+		{{"select ?", "1"}, {"select ?", 1}},
+		{{"select ?", int32(1)}, {"select ?", int64(1)}},
+		{{"select ?", []byte("ab")}, {"select ?", "ab"}},
+		{{"select ?", []byte{1, 2}}, {"select ?", "[1 2]"}},
+		{{"select ?", ""}, {"select ?"}},
+	}
+	for _, c := range collisions {
+		if key(c[0]...) == key(c[1]...) {
+			t.Errorf("key collision, %v and %v", c[0], c[1])
+		}
+	}
+	if key("select ?", "a", int64(1), []byte{1}) != key("select ?", "a", int64(1), []byte{1}) {
+		t.Errorf("same parts different key")
+	}
+
+	var n = 1
+	for _, parts := range [][]any{{"select ?", &n}, {"select ?", nil}, {"select ?", []int{1}}, {"select ?", struct{}{}}} {
+		if _, ok := ScalarKey(parts...); ok {
+			t.Errorf("parts %v should not be scalar", parts)
+		}
+	}
+}
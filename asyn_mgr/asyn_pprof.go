@@ -0,0 +1,133 @@
+package asyn_mgr
+
+import (
+	"compress/gzip"
+	"io"
+	"time"
+)
+
+// WritePprof 输出pprof格式(gzip压缩的profile.proto)，可用go tool pprof查看
+// 每个回调是一个sample，value为[调用次数, 总耗时ns]
+func (this *CBProfiler) WritePprof(w io.Writer) error {
+	var profiles = this.Top(0)
+	this.lock.Lock()
+	var start = this.start
+	this.lock.Unlock()
+
+	var strs = newStringTable()
+	var b pbBuffer
+
+	// sample_type = 1
+	b.bytes(1, valueType(strs.id("calls"), strs.id("count")))
+	b.bytes(1, valueType(strs.id("cost"), strs.id("nanoseconds")))
+
+	for i, p := range profiles {
+		var id = uint64(i + 1)
+		// sample = 2
+		var sample pbBuffer
+		sample.packed(1, []uint64{id})
+		sample.packed(2, []uint64{p.Count, uint64(p.Total.Nanoseconds())})
+		b.bytes(2, sample.buf)
+
+		// location = 4
+		var line pbBuffer
+		line.uint64(1, id)
+		line.uint64(2, uint64(p.Line))
+		var location pbBuffer
+		location.uint64(1, id)
+		location.uint64(3, uint64(p.Ptr))
+		location.bytes(4, line.buf)
+		b.bytes(4, location.buf)
+
+		// function = 5
+		var function pbBuffer
+		function.uint64(1, id)
+		function.uint64(2, strs.id(p.Name))
+		function.uint64(3, strs.id(p.Name))
+		function.uint64(4, strs.id(p.File))
+		function.uint64(5, uint64(p.Line))
+		b.bytes(5, function.buf)
+	}
+
+	// time_nanos = 9, duration_nanos = 10, period_type = 11, period = 12
+	b.uint64(9, uint64(start.UnixNano()))
+	b.uint64(10, uint64(time.Since(start).Nanoseconds()))
+	b.bytes(11, valueType(strs.id("calls"), strs.id("count")))
+	b.uint64(12, 1)
+
+	// string_table = 6，需在最后编码以包含所有字符串
+	for _, s := range strs.strs {
+		b.bytes(6, []byte(s))
+	}
+
+	var zw = gzip.NewWriter(w)
+	if _, err := zw.Write(b.buf); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+func valueType(tp, unit uint64) []byte {
+	var b pbBuffer
+	b.uint64(1, tp)
+	b.uint64(2, unit)
+	return b.buf
+}
+
+type stringTable struct {
+	strs []string
+	ids  map[string]uint64
+}
+
+func newStringTable() *stringTable {
+	// 第0个必须是空字符串
+	return &stringTable{
+		strs: []string{""},
+		ids:  map[string]uint64{"": 0},
+	}
+}
+
+func (this *stringTable) id(s string) uint64 {
+	if id, ok := this.ids[s]; ok {
+		return id
+	}
+	id := uint64(len(this.strs))
+	this.strs = append(this.strs, s)
+	this.ids[s] = id
+	return id
+}
+
+// pbBuffer 最小的protobuf编码，只用到varint和length-delimited
+type pbBuffer struct {
+	buf []byte
+}
+
+func (this *pbBuffer) varint(x uint64) {
+	for x >= 0x80 {
+		this.buf = append(this.buf, byte(x)|0x80)
+		x >>= 7
+	}
+	this.buf = append(this.buf, byte(x))
+}
+
+func (this *pbBuffer) uint64(tag int, x uint64) {
+	if x == 0 {
+		return
+	}
+	this.varint(uint64(tag)<<3 | 0)
+	this.varint(x)
+}
+
+func (this *pbBuffer) bytes(tag int, data []byte) {
+	this.varint(uint64(tag)<<3 | 2)
+	this.varint(uint64(len(data)))
+	this.buf = append(this.buf, data...)
+}
+
+func (this *pbBuffer) packed(tag int, xs []uint64) {
+	var inner pbBuffer
+	for _, x := range xs {
+		inner.varint(x)
+	}
+	this.bytes(tag, inner.buf)
+}
@@ -0,0 +1,150 @@
+package asyn_mgr
+
+import (
+	"bytes"
+	"fmt"
+	"px/shared/asyn_mgr/asyn_msg"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	ProfilerSampleCap = 1024 // 每个回调保留最近的耗时样本，用于计算p99
+)
+
+// CBProfile 单个回调的耗时统计
+type CBProfile struct {
+	Ptr   asyn_msg.AsynCBPtr
+	Name  string
+	File  string
+	Line  int
+	Count uint64
+	Total time.Duration
+	Max   time.Duration
+	P99   time.Duration
+}
+
+type cbStat struct {
+	count   uint64
+	total   time.Duration
+	max     time.Duration
+	samples []time.Duration // 环形缓冲
+	next    int
+}
+
+// CBProfiler 按AsynCBPtr聚合HandleResp中的回调耗时
+type CBProfiler struct {
+	lock  sync.Mutex
+	stats map[asyn_msg.AsynCBPtr]*cbStat
+	start time.Time
+}
+
+func NewCBProfiler() *CBProfiler {
+	return &CBProfiler{
+		stats: make(map[asyn_msg.AsynCBPtr]*cbStat),
+		start: time.Now(),
+	}
+}
+
+func (this *CBProfiler) Record(ptr asyn_msg.AsynCBPtr, cost time.Duration) {
+	if ptr == 0 {
+		return
+	}
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	stat, ok := this.stats[ptr]
+	if !ok {
+		stat = &cbStat{samples: make([]time.Duration, 0, ProfilerSampleCap)}
+		this.stats[ptr] = stat
+	}
+	stat.count++
+	stat.total += cost
+	if cost > stat.max {
+		stat.max = cost
+	}
+	if len(stat.samples) < ProfilerSampleCap {
+		stat.samples = append(stat.samples, cost)
+	} else {
+		stat.samples[stat.next] = cost
+		stat.next = (stat.next + 1) % ProfilerSampleCap
+	}
+}
+
+func (this *CBProfiler) Reset() {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.stats = make(map[asyn_msg.AsynCBPtr]*cbStat)
+	this.start = time.Now()
+}
+
+// Top 按总耗时排序，topN<=0返回全部
+func (this *CBProfiler) Top(topN int) []*CBProfile {
+	this.lock.Lock()
+	var profiles = make([]*CBProfile, 0, len(this.stats))
+	for ptr, stat := range this.stats {
+		profiles = append(profiles, &CBProfile{
+			Ptr:   ptr,
+			Count: stat.count,
+			Total: stat.total,
+			Max:   stat.max,
+			P99:   percentile(stat.samples, 0.99),
+		})
+	}
+	this.lock.Unlock()
+
+	sort.Slice(profiles, func(i, j int) bool {
+		return profiles[i].Total > profiles[j].Total
+	})
+	if topN > 0 && len(profiles) > topN {
+		profiles = profiles[:topN]
+	}
+	for _, profile := range profiles {
+		profile.Name, profile.File, profile.Line = funcInfo(profile.Ptr)
+	}
+	return profiles
+}
+
+// Report 文本格式的topN报告
+func (this *CBProfiler) Report(topN int) string {
+	var buf bytes.Buffer
+	this.lock.Lock()
+	var since = time.Since(this.start)
+	this.lock.Unlock()
+
+	fmt.Fprintf(&buf, "asyn callback profile, duration=%v\n", since.Truncate(time.Second))
+	fmt.Fprintf(&buf, "%10s %12s %10s %10s %10s  %s\n", "count", "total", "avg", "p99", "max", "callback")
+	for _, p := range this.Top(topN) {
+		fmt.Fprintf(&buf, "%10d %12v %10v %10v %10v  %s (%s:%d)\n",
+			p.Count, p.Total, p.Total/time.Duration(p.Count), p.P99, p.Max, p.Name, p.File, p.Line)
+	}
+	return buf.String()
+}
+
+func percentile(samples []time.Duration, p float64) time.Duration {
+	if len(samples) == 0 {
+		return 0
+	}
+	var sorted = make([]time.Duration, len(samples))
+	copy(sorted, samples)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	idx := int(float64(len(sorted))*p+0.5) - 1
+	if idx < 0 {
+		idx = 0
+	}
+	if idx >= len(sorted) {
+		idx = len(sorted) - 1
+	}
+	return sorted[idx]
+}
+
+func funcInfo(ptr asyn_msg.AsynCBPtr) (name string, file string, line int) {
+	fn := runtime.FuncForPC(uintptr(ptr))
+	if fn == nil {
+		return fmt.Sprintf("0x%x", uintptr(ptr)), "", 0
+	}
+	file, line = fn.FileLine(fn.Entry())
+	return fn.Name(), file, line
+}
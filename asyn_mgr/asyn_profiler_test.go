@@ -0,0 +1,159 @@
+package asyn_mgr
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"px/shared/asyn_mgr/asyn_msg"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testCbFast(asyn_msg.RespInf) asyn_msg.AsynCBPtr { return 0 }
+
+func testCbSlow(asyn_msg.RespInf) asyn_msg.AsynCBPtr { return 0 }
+
+func testCbRing(asyn_msg.RespInf) asyn_msg.AsynCBPtr { return 0 }
+
+func TestCBProfiler(t *testing.T) {
+	var profiler = NewCBProfiler()
+	var fast, slow, ring = asyn_msg.CBPtr(testCbFast), asyn_msg.CBPtr(testCbSlow), asyn_msg.CBPtr(testCbRing)
+
+	for i := 1; i <= 100; i++ {
+		profiler.Record(slow, time.Duration(i)*time.Millisecond)
+		profiler.Record(fast, time.Microsecond)
+	}
+	// 环形缓冲只保留最近的样本
+	for i := 0; i < ProfilerSampleCap; i++ {
+		profiler.Record(ring, time.Millisecond)
+	}
+	for i := 0; i < ProfilerSampleCap; i++ {
+		profiler.Record(ring, 2*time.Millisecond)
+	}
+	profiler.Record(0, time.Second)
+
+	var top = profiler.Top(0)
+	if len(top) != 3 {
+		t.Fatalf("profiles=%d, want 3", len(top))
+	}
+	if top[0].Ptr != slow || top[1].Ptr != ring || top[2].Ptr != fast {
+		t.Errorf("top order=%v %v %v", top[0].Name, top[1].Name, top[2].Name)
+	}
+	if p := top[0]; p.Count != 100 || p.Total != 5050*time.Millisecond || p.Max != 100*time.Millisecond || p.P99 != 99*time.Millisecond {
+		t.Errorf("slow profile=%+v", p)
+	}
+	if p := top[1]; p.Count != 2*ProfilerSampleCap || p.P99 != 2*time.Millisecond {
+		t.Errorf("ring profile count=%d, p99=%v", p.Count, p.P99)
+	}
+	if !strings.HasSuffix(top[0].Name, "testCbSlow") || !strings.HasSuffix(top[0].File, "asyn_profiler_test.go") {
+		t.Errorf("func info=%s %s:%d", top[0].Name, top[0].File, top[0].Line)
+	}
+	if top := profiler.Top(1); len(top) != 1 || top[0].Ptr != slow {
+		t.Errorf("top1=%+v", top)
+	}
+	if report := profiler.Report(2); !strings.Contains(report, "testCbSlow") || strings.Contains(report, "testCbFast") {
+		t.Errorf("report=%s", report)
+	}
+
+	var buf bytes.Buffer
+	if err := profiler.WritePprof(&buf); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var prof = testDecodeProfile(t, data)
+	if len(prof.samples) != 3 || prof.locations != 3 || len(prof.functions) != 3 {
+		t.Fatalf("samples=%d, locations=%d, functions=%d", len(prof.samples), prof.locations, len(prof.functions))
+	}
+	if s := prof.samples[0]; len(s) != 2 || s[0] != 100 || s[1] != uint64(5050*time.Millisecond) {
+		t.Errorf("sample=%v", s)
+	}
+	if prof.strs[0] != "" || !strings.HasSuffix(prof.strs[prof.functions[0]], "testCbSlow") {
+		t.Errorf("strings=%v, function name id=%d", prof.strs, prof.functions[0])
+	}
+}
+
+type testProfile struct {
+	samples   [][]uint64 // value
+	locations int
+	functions []uint64 // name
+	strs      []string
+}
+
+// 只解析测试用到的字段
+func testDecodeProfile(t *testing.T, data []byte) *testProfile {
+	var prof = &testProfile{}
+	testPbFields(t, data, func(tag int, v uint64, b []byte) {
+		switch tag {
+		case 2:
+			testPbFields(t, b, func(tag int, v uint64, b []byte) {
+				if tag == 2 {
+					prof.samples = append(prof.samples, testPbPacked(t, b))
+				}
+			})
+		case 4:
+			prof.locations++
+		case 5:
+			testPbFields(t, b, func(tag int, v uint64, b []byte) {
+				if tag == 2 {
+					prof.functions = append(prof.functions, v)
+				}
+			})
+		case 6:
+			prof.strs = append(prof.strs, string(b))
+		}
+	})
+	return prof
+}
+
+func testPbFields(t *testing.T, data []byte, fc func(tag int, v uint64, b []byte)) {
+	for len(data) > 0 {
+		key, n := testPbVarint(t, data)
+		data = data[n:]
+		switch key & 7 {
+		case 0:
+			v, n := testPbVarint(t, data)
+			data = data[n:]
+			fc(int(key>>3), v, nil)
+		case 2:
+			l, n := testPbVarint(t, data)
+			data = data[n:]
+			if uint64(len(data)) < l {
+				t.Fatalf("bad length %d", l)
+			}
+			fc(int(key>>3), 0, data[:l])
+			data = data[l:]
+		default:
+			t.Fatalf("unexpected wire type %d", key&7)
+		}
+	}
+}
+
+func testPbPacked(t *testing.T, data []byte) []uint64 {
+	var xs []uint64
+	for len(data) > 0 {
+		x, n := testPbVarint(t, data)
+		xs = append(xs, x)
+		data = data[n:]
+	}
+	return xs
+}
+
+func testPbVarint(t *testing.T, data []byte) (uint64, int) {
+	var x uint64
+	for i, b := range data {
+		x |= uint64(b&0x7f) << (7 * i)
+		if b < 0x80 {
+			return x, i + 1
+		}
+	}
+	t.Fatal("bad varint")
+	return 0, 0
+}
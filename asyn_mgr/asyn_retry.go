@@ -0,0 +1,191 @@
+package asyn_mgr
+
+import (
+	"errors"
+	"math/rand"
+	"px/shared/asyn_mgr/asyn_msg"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gitlab.sunborngame.com/base/log"
+)
+
+// RetryPolicy 请求失败后的重试策略，只用于幂等请求
+type RetryPolicy struct {
+	MaxAttempts int           // 最多发送次数，包括第一次
+	BaseBackoff time.Duration // 第n次重试前等待BaseBackoff*2^(n-1)
+	MaxBackoff  time.Duration // 0表示不限
+	Jitter      float64       // 0~1，等待时间在±Jitter比例内随机
+	Deadline    time.Duration // 从第一次发送起的总时限，0表示不限
+	// 判断错误是否可重试，nil时使用DefaultRetryable
+	Retryable func(err error) bool
+}
+
+// DefaultRetryable 超时、队列满、模块重启或卡住可以重试
+func DefaultRetryable(err error) bool {
+	var timeout *asyn_msg.RespTimeout
+	return errors.As(err, &timeout) ||
+		errors.Is(err, asyn_msg.ErrQueueFull) ||
+		errors.Is(err, asyn_msg.ErrQueueDropped) ||
+		errors.Is(err, asyn_msg.ErrStuck) ||
+		errors.Is(err, ErrModuleRestarted)
+}
+
+func (this *RetryPolicy) retryable(err error) bool {
+	if this.Retryable != nil {
+		return this.Retryable(err)
+	}
+	return DefaultRetryable(err)
+}
+
+// backoff 第attempt次发送失败后的等待时间
+func (this *RetryPolicy) backoff(attempt int) time.Duration {
+	var d = this.BaseBackoff
+	for i := 1; i < attempt; i++ {
+		d *= 2
+		if this.MaxBackoff > 0 && d >= this.MaxBackoff {
+			d = this.MaxBackoff
+			break
+		}
+	}
+	if this.Jitter > 0 {
+		d = time.Duration(float64(d) * (1 + this.Jitter*(rand.Float64()*2-1)))
+	}
+	return d
+}
+
+type retrier struct {
+	once     sync.Once
+	lock     sync.RWMutex
+	modules  map[asyn_msg.AsynModuleId]*RetryPolicy
+	reqTypes map[reflect.Type]*RetryPolicy
+	waiting  int32 // 等待重试的请求数
+}
+
+func newRetrier() *retrier {
+	return &retrier{
+		modules:  make(map[asyn_msg.AsynModuleId]*RetryPolicy),
+		reqTypes: make(map[reflect.Type]*RetryPolicy),
+	}
+}
+
+// 第一次设置策略时注册为全局拦截器，重试的请求经过之后注册的拦截器
+func (this *AsynMgr) installRetrier() {
+	this.retrier.once.Do(func() {
+		this.AddSendInterceptor(AllModules, this.retrier.intercept)
+	})
+}
+
+// SetRetryPolicy 启动时设置模块所有请求的重试策略，policy为nil时取消
+func (this *AsynMgr) SetRetryPolicy(moduleId asyn_msg.AsynModuleId, policy *RetryPolicy) {
+	this.installRetrier()
+	this.retrier.lock.Lock()
+	defer this.retrier.lock.Unlock()
+	if policy == nil {
+		delete(this.retrier.modules, moduleId)
+		return
+	}
+	this.retrier.modules[moduleId] = policy
+}
+
+// SetReqRetryPolicy 设置某类请求的重试策略，优先于模块的策略，req只用于获取类型
+func (this *AsynMgr) SetReqRetryPolicy(req asyn_msg.ReqInf, policy *RetryPolicy) {
+	this.installRetrier()
+	this.retrier.lock.Lock()
+	defer this.retrier.lock.Unlock()
+	if policy == nil {
+		delete(this.retrier.reqTypes, reflect.TypeOf(req))
+		return
+	}
+	this.retrier.reqTypes[reflect.TypeOf(req)] = policy
+}
+
+func (this *retrier) policy(req asyn_msg.ReqInf) *RetryPolicy {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	if policy, ok := this.reqTypes[reflect.TypeOf(req)]; ok {
+		return policy
+	}
+	return this.modules[req.GetModuleId()]
+}
+
+func (this *retrier) waitingLen() int {
+	return int(atomic.LoadInt32(&this.waiting))
+}
+
+// intercept 作为SendInterceptor，有重试策略的请求失败时重新发送，原回调只执行一次
+func (this *retrier) intercept(owner asyn_msg.RespOwner, req asyn_msg.ReqInf, cb asyn_msg.AsynCallback, next SendHandler) {
+	var policy = this.policy(req)
+	if policy == nil || policy.MaxAttempts <= 1 || cb == nil {
+		next(owner, req, cb)
+		return
+	}
+
+	var call = &retryCall{
+		retrier: this,
+		policy:  policy,
+		owner:   owner,
+		req:     req,
+		cb:      cb,
+		next:    next,
+		start:   time.Now(),
+		attempt: 1,
+	}
+	next(owner, req, call.callback)
+}
+
+type retryCall struct {
+	retrier *retrier
+	policy  *RetryPolicy
+	owner   asyn_msg.RespOwner
+	req     asyn_msg.ReqInf
+	cb      asyn_msg.AsynCallback
+	next    SendHandler
+	start   time.Time
+	attempt int
+}
+
+func (this *retryCall) callback(resp asyn_msg.RespInf) asyn_msg.AsynCBPtr {
+	var err = asyn_msg.RespErr(resp)
+	if err == nil || this.attempt >= this.policy.MaxAttempts || !this.policy.retryable(err) {
+		return this.done(resp)
+	}
+
+	var backoff = this.policy.backoff(this.attempt)
+	var timeout = this.req.GetTimeout()
+	if this.policy.Deadline > 0 {
+		// 不足1ms时超时会变成0(不超时)，直接放弃
+		var remain = this.policy.Deadline - time.Since(this.start) - backoff
+		if remain < time.Millisecond {
+			return this.done(resp)
+		}
+		if timeout <= 0 || remain.Milliseconds() < timeout {
+			timeout = remain.Milliseconds()
+		}
+	}
+
+	this.attempt++
+	// 超时的请求可能还在模块中处理，重试使用新的请求对象
+	var req = asyn_msg.CloneReq(this.req)
+	req.SetTimeout(timeout)
+	req.SetDequeueTime(0)
+	log.Warning("asyn req retry, moduleId=%d, attempt=%d, backoff=%v, req=%v, err=%v", req.GetModuleId(), this.attempt, backoff, req, err)
+
+	atomic.AddInt32(&this.retrier.waiting, 1)
+	time.AfterFunc(backoff, func() {
+		defer atomic.AddInt32(&this.retrier.waiting, -1)
+		this.next(this.owner, req, this.callback)
+	})
+	return asyn_msg.CBPtr(this.cb)
+}
+
+// 执行原回调，原回调返回0时用它自身做pprof
+func (this *retryCall) done(resp asyn_msg.RespInf) asyn_msg.AsynCBPtr {
+	x := this.cb(resp)
+	if x == 0 {
+		x = asyn_msg.CBPtr(this.cb)
+	}
+	return x
+}
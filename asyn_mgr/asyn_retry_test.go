@@ -0,0 +1,206 @@
+package asyn_mgr
+
+import (
+	"errors"
+	"fmt"
+	"px/shared/asyn_mgr/asyn_msg"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type testReq struct {
+	asyn_msg.ReqBase
+	Key    string
+	Module asyn_msg.AsynModuleId // 默认RedisModuleId
+}
+
+func (this *testReq) GetModuleId() asyn_msg.AsynModuleId {
+	if this.Module != 0 {
+		return this.Module
+	}
+	return asyn_msg.RedisModuleId
+}
+
+func TestRetryBackoff(t *testing.T) {
+	var policy = &RetryPolicy{
+		MaxAttempts: 5,
+		BaseBackoff: 10 * time.Millisecond,
+		MaxBackoff:  50 * time.Millisecond,
+	}
+	for attempt, want := range []time.Duration{10, 20, 40, 50, 50} {
+		if d := policy.backoff(attempt + 1); d != want*time.Millisecond {
+			t.Errorf("attempt=%d backoff=%v, want %v", attempt+1, d, want*time.Millisecond)
+		}
+	}
+
+	policy.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if d := policy.backoff(1); d < 5*time.Millisecond || d > 15*time.Millisecond {
+			t.Fatalf("jitter backoff=%v out of range", d)
+		}
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	var req = &testReq{Key: "k"}
+	req.SetTimeout(100)
+	if !DefaultRetryable(asyn_msg.NewRespTimeout(asyn_msg.RedisModuleId, req)) {
+		t.Errorf("timeout should be retryable")
+	}
+	if !DefaultRetryable(asyn_msg.NewRespError(asyn_msg.RedisModuleId, req, asyn_msg.ErrQueueFull)) {
+		t.Errorf("queue full should be retryable")
+	}
+	if DefaultRetryable(asyn_msg.NewRespError(asyn_msg.RedisModuleId, req, asyn_msg.ErrClosed)) {
+		t.Errorf("closed should not be retryable")
+	}
+	if DefaultRetryable(fmt.Errorf("redis: nil")) {
+		t.Errorf("module error should not be retryable")
+	}
+
+	var c = asyn_msg.CloneReq(req).(*testReq)
+	if c == req || c.Key != req.Key || c.GetTimeout() != req.GetTimeout() {
+		t.Errorf("clone=%+v, req=%+v", c, req)
+	}
+	var state = &testStateReq{testReq: testReq{Key: "k"}, state: 1}
+	if c := asyn_msg.CloneReq(state).(*testStateReq); c == state || c.Key != "k" || c.state != 0 {
+		t.Errorf("clone=%+v, want state reset", c)
+	}
+}
+
+// 有内部状态的请求，重发时重置
+type testStateReq struct {
+	testReq
+	state int
+}
+
+func (this *testStateReq) CloneReq() asyn_msg.ReqInf {
+	return &testStateReq{testReq: this.testReq}
+}
+
+// Key为fail时前failures次返回可重试的错误
+func newTestFlakyModule(failures int32) (*testModule, *int32) {
+	var attempts int32
+	return newTestModule(asyn_msg.RedisModuleId, func(req asyn_msg.ReqInf) asyn_msg.RespInf {
+		if atomic.AddInt32(&attempts, 1) <= failures {
+			return asyn_msg.NewRespError(asyn_msg.RedisModuleId, req, asyn_msg.ErrStuck)
+		}
+		return &testResp{Key: req.(*testReq).Key}
+	}), &attempts
+}
+
+func TestRetrySend(t *testing.T) {
+	var tests = []struct {
+		name     string
+		failures int32
+		policy   *RetryPolicy
+		attempts int32
+		wantErr  error
+		minCost  time.Duration
+		maxCost  time.Duration
+	}{
+		{
+			name:     "succeed after retry",
+			failures: 2,
+			policy:   &RetryPolicy{MaxAttempts: 5, BaseBackoff: 10 * time.Millisecond},
+			attempts: 3,
+			minCost:  30 * time.Millisecond,
+			maxCost:  time.Second,
+		},
+		{
+			name:     "max attempts",
+			failures: 100,
+			policy:   &RetryPolicy{MaxAttempts: 3, BaseBackoff: time.Millisecond},
+			attempts: 3,
+			wantErr:  asyn_msg.ErrStuck,
+			maxCost:  time.Second,
+		},
+		{
+			name:     "deadline",
+			failures: 1000,
+			policy:   &RetryPolicy{MaxAttempts: 1000, BaseBackoff: 10 * time.Millisecond, MaxBackoff: 20 * time.Millisecond, Deadline: 150 * time.Millisecond},
+			wantErr:  asyn_msg.ErrStuck,
+			minCost:  100 * time.Millisecond,
+			maxCost:  200 * time.Millisecond,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var module, attempts = newTestFlakyModule(tt.failures)
+			var mgr = newTestMgr(t, module)
+			mgr.SetRetryPolicy(asyn_msg.RedisModuleId, tt.policy)
+
+			var resps []asyn_msg.RespInf
+			var start = time.Now()
+			mgr.SendReq(&testReq{Key: "k"}, func(resp asyn_msg.RespInf) asyn_msg.AsynCBPtr {
+				resps = append(resps, resp)
+				return 0
+			})
+			for len(resps) == 0 {
+				if !handleOne(mgr, time.Second) {
+					t.Fatalf("wait callback timeout, attempts=%d", atomic.LoadInt32(attempts))
+				}
+			}
+			var cost = time.Since(start)
+
+			// 回调只执行一次，之后没有重试
+			for handleOne(mgr, 50*time.Millisecond) {
+			}
+			if len(resps) != 1 {
+				t.Fatalf("callbacks=%d, want 1", len(resps))
+			}
+			if err := asyn_msg.RespErr(resps[0]); !errors.Is(err, tt.wantErr) {
+				t.Errorf("err=%v, want %v", err, tt.wantErr)
+			}
+			if n := atomic.LoadInt32(attempts); tt.attempts > 0 && n != tt.attempts {
+				t.Errorf("attempts=%d, want %d", n, tt.attempts)
+			}
+			if cost < tt.minCost || cost > tt.maxCost {
+				t.Errorf("cost=%v, want [%v, %v]", cost, tt.minCost, tt.maxCost)
+			}
+			if mgr.pendingLen() != 0 {
+				t.Errorf("pending=%d", mgr.pendingLen())
+			}
+		})
+	}
+}
+
+// 最终回调返回0时记到原回调
+func TestRetryCallbackPtr(t *testing.T) {
+	var module, _ = newTestFlakyModule(1)
+	var mgr = newTestMgr(t, module)
+	mgr.SetRetryPolicy(asyn_msg.RedisModuleId, &RetryPolicy{MaxAttempts: 2})
+
+	var done bool
+	var cb = func(resp asyn_msg.RespInf) asyn_msg.AsynCBPtr {
+		done = true
+		return 0
+	}
+	mgr.SendReq(&testReq{Key: "k"}, cb)
+	for !done {
+		select {
+		case resp := <-mgr.Resp():
+			if ptr := mgr.HandleResp(resp); ptr != asyn_msg.CBPtr(cb) {
+				t.Errorf("ptr=%x, want cb", ptr)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("wait callback timeout")
+		}
+	}
+}
+
+// 运行期间第一次设置策略，和发送并发
+func TestRetryInstallConcurrent(t *testing.T) {
+	var module, _ = newTestFlakyModule(0)
+	var mgr = newTestMgr(t, module)
+
+	var done = make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			mgr.SendReq(&testReq{Key: "k"}, nil)
+		}
+	}()
+	mgr.SetRetryPolicy(asyn_msg.RedisModuleId, &RetryPolicy{MaxAttempts: 2})
+	<-done
+}
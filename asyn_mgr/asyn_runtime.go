@@ -0,0 +1,142 @@
+package asyn_mgr
+
+import (
+	"errors"
+	"fmt"
+	"px/shared/asyn_mgr/asyn_msg"
+
+	"gitlab.sunborngame.com/base/log"
+)
+
+var (
+	ErrModuleExist     = errors.New("asyn module already exist")
+	ErrModuleNotFound  = errors.New("asyn module not found")
+	ErrModuleRemoved   = errors.New("asyn module removed")
+	ErrModuleRestarted = errors.New("asyn module restarted")
+)
+
+// respDetached 模块已移除或请求被拦截，回调随响应一起投递，不再经过模块查找
+type respDetached struct {
+	*asyn_msg.RespError
+	cb asyn_msg.AsynCallback
+}
+
+func (this *respDetached) handle() asyn_msg.AsynCBPtr {
+	x := this.cb(this.RespError)
+	if x == 0 {
+		x = asyn_msg.CBPtr(this.cb)
+	}
+	return x
+}
+
+// AddModule 运行期间增加模块，Init之前等同于RegisterAsynModule
+func (this *AsynMgr) AddModule(module asyn_msg.AsynModInf) error {
+	this.lock.Lock()
+	if _, ok := this.modules[module.GetModuleId()]; ok {
+		this.lock.Unlock()
+		return fmt.Errorf("%w, moduleId=%d", ErrModuleExist, module.GetModuleId())
+	}
+	this.modules[module.GetModuleId()] = module
+	var inited = this.inited
+	this.lock.Unlock()
+
+	log.Info("add module, moduleId=%d, inited=%v", module.GetModuleId(), inited)
+	if inited {
+		module.Init()
+		go this.loop(module)
+	}
+	return nil
+}
+
+// RemoveModule 移除模块，队列中和执行中的请求回调都以RespError(ErrModuleRemoved)失败
+func (this *AsynMgr) RemoveModule(moduleId asyn_msg.AsynModuleId) error {
+	this.lock.Lock()
+	module, ok := this.modules[moduleId]
+	if !ok {
+		this.lock.Unlock()
+		return fmt.Errorf("%w, moduleId=%d", ErrModuleNotFound, moduleId)
+	}
+	delete(this.modules, moduleId)
+	var pending = this.retire(module)
+	this.lock.Unlock()
+
+	log.Warning("remove module, moduleId=%d, failed callbacks=%d", moduleId, len(pending))
+	this.failDetached(moduleId, pending, ErrModuleRemoved)
+	go module.Close()
+	return nil
+}
+
+// RestartModule 用同一AsynModuleId的新实例替换旧实例
+// 旧实例队列中还未处理的请求直接转入新实例队列，fcId和回调不变；正在执行的请求回调以RespError(ErrModuleRestarted)失败
+func (this *AsynMgr) RestartModule(module asyn_msg.AsynModInf) error {
+	var moduleId = module.GetModuleId()
+	if _, ok := this.getModule(moduleId); !ok {
+		return fmt.Errorf("%w, moduleId=%d", ErrModuleNotFound, moduleId)
+	}
+
+	// 新实例先初始化，避免持锁时间过长
+	module.Init()
+
+	this.lock.Lock()
+	old, ok := this.modules[moduleId]
+	if !ok {
+		this.lock.Unlock()
+		module.Close()
+		return fmt.Errorf("%w, moduleId=%d", ErrModuleNotFound, moduleId)
+	}
+	this.modules[moduleId] = module
+	var pending = this.retire(old)
+	// 旧实例队列已关闭，包括已出队还未被模块读走的请求
+	var queued = old.GetAsynBase().ReqChan.Drain()
+
+	// 转移队列中的请求，回调随请求一起转移
+	var callbacks = make(map[uint64]*asyn_msg.AsynFc, len(pending))
+	for _, fc := range pending {
+		callbacks[fc.Req.GetFcId()] = fc
+	}
+	var base = module.GetAsynBase()
+	base.ContinueFcId(old.GetAsynBase())
+	for _, req := range queued {
+		base.Requeue(req, callbacks[req.GetFcId()])
+		delete(callbacks, req.GetFcId())
+	}
+	go this.loop(module)
+	this.lock.Unlock()
+
+	var inflight = make([]*asyn_msg.AsynFc, 0, len(callbacks))
+	for _, fc := range pending {
+		if _, ok := callbacks[fc.Req.GetFcId()]; ok {
+			inflight = append(inflight, fc)
+		}
+	}
+	log.Warning("restart module, moduleId=%d, requeued=%d, failed callbacks=%d", moduleId, len(queued), len(inflight))
+	this.failDetached(moduleId, inflight, ErrModuleRestarted)
+	go old.Close()
+	return nil
+}
+
+// retire 停止旧实例并取出所有回调，需持有lock
+func (this *AsynMgr) retire(module asyn_msg.AsynModInf) []*asyn_msg.AsynFc {
+	module.StopAccept()
+	var base = module.GetAsynBase()
+	base.Stop()
+	return base.TakePending()
+}
+
+func (this *AsynMgr) failDetached(moduleId asyn_msg.AsynModuleId, fcs []*asyn_msg.AsynFc, err error) {
+	for _, fc := range fcs {
+		this.putDetached(fc.Owner, &respDetached{
+			RespError: asyn_msg.NewRespError(moduleId, fc.Req, err),
+			cb:        fc.Cb,
+		})
+	}
+}
+
+// putDetached 投递自带回调的响应
+func (this *AsynMgr) putDetached(owner asyn_msg.RespOwner, resp asyn_msg.RespInf) {
+	if owner != nil {
+		owner.PutResp(resp)
+	} else {
+		this.respChan.Put(resp)
+	}
+}
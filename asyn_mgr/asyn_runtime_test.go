@@ -0,0 +1,110 @@
+package asyn_mgr
+
+import (
+	"errors"
+	"px/shared/asyn_mgr/asyn_msg"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testSendHook struct {
+	lock sync.Mutex
+	sent map[string]int
+}
+
+func (this *testSendHook) OnSend(req asyn_msg.ReqInf) {
+	if r, ok := req.(*testReq); ok && strings.HasPrefix(r.Key, "restart") {
+		this.lock.Lock()
+		this.sent[r.Key]++
+		this.lock.Unlock()
+	}
+}
+
+func (this *testSendHook) OnDequeue(req asyn_msg.ReqInf, wait time.Duration) {}
+
+func (this *testSendHook) OnDone(req asyn_msg.ReqInf, resp asyn_msg.RespInf, stat *asyn_msg.AsynStat) {
+}
+
+// 旧实例卡住且队列满，发送方阻塞时重启不能死锁
+func TestRestartModuleBlockedSender(t *testing.T) {
+	var hook = &testSendHook{sent: make(map[string]int)}
+	asyn_msg.AddHook(hook)
+
+	var hang = make(chan struct{})
+	defer close(hang)
+	var old = newTestModule(asyn_msg.RedisModuleId, func(req asyn_msg.ReqInf) asyn_msg.RespInf {
+		<-hang
+		return nil
+	})
+	old.SetReqQueueOpt(1, asyn_msg.QueuePolicyBlock)
+	var mgr = newTestMgr(t, old)
+
+	var results = make(map[string]asyn_msg.RespInf)
+	var send = func(key string) *testReq {
+		var req = &testReq{Key: key}
+		mgr.SendReq(req, func(resp asyn_msg.RespInf) asyn_msg.AsynCBPtr {
+			if _, ok := results[key]; ok {
+				t.Errorf("callback of %s called twice", key)
+			}
+			results[key] = resp
+			return 0
+		})
+		return req
+	}
+
+	// restart1处理中，restart2已出队等待模块读取，restart3在队列中
+	send("restart1")
+	for old.ReqLen() != 0 {
+		time.Sleep(time.Millisecond)
+	}
+	var req2 = send("restart2")
+	var req3 = send("restart3")
+	var blocked = make(chan struct{})
+	go func() {
+		defer close(blocked)
+		send("restart4")
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	var module = newTestModule(asyn_msg.RedisModuleId, func(req asyn_msg.ReqInf) asyn_msg.RespInf {
+		return &testResp{Key: req.(*testReq).Key}
+	})
+	var restarted = make(chan error, 1)
+	go func() { restarted <- mgr.RestartModule(module) }()
+	select {
+	case err := <-restarted:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("restart module blocked")
+	}
+	<-blocked
+
+	for len(results) < 4 {
+		if !handleOne(mgr, time.Second) {
+			t.Fatalf("results=%v, want 4", results)
+		}
+	}
+	for _, key := range []string{"restart1", "restart4"} {
+		if err := asyn_msg.RespErr(results[key]); !errors.Is(err, ErrModuleRestarted) {
+			t.Errorf("%s err=%v, want ErrModuleRestarted", key, err)
+		}
+	}
+	for key, req := range map[string]*testReq{"restart2": req2, "restart3": req3} {
+		resp, ok := results[key].(*testResp)
+		if !ok || resp.Key != key || resp.GetFcId() != req.GetFcId() {
+			t.Errorf("%s resp=%+v, want handled by new module with fcId %d", key, results[key], req.GetFcId())
+		}
+	}
+	for key, n := range hook.sent {
+		if n != 1 {
+			t.Errorf("%s sent %d times, want 1", key, n)
+		}
+	}
+	if mgr.pendingLen() != 0 {
+		t.Errorf("pending=%d", mgr.pendingLen())
+	}
+}
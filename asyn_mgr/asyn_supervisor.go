@@ -0,0 +1,183 @@
+package asyn_mgr
+
+import (
+	"px/framebase"
+	"px/shared/asyn_mgr/asyn_msg"
+	"px/utils"
+	"sync"
+	"time"
+
+	"gitlab.sunborngame.com/base/log"
+)
+
+type ModuleHealth int32
+
+const (
+	HealthHealthy  ModuleHealth = iota
+	HealthDegraded              // loop最近重启过或有请求处理超时
+	HealthDown                  // 模块不存在、已关闭或loop等待重启
+)
+
+func (this ModuleHealth) String() string {
+	switch this {
+	case HealthHealthy:
+		return "healthy"
+	case HealthDegraded:
+		return "degraded"
+	case HealthDown:
+		return "down"
+	}
+	return "unknown"
+}
+
+// ModuleFactory 创建新的模块实例，模块卡住时用于RestartModule
+type ModuleFactory func() asyn_msg.AsynModInf
+
+type superviseOpt struct {
+	stuckTimeout time.Duration
+	factory      ModuleFactory
+
+	backoff     time.Duration
+	nextRestart time.Time
+	health      ModuleHealth
+}
+
+type supervisor struct {
+	lock sync.Mutex
+	opts map[asyn_msg.AsynModuleId]*superviseOpt
+}
+
+func newSupervisor() *supervisor {
+	return &supervisor{
+		opts: make(map[asyn_msg.AsynModuleId]*superviseOpt),
+	}
+}
+
+// 需持有lock
+func (this *supervisor) getOpt(moduleId asyn_msg.AsynModuleId) *superviseOpt {
+	opt, ok := this.opts[moduleId]
+	if !ok {
+		opt = &superviseOpt{
+			stuckTimeout: DefaultStuckTimeout,
+			backoff:      asyn_msg.LoopMinBackoff,
+		}
+		this.opts[moduleId] = opt
+	}
+	return opt
+}
+
+// Supervise 设置模块的卡住判定时间，factory非空时模块卡住后以新实例重启
+func (this *AsynMgr) Supervise(moduleId asyn_msg.AsynModuleId, stuckTimeout time.Duration, factory ModuleFactory) {
+	this.supervisor.lock.Lock()
+	defer this.supervisor.lock.Unlock()
+	var opt = this.supervisor.getOpt(moduleId)
+	if stuckTimeout > 0 {
+		opt.stuckTimeout = stuckTimeout
+	}
+	opt.factory = factory
+}
+
+func (this *AsynMgr) stuckTimeout(moduleId asyn_msg.AsynModuleId) time.Duration {
+	this.supervisor.lock.Lock()
+	defer this.supervisor.lock.Unlock()
+	return this.supervisor.getOpt(moduleId).stuckTimeout
+}
+
+// Health 模块当前的健康状态
+func (this *AsynMgr) Health(moduleId asyn_msg.AsynModuleId) ModuleHealth {
+	module, ok := this.getModule(moduleId)
+	if !ok {
+		return HealthDown
+	}
+	return this.moduleHealth(module, time.Now())
+}
+
+func (this *AsynMgr) AllHealth() map[asyn_msg.AsynModuleId]ModuleHealth {
+	var now = time.Now()
+	var modules = this.allModules()
+	var health = make(map[asyn_msg.AsynModuleId]ModuleHealth, len(modules))
+	for moduleId, module := range modules {
+		health[moduleId] = this.moduleHealth(module, now)
+	}
+	return health
+}
+
+func (this *AsynMgr) moduleHealth(module asyn_msg.AsynModInf, now time.Time) ModuleHealth {
+	var base = module.GetAsynBase()
+	var stat = base.GetLoopStat()
+	if base.IsClosing() || stat.Down > 0 {
+		return HealthDown
+	}
+	if !stat.LastCrash.IsZero() && now.Sub(stat.LastCrash) < DegradedWindow {
+		return HealthDegraded
+	}
+	var working = base.Working()
+	if len(working) > 0 && now.Sub(working[0].Start) >= this.stuckTimeout(module.GetModuleId()) {
+		return HealthDegraded
+	}
+	return HealthHealthy
+}
+
+// 检查各模块正在处理的请求，超时的回调以ErrStuck失败，设置了factory的模块以新实例重启
+func (this *AsynMgr) watchdogLoop() {
+	defer utils.Recover(framebase.SendWeChatMsg(), framebase.IsReleaseEnv())
+
+	var ticker = time.NewTicker(WatchdogTick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			var now = time.Now()
+			for _, module := range this.allModules() {
+				this.watch(module, now)
+			}
+		case <-this.stopChan:
+			return
+		}
+	}
+}
+
+func (this *AsynMgr) watch(module asyn_msg.AsynModInf, now time.Time) {
+	var moduleId = module.GetModuleId()
+	var base = module.GetAsynBase()
+	var timeout = this.stuckTimeout(moduleId)
+
+	var stuck = false
+	for _, working := range base.Working() {
+		if now.Sub(working.Start) < timeout {
+			break
+		}
+		stuck = true
+		if base.FailStuck(working.Req.GetFcId()) {
+			log.Error("asyn req stuck, moduleId=%d, fcId=%d, cost=%v, req=%v", moduleId, working.Req.GetFcId(), now.Sub(working.Start), working.Req)
+		}
+	}
+
+	var health = this.moduleHealth(module, now)
+	this.supervisor.lock.Lock()
+	var opt = this.supervisor.getOpt(moduleId)
+	if opt.health != health {
+		log.Warning("asyn module health changed, moduleId=%d, %v -> %v", moduleId, opt.health, health)
+		opt.health = health
+	}
+	var factory ModuleFactory
+	if stuck && opt.factory != nil && !now.Before(opt.nextRestart) {
+		factory = opt.factory
+		opt.nextRestart = now.Add(opt.backoff)
+		opt.backoff *= 2
+		if opt.backoff > asyn_msg.LoopMaxBackoff {
+			opt.backoff = asyn_msg.LoopMaxBackoff
+		}
+	} else if !stuck && now.Sub(opt.nextRestart) >= asyn_msg.LoopStableAfter {
+		opt.backoff = asyn_msg.LoopMinBackoff
+	}
+	this.supervisor.lock.Unlock()
+
+	if factory == nil {
+		return
+	}
+	log.Error("asyn module stuck, restart, moduleId=%d", moduleId)
+	if err := this.RestartModule(factory()); err != nil {
+		log.Error("restart stuck module failed, moduleId=%d, err=%v", moduleId, err)
+	}
+}
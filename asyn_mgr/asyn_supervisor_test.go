@@ -0,0 +1,179 @@
+package asyn_mgr
+
+import (
+	"errors"
+	"px/shared/asyn_mgr/asyn_msg"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Key为panic时panic，为stuck时阻塞到hang关闭，其余原样回包
+func newTestSuperviseModule(hang chan struct{}) *testModule {
+	return newTestModule(asyn_msg.RedisModuleId, func(req asyn_msg.ReqInf) asyn_msg.RespInf {
+		switch key := req.(*testReq).Key; key {
+		case "panic":
+			panic("test panic")
+		case "stuck":
+			<-hang
+			return &testResp{Key: key}
+		default:
+			return &testResp{Key: key}
+		}
+	})
+}
+
+// 发送请求并等待回调
+func testCall(t *testing.T, mgr *AsynMgr, key string) asyn_msg.RespInf {
+	t.Helper()
+	var result asyn_msg.RespInf
+	mgr.SendReq(&testReq{Key: key}, func(resp asyn_msg.RespInf) asyn_msg.AsynCBPtr {
+		result = resp
+		return 0
+	})
+	for result == nil {
+		if !handleOne(mgr, 2*time.Second) {
+			t.Fatalf("wait resp of %s timeout", key)
+		}
+	}
+	return result
+}
+
+// 等待健康状态变为want，返回等待时间
+func waitHealth(t *testing.T, mgr *AsynMgr, want ModuleHealth) time.Duration {
+	t.Helper()
+	var start = time.Now()
+	for h := mgr.Health(asyn_msg.RedisModuleId); h != want; h = mgr.Health(asyn_msg.RedisModuleId) {
+		if time.Since(start) > 2*time.Second {
+			t.Fatalf("health=%v, want %v", h, want)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return time.Since(start)
+}
+
+func TestSupervisePanic(t *testing.T) {
+	var module = newTestSuperviseModule(nil)
+	var mgr = newTestMgr(t, module)
+	if h := mgr.Health(asyn_msg.RedisModuleId); h != HealthHealthy {
+		t.Fatalf("health=%v, want healthy", h)
+	}
+
+	// 第一次panic后等待LoopMinBackoff重启，之后退避翻倍
+	for i, backoff := range []time.Duration{asyn_msg.LoopMinBackoff, 2 * asyn_msg.LoopMinBackoff} {
+		if err := asyn_msg.RespErr(testCall(t, mgr, "panic")); !errors.Is(err, asyn_msg.ErrPanic) {
+			t.Fatalf("panic resp err=%v, want ErrPanic", err)
+		}
+		if h := mgr.Health(asyn_msg.RedisModuleId); h != HealthDown {
+			t.Errorf("health=%v after panic, want down", h)
+		}
+		if wait := waitHealth(t, mgr, HealthDegraded); wait < backoff*3/4 {
+			t.Errorf("restart %d after %v, want backoff %v", i+1, wait, backoff)
+		}
+	}
+
+	if stat := module.GetLoopStat(); stat.Restarts != 2 || stat.LastCrash.IsZero() {
+		t.Errorf("loop stat=%+v", stat)
+	}
+	if resp, ok := testCall(t, mgr, "ok").(*testResp); !ok || resp.Key != "ok" {
+		t.Errorf("resp after restart=%+v", resp)
+	}
+}
+
+func TestWatchdogStuck(t *testing.T) {
+	var hang = make(chan struct{})
+	var module = newTestSuperviseModule(hang)
+	var mgr = newTestMgr(t, module)
+	mgr.Supervise(asyn_msg.RedisModuleId, 50*time.Millisecond, nil)
+
+	var resps []asyn_msg.RespInf
+	mgr.SendReq(&testReq{Key: "stuck"}, func(resp asyn_msg.RespInf) asyn_msg.AsynCBPtr {
+		resps = append(resps, resp)
+		return 0
+	})
+	for len(module.Working()) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	var later = time.Now().Add(100 * time.Millisecond)
+	if h := mgr.moduleHealth(module, later); h != HealthDegraded {
+		t.Errorf("health=%v when stuck, want degraded", h)
+	}
+
+	mgr.watch(module, later)
+	mgr.watch(module, later)
+	for handleOne(mgr, 100*time.Millisecond) {
+	}
+	if len(resps) != 1 || !errors.Is(asyn_msg.RespErr(resps[0]), asyn_msg.ErrStuck) {
+		t.Fatalf("resps=%v, want one ErrStuck", resps)
+	}
+
+	// 模块恢复后迟到的响应丢弃
+	close(hang)
+	for len(module.Working()) > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	for handleOne(mgr, 100*time.Millisecond) {
+	}
+	if len(resps) != 1 {
+		t.Errorf("callbacks=%d, want 1", len(resps))
+	}
+	if h := mgr.Health(asyn_msg.RedisModuleId); h != HealthHealthy {
+		t.Errorf("health=%v after recover, want healthy", h)
+	}
+}
+
+func TestWatchdogRestart(t *testing.T) {
+	var hang = make(chan struct{})
+	defer close(hang)
+	var module = newTestSuperviseModule(hang)
+	var mgr = newTestMgr(t, module)
+
+	// watchdogLoop也可能调用factory
+	var lock sync.Mutex
+	var created []*testModule
+	mgr.Supervise(asyn_msg.RedisModuleId, 50*time.Millisecond, func() asyn_msg.AsynModInf {
+		var m = newTestSuperviseModule(hang)
+		lock.Lock()
+		created = append(created, m)
+		lock.Unlock()
+		return m
+	})
+
+	var resps []asyn_msg.RespInf
+	mgr.SendReq(&testReq{Key: "stuck"}, func(resp asyn_msg.RespInf) asyn_msg.AsynCBPtr {
+		resps = append(resps, resp)
+		return 0
+	})
+	for len(module.Working()) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// 退避时间内不重复重启
+	var later = time.Now().Add(100 * time.Millisecond)
+	mgr.watch(module, later)
+	mgr.watch(module, later)
+	lock.Lock()
+	var restarted = append([]*testModule(nil), created...)
+	lock.Unlock()
+	if len(restarted) != 1 {
+		t.Fatalf("restarts=%d, want 1", len(restarted))
+	}
+	if current, _ := mgr.getModule(asyn_msg.RedisModuleId); current != restarted[0] {
+		t.Fatalf("module not replaced")
+	}
+
+	for handleOne(mgr, 100*time.Millisecond) {
+	}
+	if len(resps) != 1 {
+		t.Fatalf("callbacks=%d, want 1", len(resps))
+	}
+	if err := asyn_msg.RespErr(resps[0]); !errors.Is(err, asyn_msg.ErrStuck) && !errors.Is(err, ErrModuleRestarted) {
+		t.Errorf("stuck resp err=%v", err)
+	}
+	if resp, ok := testCall(t, mgr, "ok").(*testResp); !ok || resp.Key != "ok" {
+		t.Errorf("resp after restart=%+v", resp)
+	}
+	if h := mgr.Health(asyn_msg.RedisModuleId); h != HealthHealthy {
+		t.Errorf("health=%v after restart, want healthy", h)
+	}
+}
@@ -0,0 +1,196 @@
+package asyn_trace
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	OTLPTimeout = 5 * time.Second
+	OTLPScope   = "asyn_mgr"
+)
+
+type spanLine struct {
+	TraceId  string `json:"trace_id"`
+	SpanId   string `json:"span_id"`
+	ParentId string `json:"parent_id,omitempty"`
+	Name     string `json:"name"`
+	Kind     string `json:"kind"`
+	ModuleId int32  `json:"module_id,omitempty"`
+	FcId     uint64 `json:"fc_id,omitempty"`
+	Start    int64  `json:"start_ns"`
+	End      int64  `json:"end_ns"`
+	Err      string `json:"err,omitempty"`
+}
+
+// FileExporter 每个span一行json追加写入本地文件
+type FileExporter struct {
+	file *os.File
+	w    *bufio.Writer
+}
+
+func NewFileExporter(path string) (*FileExporter, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &FileExporter{
+		file: file,
+		w:    bufio.NewWriter(file),
+	}, nil
+}
+
+func (this *FileExporter) Export(spans []*Span) error {
+	var enc = json.NewEncoder(this.w)
+	for _, span := range spans {
+		if err := enc.Encode(&spanLine{
+			TraceId:  span.TraceId.String(),
+			SpanId:   span.SpanId.String(),
+			ParentId: span.ParentId.String(),
+			Name:     span.Name,
+			Kind:     span.Kind,
+			ModuleId: int32(span.ModuleId),
+			FcId:     span.FcId,
+			Start:    span.Start.UnixNano(),
+			End:      span.End.UnixNano(),
+			Err:      span.Err,
+		}); err != nil {
+			return err
+		}
+	}
+	return this.w.Flush()
+}
+
+func (this *FileExporter) Close() error {
+	if err := this.w.Flush(); err != nil {
+		this.file.Close()
+		return err
+	}
+	return this.file.Close()
+}
+
+// OTLPExporter 以OTLP/HTTP JSON格式发送到collector，如http://127.0.0.1:4318/v1/traces
+type OTLPExporter struct {
+	url     string
+	service string
+	client  *http.Client
+}
+
+func NewOTLPExporter(url string, service string) *OTLPExporter {
+	return &OTLPExporter{
+		url:     url,
+		service: service,
+		client:  &http.Client{Timeout: OTLPTimeout},
+	}
+}
+
+type (
+	otlpValue struct {
+		StringValue string `json:"stringValue,omitempty"`
+		IntValue    string `json:"intValue,omitempty"`
+	}
+	otlpAttr struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+	otlpStatus struct {
+		Code    int    `json:"code"`
+		Message string `json:"message,omitempty"`
+	}
+	otlpSpan struct {
+		TraceId      string     `json:"traceId"`
+		SpanId       string     `json:"spanId"`
+		ParentSpanId string     `json:"parentSpanId,omitempty"`
+		Name         string     `json:"name"`
+		Kind         int        `json:"kind"`
+		Start        string     `json:"startTimeUnixNano"`
+		End          string     `json:"endTimeUnixNano"`
+		Attributes   []otlpAttr `json:"attributes,omitempty"`
+		Status       otlpStatus `json:"status"`
+	}
+	otlpScopeSpans struct {
+		Scope struct {
+			Name string `json:"name"`
+		} `json:"scope"`
+		Spans []*otlpSpan `json:"spans"`
+	}
+	otlpResourceSpans struct {
+		Resource struct {
+			Attributes []otlpAttr `json:"attributes"`
+		} `json:"resource"`
+		ScopeSpans []*otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpRequest struct {
+		ResourceSpans []*otlpResourceSpans `json:"resourceSpans"`
+	}
+)
+
+const (
+	otlpKindInternal = 1
+	otlpKindClient   = 3
+	otlpStatusOk     = 1
+	otlpStatusError  = 2
+)
+
+func (this *OTLPExporter) Export(spans []*Span) error {
+	var scope = &otlpScopeSpans{Spans: make([]*otlpSpan, 0, len(spans))}
+	scope.Scope.Name = OTLPScope
+	for _, span := range spans {
+		scope.Spans = append(scope.Spans, toOTLP(span))
+	}
+	var rs = &otlpResourceSpans{ScopeSpans: []*otlpScopeSpans{scope}}
+	rs.Resource.Attributes = []otlpAttr{{Key: "service.name", Value: otlpValue{StringValue: this.service}}}
+
+	data, err := json.Marshal(&otlpRequest{ResourceSpans: []*otlpResourceSpans{rs}})
+	if err != nil {
+		return err
+	}
+	resp, err := this.client.Post(this.url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("otlp export status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (this *OTLPExporter) Close() error {
+	this.client.CloseIdleConnections()
+	return nil
+}
+
+func toOTLP(span *Span) *otlpSpan {
+	var s = &otlpSpan{
+		TraceId:      span.TraceId.String(),
+		SpanId:       span.SpanId.String(),
+		ParentSpanId: span.ParentId.String(),
+		Name:         span.Name,
+		Kind:         otlpKindInternal,
+		Start:        strconv.FormatInt(span.Start.UnixNano(), 10),
+		End:          strconv.FormatInt(span.End.UnixNano(), 10),
+		Attributes:   []otlpAttr{{Key: "asyn.kind", Value: otlpValue{StringValue: span.Kind}}},
+		Status:       otlpStatus{Code: otlpStatusOk},
+	}
+	if span.Kind == SpanKindSend {
+		s.Kind = otlpKindClient
+	}
+	if span.Kind != SpanKindRoot {
+		s.Name = span.Name + " " + span.Kind
+		s.Attributes = append(s.Attributes,
+			otlpAttr{Key: "asyn.module_id", Value: otlpValue{IntValue: strconv.Itoa(int(span.ModuleId))}},
+			otlpAttr{Key: "asyn.fc_id", Value: otlpValue{IntValue: strconv.FormatUint(span.FcId, 10)}},
+		)
+	}
+	if span.Err != "" {
+		s.Status = otlpStatus{Code: otlpStatusError, Message: span.Err}
+	}
+	return s
+}
@@ -0,0 +1,212 @@
+package asyn_trace
+
+import (
+	"px/shared/asyn_mgr/asyn_msg"
+	"px/utils/chanx"
+	"time"
+
+	"gitlab.sunborngame.com/base/log"
+)
+
+const (
+	LogTag = "[asyn_trace]"
+
+	SpanChanCap   = 10240
+	ExportBatch   = 512         // 单次导出的最大span数
+	ExportTick    = time.Second // 定时导出间隔
+	SpanKindRoot  = "root"
+	SpanKindSend  = "send"  // SendReq到回调结束，queue/exec/callback的父span
+	SpanKindQueue = "queue" // 请求队列中等待
+	SpanKindExec  = "exec"  // 出队到模块产出响应
+	SpanKindCb    = "callback"
+)
+
+// Span 一段已结束的耗时
+type Span struct {
+	TraceId  asyn_msg.TraceId
+	SpanId   asyn_msg.SpanId
+	ParentId asyn_msg.SpanId
+	Name     string
+	Kind     string
+	ModuleId asyn_msg.AsynModuleId
+	FcId     uint64
+	Start    time.Time
+	End      time.Time
+	Err      string
+}
+
+// Exporter span的导出目标，只在Tracer的导出goroutine中调用，Export返回后不能再持有spans
+type Exporter interface {
+	Export(spans []*Span) error
+	Close() error
+}
+
+// Tracer 实现asyn_msg.AsynHook，请求出队时生成queue span，回调完成时生成send/exec/callback span
+// 没有回调的请求在出队时结束，只有send和queue span
+type Tracer struct {
+	exporter Exporter
+	spanChan *chanx.UnboundedChan[*Span]
+	stopChan chan struct{}
+	doneChan chan struct{}
+}
+
+func NewTracer(exporter Exporter) *Tracer {
+	var tracer = &Tracer{
+		exporter: exporter,
+		spanChan: chanx.NewUnboundedChan[*Span](SpanChanCap),
+		stopChan: make(chan struct{}),
+		doneChan: make(chan struct{}),
+	}
+	go tracer.loop()
+	return tracer
+}
+
+func (this *Tracer) OnSend(req asyn_msg.ReqInf) {
+}
+
+func (this *Tracer) OnDequeue(req asyn_msg.ReqInf, wait time.Duration) {
+	var trace = req.GetTrace()
+	if trace.IsZero() {
+		return
+	}
+	var send = time.Unix(0, req.GetSendTime())
+	var dequeue = send.Add(wait)
+	this.spanChan.Put(newSpan(req, SpanKindQueue, asyn_msg.NewSpanId(), trace.SpanId, send, dequeue))
+	if trace.Oneway {
+		this.spanChan.Put(newSpan(req, SpanKindSend, trace.SpanId, trace.ParentId, send, dequeue))
+	}
+}
+
+func (this *Tracer) OnDone(req asyn_msg.ReqInf, resp asyn_msg.RespInf, stat *asyn_msg.AsynStat) {
+	var trace = req.GetTrace()
+	if trace.IsZero() {
+		return
+	}
+
+	var end = time.Now()
+	var send = time.Unix(0, req.GetSendTime())
+	var errMsg string
+	if err := asyn_msg.RespErr(resp); err != nil {
+		errMsg = err.Error()
+	}
+
+	var sendSpan = newSpan(req, SpanKindSend, trace.SpanId, trace.ParentId, send, end)
+	sendSpan.Err = errMsg
+	this.spanChan.Put(sendSpan)
+	// queue span已在出队时生成
+	if dequeueTs := req.GetDequeueTime(); dequeueTs > 0 {
+		if respTs := resp.GetRespTime(); respTs > 0 {
+			var execSpan = newSpan(req, SpanKindExec, asyn_msg.NewSpanId(), trace.SpanId, time.Unix(0, dequeueTs), time.Unix(0, respTs))
+			execSpan.Err = errMsg
+			this.spanChan.Put(execSpan)
+		}
+	}
+	var cbSpanId = stat.CallbackSpan
+	if cbSpanId.IsZero() {
+		cbSpanId = asyn_msg.NewSpanId()
+	}
+	this.spanChan.Put(newSpan(req, SpanKindCb, cbSpanId, trace.SpanId, end.Add(-stat.Callback), end))
+}
+
+func newSpan(req asyn_msg.ReqInf, kind string, spanId asyn_msg.SpanId, parentId asyn_msg.SpanId, start, end time.Time) *Span {
+	return &Span{
+		TraceId:  req.GetTrace().TraceId,
+		SpanId:   spanId,
+		ParentId: parentId,
+		Name:     asyn_msg.TypeName(req),
+		Kind:     kind,
+		ModuleId: req.GetModuleId(),
+		FcId:     req.GetFcId(),
+		Start:    start,
+		End:      end,
+	}
+}
+
+// ActiveSpan Start返回的未结束的span
+type ActiveSpan struct {
+	tracer *Tracer
+	owner  asyn_msg.RespOwner
+	ctx    asyn_msg.TraceCtx
+	prev   asyn_msg.TraceCtx
+	name   string
+	start  time.Time
+}
+
+// Start 开始一个span，如处理玩家的一次操作，结束前owner发出的请求都是它的子span
+// 在回调中调用时成为回调span的子span，owner为nil表示主逻辑goroutine
+func (this *Tracer) Start(owner asyn_msg.RespOwner, name string) *ActiveSpan {
+	var ctx = asyn_msg.ChildOf(asyn_msg.CurrentTrace(owner))
+	return &ActiveSpan{
+		tracer: this,
+		owner:  owner,
+		ctx:    ctx,
+		prev:   asyn_msg.SetCurrentTrace(owner, ctx),
+		name:   name,
+		start:  time.Now(),
+	}
+}
+
+func (this *ActiveSpan) Context() asyn_msg.TraceCtx {
+	return this.ctx
+}
+
+// End 与Start在同一goroutine中调用
+func (this *ActiveSpan) End() {
+	asyn_msg.SetCurrentTrace(this.owner, this.prev)
+	this.tracer.spanChan.Put(&Span{
+		TraceId:  this.ctx.TraceId,
+		SpanId:   this.ctx.SpanId,
+		ParentId: this.ctx.ParentId,
+		Name:     this.name,
+		Kind:     SpanKindRoot,
+		Start:    this.start,
+		End:      time.Now(),
+	})
+}
+
+// Close 导出剩余的span后关闭exporter
+func (this *Tracer) Close() {
+	close(this.stopChan)
+	<-this.doneChan
+}
+
+func (this *Tracer) loop() {
+	defer close(this.doneChan)
+
+	var ticker = time.NewTicker(ExportTick)
+	defer ticker.Stop()
+	var batch = make([]*Span, 0, ExportBatch)
+	for {
+		select {
+		case span := <-this.spanChan.C():
+			batch = append(batch, span)
+			if len(batch) >= ExportBatch {
+				batch = this.export(batch)
+			}
+		case <-ticker.C:
+			batch = this.export(batch)
+		case <-this.stopChan:
+			for this.spanChan.Len() > 0 {
+				batch = append(batch, <-this.spanChan.C())
+				if len(batch) >= ExportBatch {
+					batch = this.export(batch)
+				}
+			}
+			this.export(batch)
+			if err := this.exporter.Close(); err != nil {
+				log.Error("%s close exporter err:%v", LogTag, err)
+			}
+			return
+		}
+	}
+}
+
+func (this *Tracer) export(batch []*Span) []*Span {
+	if len(batch) == 0 {
+		return batch
+	}
+	if err := this.exporter.Export(batch); err != nil {
+		log.Error("%s export %d spans err:%v", LogTag, len(batch), err)
+	}
+	return batch[:0]
+}
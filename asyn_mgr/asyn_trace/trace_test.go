@@ -0,0 +1,128 @@
+package asyn_trace
+
+import (
+	"px/shared/asyn_mgr/asyn_msg"
+	"testing"
+	"time"
+)
+
+type testReq struct {
+	asyn_msg.ReqBase
+}
+
+type testResp struct {
+	asyn_msg.RespBase
+	Err string
+}
+
+func (this *testReq) GetModuleId() asyn_msg.AsynModuleId {
+	return asyn_msg.RedisModuleId
+}
+
+func (this *testResp) GetModuleId() asyn_msg.AsynModuleId {
+	return asyn_msg.RedisModuleId
+}
+
+type memExporter struct {
+	spans  []*Span
+	closed bool
+}
+
+func (this *memExporter) Export(spans []*Span) error {
+	for _, span := range spans {
+		var s = *span
+		this.spans = append(this.spans, &s)
+	}
+	return nil
+}
+
+func (this *memExporter) Close() error {
+	this.closed = true
+	return nil
+}
+
+func TestTracer(t *testing.T) {
+	var exporter = &memExporter{}
+	var tracer = NewTracer(exporter)
+
+	var root = tracer.Start(nil, "player_login")
+	var req = &testReq{}
+	req.SetTrace(asyn_msg.ChildOf(asyn_msg.CurrentTrace(nil)))
+	root.End()
+
+	var now = time.Now()
+	req.SetSendTime(now.Add(-3 * time.Millisecond).UnixNano())
+	req.SetDequeueTime(now.Add(-2 * time.Millisecond).UnixNano())
+	tracer.OnDequeue(req, time.Millisecond)
+	var resp = &testResp{Err: "redis: nil"}
+	resp.SetRespTime(now.Add(-time.Millisecond).UnixNano())
+	var cbSpan = asyn_msg.NewSpanId()
+	tracer.OnDone(req, resp, &asyn_msg.AsynStat{Callback: time.Millisecond, CallbackSpan: cbSpan})
+	tracer.Close()
+
+	if !exporter.closed {
+		t.Fatalf("exporter not closed")
+	}
+	if len(exporter.spans) != 5 {
+		t.Fatalf("spans=%d, want 5", len(exporter.spans))
+	}
+	if !asyn_msg.CurrentTrace(nil).IsZero() {
+		t.Errorf("current trace not restored")
+	}
+
+	var kinds = make(map[string]*Span)
+	for _, span := range exporter.spans {
+		kinds[span.Kind] = span
+		if span.TraceId != root.Context().TraceId {
+			t.Errorf("span %s traceId=%v, want %v", span.Kind, span.TraceId, root.Context().TraceId)
+		}
+	}
+	if kinds[SpanKindSend].ParentId != root.Context().SpanId {
+		t.Errorf("send parent=%v, want root", kinds[SpanKindSend].ParentId)
+	}
+	for _, kind := range []string{SpanKindQueue, SpanKindExec, SpanKindCb} {
+		if kinds[kind] == nil || kinds[kind].ParentId != req.GetTrace().SpanId {
+			t.Errorf("%s span parent mismatch, span=%+v", kind, kinds[kind])
+		}
+	}
+	if kinds[SpanKindCb].SpanId != cbSpan {
+		t.Errorf("callback spanId=%v, want %v", kinds[SpanKindCb].SpanId, cbSpan)
+	}
+	if kinds[SpanKindExec].Err == "" {
+		t.Errorf("exec span missing err")
+	}
+}
+
+// 没有回调的请求出队时生成send和queue span
+func TestTracerOneway(t *testing.T) {
+	var exporter = &memExporter{}
+	var tracer = NewTracer(exporter)
+	asyn_msg.EnableTrace()
+	asyn_msg.AddHook(tracer)
+
+	var base = asyn_msg.NewAsynBase()
+	defer base.Stop()
+	var req = &testReq{}
+	base.SendReq(req, nil)
+	<-base.ReqChan.C()
+	tracer.Close()
+
+	var trace = req.GetTrace()
+	if !trace.Oneway || len(exporter.spans) != 2 {
+		t.Fatalf("trace=%+v, spans=%d, want 2", trace, len(exporter.spans))
+	}
+	for _, span := range exporter.spans {
+		switch span.Kind {
+		case SpanKindSend:
+			if span.SpanId != trace.SpanId || span.End.Before(span.Start) {
+				t.Errorf("send span=%+v", span)
+			}
+		case SpanKindQueue:
+			if span.ParentId != trace.SpanId {
+				t.Errorf("queue span=%+v", span)
+			}
+		default:
+			t.Errorf("unexpected span %s", span.Kind)
+		}
+	}
+}
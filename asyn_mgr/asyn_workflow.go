@@ -0,0 +1,266 @@
+package asyn_mgr
+
+import (
+	"fmt"
+	"px/shared/asyn_mgr/asyn_msg"
+
+	"gitlab.sunborngame.com/base/log"
+)
+
+type (
+	// StepFunc 生成步骤的请求，返回nil表示本地步骤，不发请求直接完成
+	StepFunc func(ctx *WorkflowCtx) (asyn_msg.ReqInf, error)
+	// StepCheck 检查响应，返回error时步骤失败
+	StepCheck func(ctx *WorkflowCtx, resp asyn_msg.RespInf) error
+)
+
+// WorkflowError 步骤失败，补偿动作执行后回调
+type WorkflowError struct {
+	Workflow       string
+	Step           string
+	Err            error
+	CompensateErrs []error // 补偿失败的只记录，不中断其他补偿
+}
+
+func (this *WorkflowError) Error() string {
+	return fmt.Sprintf("asyn workflow failed, workflow=%s, step=%s, err=%v, compensateErrs=%v", this.Workflow, this.Step, this.Err, this.CompensateErrs)
+}
+
+func (this *WorkflowError) Unwrap() error {
+	return this.Err
+}
+
+// WorkflowCtx 步骤之间传递结果
+type WorkflowCtx struct {
+	resps  map[string]asyn_msg.RespInf
+	values map[string]any
+}
+
+// Resp 已完成步骤的响应
+func (this *WorkflowCtx) Resp(step string) asyn_msg.RespInf {
+	return this.resps[step]
+}
+
+func (this *WorkflowCtx) Set(key string, v any) {
+	this.values[key] = v
+}
+
+func (this *WorkflowCtx) Get(key string) any {
+	return this.values[key]
+}
+
+// StepResp 带类型获取步骤的响应
+func StepResp[Resp asyn_msg.RespInf](ctx *WorkflowCtx, step string) (Resp, error) {
+	return CastResp[Resp](ctx.Resp(step))
+}
+
+// WorkflowStep 工作流中的一步，名字在工作流内唯一
+type WorkflowStep struct {
+	name       string
+	build      StepFunc
+	check      StepCheck
+	compensate StepFunc
+}
+
+func Step(name string, build StepFunc) *WorkflowStep {
+	return &WorkflowStep{name: name, build: build}
+}
+
+// Check 设置后由check判断响应是否成功，否则使用asyn_msg.RespErr，超时和RespError总是失败
+func (this *WorkflowStep) Check(check StepCheck) *WorkflowStep {
+	this.check = check
+	return this
+}
+
+// Compensate 之后的步骤失败时执行的补偿，只对已成功的步骤按完成顺序倒序执行
+func (this *WorkflowStep) Compensate(compensate StepFunc) *WorkflowStep {
+	this.compensate = compensate
+	return this
+}
+
+// 回调的pprof指针，按步骤区分
+func (this *WorkflowStep) ptr() asyn_msg.AsynCBPtr {
+	if this.check != nil {
+		return asyn_msg.CBPtr(this.check)
+	}
+	return asyn_msg.CBPtr(this.build)
+}
+
+// Workflow 按阶段依次执行的异步步骤，同一阶段的步骤并行，和HandleResp在同一个goroutine中使用，非线程安全
+//
+//	asyn_mgr.NewWorkflow("guild_upgrade").
+//		Then(asyn_mgr.Step("load", loadGuild)).
+//		Then(asyn_mgr.Step("save", saveGuild).Compensate(restoreGuild)).
+//		Parallel(asyn_mgr.Step("etcd", writeEtcd), asyn_mgr.Step("notify", notifyClient)).
+//		Run(func(ctx *asyn_mgr.WorkflowCtx, err error) {...})
+type Workflow struct {
+	name   string
+	mgr    *AsynMgr
+	owner  asyn_msg.RespOwner
+	stages [][]*WorkflowStep
+	ctx    *WorkflowCtx
+	done   func(ctx *WorkflowCtx, err error)
+
+	stage     int
+	running   int  // 当前阶段未完成的步骤数
+	starting  bool // 正在发出当前阶段的步骤
+	err       *WorkflowError
+	succeeded []*WorkflowStep // 按完成顺序
+}
+
+func NewWorkflow(name string) *Workflow {
+	return asynMgr.NewWorkflow(name)
+}
+
+func (this *AsynMgr) NewWorkflow(name string) *Workflow {
+	return &Workflow{
+		name: name,
+		mgr:  this,
+	}
+}
+
+// From 在主逻辑以外的goroutine中执行，响应投递给owner
+func (this *Workflow) From(owner asyn_msg.RespOwner) *Workflow {
+	this.owner = owner
+	return this
+}
+
+// Then 增加一个阶段，只有一个步骤
+func (this *Workflow) Then(step *WorkflowStep) *Workflow {
+	return this.Parallel(step)
+}
+
+// Parallel 增加一个阶段，步骤同时发出，全部成功后进入下一阶段
+func (this *Workflow) Parallel(steps ...*WorkflowStep) *Workflow {
+	if len(steps) > 0 {
+		this.stages = append(this.stages, steps)
+	}
+	return this
+}
+
+// Run 开始执行，只能调用一次，done在全部完成或失败并补偿后执行
+func (this *Workflow) Run(done func(ctx *WorkflowCtx, err error)) {
+	if this.ctx != nil {
+		log.Error("asyn workflow already running, workflow=%s", this.name)
+		return
+	}
+	this.ctx = &WorkflowCtx{
+		resps:  make(map[string]asyn_msg.RespInf),
+		values: make(map[string]any),
+	}
+	this.done = done
+	this.runStage()
+}
+
+func (this *Workflow) runStage() {
+	for this.stage < len(this.stages) {
+		var steps = this.stages[this.stage]
+		this.running = len(steps)
+		this.starting = true
+		for _, step := range steps {
+			if this.err != nil {
+				// 同阶段已有失败，之后的步骤不再发出
+				this.running--
+				continue
+			}
+			this.runStep(step)
+		}
+		this.starting = false
+		if this.running > 0 || !this.nextStage() {
+			return
+		}
+	}
+	this.finish()
+}
+
+// 当前阶段完成后调用，失败时开始补偿并返回false
+func (this *Workflow) nextStage() bool {
+	if this.err != nil {
+		this.compensate(len(this.succeeded) - 1)
+		return false
+	}
+	this.stage++
+	return true
+}
+
+func (this *Workflow) runStep(step *WorkflowStep) {
+	req, err := step.build(this.ctx)
+	if err != nil || req == nil {
+		this.stepDone(step, err)
+		return
+	}
+	this.mgr.SendReqFrom(this.owner, req, func(resp asyn_msg.RespInf) asyn_msg.AsynCBPtr {
+		this.ctx.resps[step.name] = resp
+		var err error
+		if e, ok := resp.(error); ok {
+			err = e
+		} else if step.check != nil {
+			err = step.check(this.ctx, resp)
+		} else {
+			err = asyn_msg.RespErr(resp)
+		}
+		this.stepDone(step, err)
+		return step.ptr()
+	})
+}
+
+func (this *Workflow) stepDone(step *WorkflowStep, err error) {
+	this.running--
+	if err != nil {
+		if this.err == nil {
+			this.err = &WorkflowError{Workflow: this.name, Step: step.name, Err: err}
+		}
+	} else {
+		this.succeeded = append(this.succeeded, step)
+	}
+	if this.running > 0 || this.starting {
+		return
+	}
+	if this.nextStage() {
+		this.runStage()
+	}
+}
+
+// 倒序执行succeeded[:i+1]的补偿，一个完成后再执行下一个
+func (this *Workflow) compensate(i int) {
+	for ; i >= 0; i-- {
+		var step = this.succeeded[i]
+		if step.compensate == nil {
+			continue
+		}
+		req, err := step.compensate(this.ctx)
+		if err != nil {
+			this.compensateFailed(step, err)
+			continue
+		}
+		if req == nil {
+			continue
+		}
+		var next = i - 1
+		this.mgr.SendReqFrom(this.owner, req, func(resp asyn_msg.RespInf) asyn_msg.AsynCBPtr {
+			if err := asyn_msg.RespErr(resp); err != nil {
+				this.compensateFailed(step, err)
+			}
+			this.compensate(next)
+			return asyn_msg.CBPtr(step.compensate)
+		})
+		return
+	}
+	this.finish()
+}
+
+func (this *Workflow) compensateFailed(step *WorkflowStep, err error) {
+	log.Error("asyn workflow compensate failed, workflow=%s, step=%s, err=%v", this.name, step.name, err)
+	this.err.CompensateErrs = append(this.err.CompensateErrs, fmt.Errorf("%s: %w", step.name, err))
+}
+
+func (this *Workflow) finish() {
+	var err error
+	if this.err != nil {
+		log.Warning("%v", this.err)
+		err = this.err
+	}
+	if this.done != nil {
+		this.done(this.ctx, err)
+	}
+}
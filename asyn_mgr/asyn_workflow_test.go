@@ -0,0 +1,140 @@
+package asyn_mgr
+
+import (
+	"errors"
+	"px/shared/asyn_mgr/asyn_msg"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Key为fail时返回ErrStuck，其余原样回包，记录处理顺序
+func newTestWorkflowModule() (*testModule, func() []string) {
+	var lock sync.Mutex
+	var handled []string
+	var module = newTestModule(asyn_msg.RedisModuleId, func(req asyn_msg.ReqInf) asyn_msg.RespInf {
+		var key = req.(*testReq).Key
+		lock.Lock()
+		handled = append(handled, key)
+		lock.Unlock()
+		if key == "fail" {
+			return asyn_msg.NewRespError(asyn_msg.RedisModuleId, req, asyn_msg.ErrStuck)
+		}
+		return &testResp{Key: key}
+	})
+	return module, func() []string {
+		lock.Lock()
+		defer lock.Unlock()
+		return append([]string(nil), handled...)
+	}
+}
+
+func testStep(key string) StepFunc {
+	return func(ctx *WorkflowCtx) (asyn_msg.ReqInf, error) {
+		return &testReq{Key: key}, nil
+	}
+}
+
+// 执行到done回调，返回各响应回调的pprof指针
+func runWorkflow(t *testing.T, mgr *AsynMgr, wf *Workflow) (*WorkflowCtx, []asyn_msg.AsynCBPtr, error) {
+	t.Helper()
+	var finished bool
+	var ctx *WorkflowCtx
+	var err error
+	wf.Run(func(c *WorkflowCtx, e error) {
+		finished, ctx, err = true, c, e
+	})
+	var ptrs []asyn_msg.AsynCBPtr
+	for !finished {
+		select {
+		case resp := <-mgr.Resp():
+			ptrs = append(ptrs, mgr.HandleResp(resp))
+		case <-time.After(time.Second):
+			t.Fatal("wait workflow timeout")
+		}
+	}
+	return ctx, ptrs, err
+}
+
+func TestWorkflow(t *testing.T) {
+	var module, handled = newTestWorkflowModule()
+	var mgr = newTestMgr(t, module)
+
+	var check = func(ctx *WorkflowCtx, resp asyn_msg.RespInf) error {
+		ctx.Set("checked", resp.(*testResp).Key)
+		return nil
+	}
+	var wf = mgr.NewWorkflow("test").
+		Then(Step("load", testStep("load")).Check(check)).
+		Parallel(
+			Step("a", func(ctx *WorkflowCtx) (asyn_msg.ReqInf, error) {
+				load, err := StepResp[*testResp](ctx, "load")
+				if err != nil {
+					return nil, err
+				}
+				return &testReq{Key: load.Key + "_a"}, nil
+			}),
+			Step("b", testStep("b")),
+		).
+		Then(Step("local", func(ctx *WorkflowCtx) (asyn_msg.ReqInf, error) {
+			ctx.Set("local", true)
+			return nil, nil
+		}))
+
+	ctx, ptrs, err := runWorkflow(t, mgr, wf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a, err := StepResp[*testResp](ctx, "a"); err != nil || a.Key != "load_a" {
+		t.Errorf("step a resp=%+v, err=%v", a, err)
+	}
+	if ctx.Get("checked") != "load" || ctx.Get("local") != true {
+		t.Errorf("values=%v", ctx.values)
+	}
+	if len(ptrs) != 3 || ptrs[0] != asyn_msg.CBPtr(check) || ptrs[1] == ptrs[0] {
+		t.Errorf("callback ptrs=%v, want step attribution", ptrs)
+	}
+	if got := handled(); len(got) != 3 || got[0] != "load" {
+		t.Errorf("handled=%v", got)
+	}
+}
+
+func TestWorkflowCompensate(t *testing.T) {
+	var module, handled = newTestWorkflowModule()
+	var mgr = newTestMgr(t, module)
+
+	var errUndo = errors.New("undo failed")
+	var wf = mgr.NewWorkflow("test").
+		Then(Step("load", testStep("load")).Compensate(testStep("undo_load"))).
+		Then(Step("save", testStep("save")).Compensate(func(ctx *WorkflowCtx) (asyn_msg.ReqInf, error) {
+			return nil, errUndo
+		})).
+		Parallel(
+			Step("a", testStep("a")).Compensate(testStep("undo_a")),
+			Step("b", testStep("fail")).Compensate(testStep("undo_b")),
+		).
+		Then(Step("c", testStep("c")))
+
+	_, _, err := runWorkflow(t, mgr, wf)
+	var wfErr *WorkflowError
+	if !errors.As(err, &wfErr) || wfErr.Step != "b" || !errors.Is(err, asyn_msg.ErrStuck) {
+		t.Fatalf("err=%v, want step b failed with ErrStuck", err)
+	}
+	if len(wfErr.CompensateErrs) != 1 || !errors.Is(wfErr.CompensateErrs[0], errUndo) {
+		t.Errorf("compensate errs=%v", wfErr.CompensateErrs)
+	}
+
+	// 失败步骤和之后的步骤不补偿，已成功的倒序补偿
+	var got = handled()
+	if len(got) != 6 {
+		t.Fatalf("handled=%v", got)
+	}
+	var parallel = append([]string(nil), got[2:4]...)
+	sort.Strings(parallel)
+	if !reflect.DeepEqual(got[:2], []string{"load", "save"}) || !reflect.DeepEqual(parallel, []string{"a", "fail"}) ||
+		!reflect.DeepEqual(got[4:], []string{"undo_a", "undo_load"}) {
+		t.Errorf("handled=%v", got)
+	}
+}
@@ -6,16 +6,17 @@ import (
 	"px/shared/asyn_mgr/asyn_msg"
 	"px/shared/asyn_mgr/db_pool/db"
 	"px/utils"
-	"px/utils/chanx"
 )
 
 type DB struct {
 	dbInter  db.DBInter
-	reqChan  *chanx.UnboundedChan[asyn_msg.ReqInf]
-	respChan *chanx.UnboundedChan[asyn_msg.RespInf]
+	base     *asyn_msg.AsynBase
+	reqChan  *asyn_msg.Queue[asyn_msg.ReqInf] // db卡住时阻塞DbPoolMgr分发，由模块请求队列的策略处理
+	respChan *asyn_msg.Queue[asyn_msg.RespInf]
+	stopChan chan struct{}
 }
 
-func newDB(cfg *db.MysqlCfg, respChan *chanx.UnboundedChan[asyn_msg.RespInf]) *DB {
+func newDB(cfg *db.MysqlCfg, base *asyn_msg.AsynBase) *DB {
 	db, err := db.NewMysql(cfg)
 	if err != nil {
 		panic(err)
@@ -23,16 +24,20 @@ func newDB(cfg *db.MysqlCfg, respChan *chanx.UnboundedChan[asyn_msg.RespInf]) *D
 
 	return &DB{
 		dbInter:  db,
-		reqChan:  chanx.NewUnboundedChan[asyn_msg.ReqInf](MessageChanCap),
-		respChan: respChan,
+		base:     base,
+		reqChan:  asyn_msg.NewReqQueue(MessageChanCap, asyn_msg.QueuePolicyBlock),
+		respChan: base.RespChan,
+		stopChan: make(chan struct{}),
 	}
 }
 
 func (this *DB) init() {
-	go this.loop()
+	this.base.GoUntil(this.stopChan, this.loop)
 }
 
 func (this *DB) close() {
+	close(this.stopChan)
+	this.reqChan.Close()
 	this.dbInter.CloseLazy()
 }
 
@@ -52,7 +57,9 @@ func (this *DB) run() bool {
 		if !ok {
 			return false
 		}
-		this.handleReq(req)
+		this.base.Process(req, this.handleReq)
+	case <-this.stopChan:
+		return false
 	}
 
 	return true
@@ -7,6 +7,7 @@ import (
 	"px/proto/proto_db"
 	"strconv"
 	"sync/atomic"
+	"time"
 )
 
 type (
@@ -24,6 +25,8 @@ type (
 
 const (
 	userMod uint64 = 16
+
+	closeLazyInterval = 10 * time.Millisecond
 )
 
 func NewMysql(cfg *MysqlCfg) (*Mysql, error) {
@@ -251,7 +254,7 @@ func (m *Mysql) CloseImmediately() {
 
 func (m *Mysql) CloseLazy() {
 	for atomic.LoadInt32(&m.working) != 0 {
-		continue
+		time.Sleep(closeLazyInterval)
 	}
 	m.DbImp.Close()
 }
@@ -41,7 +41,7 @@ func CreateDbPoolMgr(poolSize int, cfg *db.MysqlCfg) asyn_msg.AsynModInf {
 
 func (this *DbPoolMgr) initDbs(poolSize int, cfg *db.MysqlCfg) {
 	for i := 0; i < poolSize; i++ {
-		db := newDB(cfg, this.RespChan)
+		db := newDB(cfg, this.AsynBase)
 		this.dbs = append(this.dbs, db)
 	}
 }
@@ -50,18 +50,24 @@ func (this *DbPoolMgr) GetModuleId() asyn_msg.AsynModuleId {
 	return asyn_msg.DbPoolModuleId
 }
 
+// ReqLen 包括已分发到各个db还未处理的请求
 func (this *DbPoolMgr) ReqLen() int {
-	return len(this.CallBacks)
+	l := this.AsynBase.ReqLen()
+	for _, db := range this.dbs {
+		l += db.reqChan.Len()
+	}
+	return l
 }
 
 func (this *DbPoolMgr) Init() {
 	for _, db := range this.dbs {
 		db.init()
 	}
-	go this.loop()
+	this.Go(this.loop)
 }
 
 func (this *DbPoolMgr) Close() {
+	this.Stop()
 	for _, db := range this.dbs {
 		db.close()
 	}
@@ -83,7 +89,9 @@ func (this *DbPoolMgr) run() bool {
 		if !ok {
 			return false
 		}
-		this.handleReq(req)
+		this.Process(req, this.handleReq)
+	case <-this.Done():
+		return false
 	}
 
 	return true
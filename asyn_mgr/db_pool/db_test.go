@@ -8,7 +8,6 @@ import (
 	"px/proto/proto_db"
 	"px/shared/asyn_mgr/asyn_msg"
 	"px/shared/asyn_mgr/db_pool/db"
-	"px/utils/chanx"
 	"strconv"
 	"sync"
 	"sync/atomic"
@@ -40,7 +39,8 @@ type Config struct {
 }
 
 var (
-	sRespChan     = chanx.NewUnboundedChan[asyn_msg.RespInf](10240)
+	sBase         = asyn_msg.NewAsynBase()
+	sRespChan     = sBase.RespChan
 	sDBs          []*DB
 	sConfig       = Config{}
 	sProcessCount = int32(0)
@@ -480,7 +480,7 @@ func TestDB(t *testing.T) {
 	}
 
 	for i := 0; i < int(sConfig.DBCount); i++ {
-		db := newDB(&dbCnf, sRespChan)
+		db := newDB(&dbCnf, sBase)
 		db.init()
 		sDBs[i] = db
 	}
@@ -540,7 +540,7 @@ func TestGetProp(t *testing.T) {
 		Database: sConfig.DBDatabase,
 	}
 
-	db := newDB(&dbCnf, sRespChan)
+	db := newDB(&dbCnf, sBase)
 	db.init()
 
 	log.Info("db init ok")
@@ -32,3 +32,20 @@ func (this *ReqBase) GetModuleId() asyn_msg.AsynModuleId {
 func (this *RespBase) GetModuleId() asyn_msg.AsynModuleId {
 	return asyn_msg.DbPoolModuleId
 }
+
+// CoalesceKey 只合并SELECT，用于asyn_mgr.EnableCoalesce
+func (this *ReqDBOperator) CoalesceKey() string {
+	if this.Op != proto_db.DB_OPERATOR_DB_OP_SELECT {
+		return ""
+	}
+	var parts = make([]any, 0, 1+2*len(this.Args))
+	parts = append(parts, this.Sql)
+	for _, arg := range this.Args {
+		if arg == nil {
+			return ""
+		}
+		parts = append(parts, int32(arg.ArgsType), arg.Args)
+	}
+	key, _ := asyn_msg.ScalarKey(parts...)
+	return key
+}
@@ -40,34 +40,37 @@ func (this *DbMgr) GetModuleId() asyn_msg.AsynModuleId {
 	return asyn_msg.DbClientModuleId
 }
 
-func (this *DbMgr) ReqLen() int {
-	return len(this.CallBacks)
-}
-
 func (this *DbMgr) Init() {
 	this.client.Start(defaultConnTimeout)
 	this.timer.Start()
 
-	go this.loop()
+	go this.waitConnect()
 
 	this.timer.AddRepeatTimer(DbTick, this.tick)
 }
 
 func (this *DbMgr) Close() {
-
+	this.Stop()
+	this.timer.Stop()
 }
 
 func (this *DbMgr) tick(now int64, _ cbctx.Ctx) {
 	this.client.tick(now)
 }
 
-func (this *DbMgr) loop() {
+func (this *DbMgr) waitConnect() {
 	defer utils.Recover(framebase.SendWeChatMsg(), framebase.IsReleaseEnv())
 
 	select { // first wait connect to dbproxy
 	case resp := <-this.client.respChan.C():
 		this.client.handleResp(resp)
+	case <-this.Done():
+		return
 	}
+	this.Go(this.loop)
+}
+
+func (this *DbMgr) loop() {
 	for {
 		if !this.run() {
 			break
@@ -89,9 +92,11 @@ func (this *DbMgr) run() bool {
 			if !ok {
 				return false
 			}
-			this.handleReq(req)
+			this.Process(req, this.handleReq)
 		case resp := <-this.client.respChan.C():
 			this.client.handleResp(resp)
+		case <-this.Done():
+			return false
 		}
 	}
 
@@ -99,7 +104,6 @@ func (this *DbMgr) run() bool {
 }
 
 func (this *DbMgr) handleReq(req asyn_msg.ReqInf) {
-	log.Info("[DL_DBOperator] handleReq, fcId=%d", req.GetFcId())
 	switch msg := req.(type) {
 	case *ReqDbQuery:
 		dbArgs, e := this.client.toDbArgs(msg.Args)
@@ -55,3 +55,15 @@ func (this *ReqBase) GetModuleId() asyn_msg.AsynModuleId {
 func (this *RespBase) GetModuleId() asyn_msg.AsynModuleId {
 	return asyn_msg.DbClientModuleId
 }
+
+// CoalesceKey 只合并非阻塞、参数都是标量的SELECT，用于asyn_mgr.EnableCoalesce
+func (this *ReqDbQuery) CoalesceKey() string {
+	if this.Op != proto_db.DB_OPERATOR_DB_OP_SELECT || this.GetBlockCh() != nil {
+		return ""
+	}
+	key, ok := asyn_msg.ScalarKey(append([]any{this.Sql}, this.Args...)...)
+	if !ok {
+		return ""
+	}
+	return key
+}
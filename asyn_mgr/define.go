@@ -1,5 +1,14 @@
 package asyn_mgr
 
+import "time"
+
 const (
 	MessageChanCap = 1024
+	TimeoutTick    = 100 * time.Millisecond // 请求超时检查间隔
+
+	ShutdownTimeout = 10 * time.Second // 关闭时等待请求完成的时间
+
+	WatchdogTick        = time.Second      // 模块卡住检查间隔
+	DefaultStuckTimeout = 30 * time.Second // 单个请求处理超过该时间判定模块卡住
+	DegradedWindow      = time.Minute      // loop重启后该时间内健康状态为degraded
 )
@@ -14,6 +14,7 @@ import (
 	"px/shared/asyn_mgr/etcd/etcd_data_inf"
 	"px/utils"
 	"reflect"
+	"sync"
 	"time"
 )
 
@@ -24,17 +25,27 @@ var (
 const (
 	LogTag    = "[etcd_mgr]"
 	OpTimeout = 5 * time.Second
+
+	DefaultWorkers = 4
 )
 
 type EtcdMgr struct {
 	*asyn_msg.AsynBase
-	cli *clientv3.Client
+	cli     *clientv3.Client
+	workers *asyn_msg.WorkerPool // 相同key的请求按顺序执行
+
+	// watch的回调常驻，关闭时需要单独清理
+	ctx     context.Context
+	cancel  context.CancelFunc
+	watches sync.Map // fcId -> *ReqWatch
 }
 
 func CreateEtcd() asyn_msg.AsynModInf {
 	var etcdMgr = &EtcdMgr{
 		AsynBase: asyn_msg.NewAsynBase(),
 	}
+	etcdMgr.ctx, etcdMgr.cancel = context.WithCancel(context.Background())
+	etcdMgr.SetWorkers(DefaultWorkers)
 
 	initConf()
 
@@ -51,12 +62,55 @@ func initConf() {
 	}
 }
 
+// SetWorkers Init之前设置并发处理请求的worker数
+func (this *EtcdMgr) SetWorkers(n int) {
+	this.workers = asyn_msg.NewWorkerPool(this.AsynBase, n, orderKey, this.handleReq)
+}
+
+// ReqLen 包括已分发到worker还未执行的请求
+func (this *EtcdMgr) ReqLen() int {
+	return this.AsynBase.ReqLen() + this.workers.Queued()
+}
+
 func (this *EtcdMgr) Init() {
-	go this.loop()
+	this.workers.Start()
+	this.Go(this.loop)
 }
 
-func (this *EtcdMgr) Close() {
+// StopAccept 停止接收新请求，同时取消所有watch，watch回调以RespError(ErrClosed)结束
+// 模块移除或重启时回调已由AsynMgr取出，以ErrModuleRemoved/ErrModuleRestarted失败
+func (this *EtcdMgr) StopAccept() {
+	this.AsynBase.StopAccept()
+	this.cancel()
+	this.watches.Range(func(key, value any) bool {
+		var req = value.(*ReqWatch)
+		this.watches.Delete(key)
+		if _, ok := this.GetCallBack(req.GetFcId()); ok {
+			this.RespChan.Put(asyn_msg.NewRespError(this.GetModuleId(), req, asyn_msg.ErrClosed))
+		}
+		return true
+	})
+}
+
+func (this *EtcdMgr) SendReq(req asyn_msg.ReqInf, cb asyn_msg.AsynCallback) {
+	this.SendReqFrom(nil, req, cb)
+}
+
+// SendReqFrom watch的回调常驻，不能超时，否则RespTimeout会删除回调
+func (this *EtcdMgr) SendReqFrom(owner asyn_msg.RespOwner, req asyn_msg.ReqInf, cb asyn_msg.AsynCallback) {
+	if watch, ok := req.(*ReqWatch); ok && watch.GetTimeout() > 0 {
+		log.Warning("%s watch ignore timeout, key=%s, timeout=%d", LogTag, watch.Key, watch.GetTimeout())
+		watch.SetTimeout(0)
+	}
+	this.AsynBase.SendReqFrom(owner, req, cb)
+}
 
+func (this *EtcdMgr) Close() {
+	this.StopAccept()
+	this.Stop()
+	if err := this.cli.Close(); err != nil {
+		log.Error("%s close err:%v", LogTag, err)
+	}
 }
 
 func (this *EtcdMgr) loop() {
@@ -75,7 +129,9 @@ func (this *EtcdMgr) run() bool {
 		if !ok {
 			return false
 		}
-		this.handleReq(req)
+		this.workers.Dispatch(req)
+	case <-this.Done():
+		return false
 	}
 
 	return true
@@ -85,10 +141,6 @@ func (this *EtcdMgr) GetModuleId() asyn_msg.AsynModuleId {
 	return asyn_msg.EtcdModuleId
 }
 
-func (this *EtcdMgr) ReqLen() int {
-	return 0
-}
-
 func (this *EtcdMgr) initClientv3() {
 	var dialTimeout = config.GetEtcdConfig().GetDialTimeout()
 	cli, err := clientv3.New(clientv3.Config{
@@ -101,12 +153,24 @@ func (this *EtcdMgr) initClientv3() {
 	this.cli = cli
 }
 
+// 同一个key的请求按顺序执行
+func orderKey(req asyn_msg.ReqInf) string {
+	switch msg := req.(type) {
+	case *ReqWrite:
+		return msg.Key
+	case *ReqWatch:
+		return msg.Key
+	default:
+		return ""
+	}
+}
+
 func (this *EtcdMgr) handleReq(req asyn_msg.ReqInf) {
-	log.Info("%s etcd recv req:%v", LogTag, req)
 	switch msg := req.(type) {
 	case *ReqWrite:
 		this.handleWrite(msg)
 	case *ReqWatch:
+		this.watches.Store(msg.GetFcId(), msg)
 		go this.handleWatch(msg)
 	default:
 		log.Error("%s etcd reqMsg err %v", LogTag, req)
@@ -131,6 +195,7 @@ func (this *EtcdMgr) handleWrite(req *ReqWrite) {
 	_, err = this.cli.Put(ctx, req.Key, etcdData)
 	if err != nil {
 		log.Error("%s etcd put err:%v", LogTag, err)
+		resp.Err = err.Error()
 	}
 }
 
@@ -139,7 +204,9 @@ func (this *EtcdMgr) handleWatch(req *ReqWatch) {
 	if req.WithPrefix {
 		options = append(options, clientv3.WithPrefix())
 	}
-	res, err := this.cli.Get(context.TODO(), req.Key, options...)
+	defer this.watches.Delete(req.GetFcId())
+
+	res, err := this.cli.Get(this.ctx, req.Key, options...)
 	if err != nil {
 		log.Error("[EtcdMgr] err : %s", err.Error())
 		return
@@ -164,10 +231,16 @@ func (this *EtcdMgr) handleWatch(req *ReqWatch) {
 		this.RespChan.Put(resp)
 	}
 
-	ch := this.cli.Watch(context.TODO(), req.Key, options...)
+	ch := this.cli.Watch(this.ctx, req.Key, options...)
 	for {
 		select {
-		case c := <-ch:
+		case <-this.ctx.Done():
+			return
+		case c, ok := <-ch:
+			if !ok {
+				log.Warning("%s watch closed, key=%s", LogTag, req.Key)
+				return
+			}
 			resp.Datas = make([]*EtcdOpData, 0, len(c.Events))
 			for _, e := range c.Events {
 				var value = this.decode(string(e.Kv.Value))
@@ -198,18 +271,22 @@ func (this *EtcdMgr) handleWatch(req *ReqWatch) {
 
 func (this *EtcdMgr) HandleResp(resp asyn_msg.RespInf) asyn_msg.AsynCBPtr {
 	switch resp.(type) {
-	case *RespWrite:
+	case *RespWrite, *asyn_msg.RespTimeout, *asyn_msg.RespError:
 		return this.AsynBase.HandleResp(resp)
 	default:
-		cb, ok := this.CallBacks[resp.GetFcId()]
+		cb, ok := this.GetCallBack(resp.GetFcId())
 		if !ok {
+			if this.TakeTimedOut(resp.GetFcId()) {
+				log.Warning("resp after timeout, fcid=%d, resp=%v", resp.GetFcId(), resp)
+				return 0
+			}
 			log.Error("callback not found, fcid=%d, resp=%v", resp.GetFcId(), resp)
 			return 0
 		}
 
 		x := cb(resp)
 		if x == 0 {
-			x = asyn_msg.AsynCBPtr(reflect.ValueOf(cb).Pointer())
+			x = asyn_msg.CBPtr(cb)
 		}
 
 		return x
@@ -14,6 +14,7 @@ const (
 
 type Client struct {
 	rClient *redis.Client
+	redisCmds
 }
 
 func NewClient(addr string) *Client {
@@ -27,7 +28,10 @@ func NewClient(addr string) *Client {
 	}
 	log.Info("connect redis success")
 
-	return &Client{rClient: rClient}
+	return &Client{
+		rClient:   rClient,
+		redisCmds: redisCmds{rc: rClient, tag: ClientLogTag},
+	}
 }
 
 func (this *Client) Close() {
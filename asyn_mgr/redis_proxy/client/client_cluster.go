@@ -14,6 +14,7 @@ const (
 
 type ClientCluster struct {
 	rClient *redis.ClusterClient
+	redisCmds
 }
 
 func NewClientCluster(addrs []string) *ClientCluster {
@@ -27,11 +28,14 @@ func NewClientCluster(addrs []string) *ClientCluster {
 	}
 	log.Info("connect redis success")
 
-	return &ClientCluster{rClient: rClient}
+	return &ClientCluster{
+		rClient:   rClient,
+		redisCmds: redisCmds{rc: rClient, tag: ClientClusterLogTag},
+	}
 }
 
 func (this *ClientCluster) Close() {
-
+	this.rClient.Close()
 }
 
 func (this *ClientCluster) Set(key string, value *redis_inf.RedisData, ttl time.Duration) error {
@@ -99,3 +103,63 @@ func (this *ClientCluster) Del(key string) error {
 	}
 	return err
 }
+
+// Exists key可能在不同slot，按slot分组用pipeline执行EXISTS，返回存在的key总数
+func (this *ClientCluster) Exists(keys ...string) (int64, error) {
+	var slots = make(map[int][]string)
+	for _, key := range keys {
+		slots[Slot(key)] = append(slots[Slot(key)], key)
+	}
+	var cmds = make([]*redis.IntCmd, 0, len(slots))
+	_, err := this.rClient.Pipelined(context.Background(), func(pipe redis.Pipeliner) error {
+		for _, slotKeys := range slots {
+			cmds = append(cmds, pipe.Exists(context.Background(), slotKeys...))
+		}
+		return nil
+	})
+	if err != nil {
+		log.Error("%v redis exists err:%v, keys=%v", ClientClusterLogTag, err, keys)
+		return 0, err
+	}
+	var n int64
+	for _, cmd := range cmds {
+		n += cmd.Val()
+	}
+	return n, nil
+}
+
+// MGet key可能在不同slot，用pipeline逐个GET，不存在的key不在结果中
+func (this *ClientCluster) MGet(keys ...string) (map[string]string, error) {
+	var cmds = make([]*redis.StringCmd, 0, len(keys))
+	_, err := this.rClient.Pipelined(context.Background(), func(pipe redis.Pipeliner) error {
+		for _, key := range keys {
+			cmds = append(cmds, pipe.Get(context.Background(), key))
+		}
+		return nil
+	})
+	if err != nil && !IsNil(err) {
+		log.Error("%v redis mget err:%v, keys=%v", ClientClusterLogTag, err, keys)
+		return nil, err
+	}
+	var ret = make(map[string]string, len(keys))
+	for i, cmd := range cmds {
+		if value, err := cmd.Result(); err == nil {
+			ret[keys[i]] = value
+		}
+	}
+	return ret, nil
+}
+
+// MSet key可能在不同slot，用pipeline逐个SET，不保证原子性
+func (this *ClientCluster) MSet(values map[string]*redis_inf.RedisData) error {
+	_, err := this.rClient.Pipelined(context.Background(), func(pipe redis.Pipeliner) error {
+		for key, value := range values {
+			pipe.Set(context.Background(), key, value, 0)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Error("%v redis mset err:%v", ClientClusterLogTag, err)
+	}
+	return err
+}
@@ -0,0 +1,190 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"github.com/redis/go-redis/v9"
+	"gitlab.sunborngame.com/base/log"
+	"px/shared/asyn_mgr/redis_proxy/redis_inf"
+	"time"
+)
+
+type SetMode string
+
+const (
+	SetModeAlways SetMode = ""
+	SetModeNX     SetMode = "NX" // key不存在时设置
+	SetModeXX     SetMode = "XX" // key存在时设置
+)
+
+// SetOpt SET的选项，KeepTtl时忽略Ttl
+type SetOpt struct {
+	Mode    SetMode
+	Ttl     time.Duration
+	KeepTtl bool
+}
+
+// IsNil key不存在、列表为空等情况
+func IsNil(err error) bool {
+	return errors.Is(err, redis.Nil)
+}
+
+// Client和ClientCluster共用的命令，集群中多key命令由ClientCluster单独实现
+type redisCmds struct {
+	rc  redis.Cmdable
+	tag string
+}
+
+func (this *redisCmds) logErr(cmd string, key string, err error) {
+	if err != nil && !IsNil(err) {
+		log.Error("%v redis %s err:%v, key=%s", this.tag, cmd, err, key)
+	}
+}
+
+func (this *redisCmds) IncrBy(key string, delta int64) (int64, error) {
+	value, err := this.rc.IncrBy(context.Background(), key, delta).Result()
+	this.logErr("incrby", key, err)
+	return value, err
+}
+
+func (this *redisCmds) DecrBy(key string, delta int64) (int64, error) {
+	value, err := this.rc.DecrBy(context.Background(), key, delta).Result()
+	this.logErr("decrby", key, err)
+	return value, err
+}
+
+// SetNX 返回是否设置成功
+func (this *redisCmds) SetNX(key string, value *redis_inf.RedisData, ttl time.Duration) (bool, error) {
+	ok, err := this.rc.SetNX(context.Background(), key, value, ttl).Result()
+	this.logErr("setnx", key, err)
+	return ok, err
+}
+
+// SetArgs 返回是否设置成功，NX、XX条件不满足时为false
+func (this *redisCmds) SetArgs(key string, value *redis_inf.RedisData, opt SetOpt) (bool, error) {
+	var args = redis.SetArgs{
+		Mode:    string(opt.Mode),
+		TTL:     opt.Ttl,
+		KeepTTL: opt.KeepTtl,
+	}
+	if opt.KeepTtl {
+		args.TTL = 0
+	}
+	err := this.rc.SetArgs(context.Background(), key, value, args).Err()
+	if IsNil(err) {
+		return false, nil
+	}
+	this.logErr("set", key, err)
+	return err == nil, err
+}
+
+// Exists 返回存在的key数量
+func (this *redisCmds) Exists(keys ...string) (int64, error) {
+	n, err := this.rc.Exists(context.Background(), keys...).Result()
+	if err != nil {
+		log.Error("%v redis exists err:%v, keys=%v", this.tag, err, keys)
+	}
+	return n, err
+}
+
+// MGet 不存在的key不在结果中
+func (this *redisCmds) MGet(keys ...string) (map[string]string, error) {
+	values, err := this.rc.MGet(context.Background(), keys...).Result()
+	if err != nil {
+		log.Error("%v redis mget err:%v, keys=%v", this.tag, err, keys)
+		return nil, err
+	}
+	var ret = make(map[string]string, len(keys))
+	for i, value := range values {
+		if s, ok := value.(string); ok {
+			ret[keys[i]] = s
+		}
+	}
+	return ret, nil
+}
+
+func (this *redisCmds) MSet(values map[string]*redis_inf.RedisData) error {
+	err := this.rc.MSet(context.Background(), toArgs(values)).Err()
+	if err != nil {
+		log.Error("%v redis mset err:%v", this.tag, err)
+	}
+	return err
+}
+
+func (this *redisCmds) HGetAll(key string) (map[string]string, error) {
+	values, err := this.rc.HGetAll(context.Background(), key).Result()
+	this.logErr("hgetall", key, err)
+	return values, err
+}
+
+func (this *redisCmds) HMSet(key string, values map[string]*redis_inf.RedisData) error {
+	err := this.rc.HSet(context.Background(), key, toArgs(values)).Err()
+	this.logErr("hmset", key, err)
+	return err
+}
+
+func (this *redisCmds) HIncrBy(key1 string, key2 string, delta int64) (int64, error) {
+	value, err := this.rc.HIncrBy(context.Background(), key1, key2, delta).Result()
+	this.logErr("hincrby", key1, err)
+	return value, err
+}
+
+// LPush 返回列表长度
+func (this *redisCmds) LPush(key string, values ...*redis_inf.RedisData) (int64, error) {
+	var args = make([]any, 0, len(values))
+	for _, value := range values {
+		args = append(args, value)
+	}
+	n, err := this.rc.LPush(context.Background(), key, args...).Result()
+	this.logErr("lpush", key, err)
+	return n, err
+}
+
+// RPop 列表为空时返回redis.Nil，用IsNil判断
+func (this *redisCmds) RPop(key string) (string, error) {
+	value, err := this.rc.RPop(context.Background(), key).Result()
+	this.logErr("rpop", key, err)
+	return value, err
+}
+
+func (this *redisCmds) LRange(key string, start, stop int64) ([]string, error) {
+	values, err := this.rc.LRange(context.Background(), key, start, stop).Result()
+	this.logErr("lrange", key, err)
+	return values, err
+}
+
+// SAdd 返回新加入的成员数
+func (this *redisCmds) SAdd(key string, members ...string) (int64, error) {
+	n, err := this.rc.SAdd(context.Background(), key, toMembers(members)...).Result()
+	this.logErr("sadd", key, err)
+	return n, err
+}
+
+// SRem 返回移除的成员数
+func (this *redisCmds) SRem(key string, members ...string) (int64, error) {
+	n, err := this.rc.SRem(context.Background(), key, toMembers(members)...).Result()
+	this.logErr("srem", key, err)
+	return n, err
+}
+
+func (this *redisCmds) SMembers(key string) ([]string, error) {
+	members, err := this.rc.SMembers(context.Background(), key).Result()
+	this.logErr("smembers", key, err)
+	return members, err
+}
+
+func toArgs(values map[string]*redis_inf.RedisData) map[string]any {
+	var args = make(map[string]any, len(values))
+	for key, value := range values {
+		args[key] = value
+	}
+	return args
+}
+
+func toMembers(members []string) []any {
+	var args = make([]any, 0, len(members))
+	for _, member := range members {
+		args = append(args, member)
+	}
+	return args
+}
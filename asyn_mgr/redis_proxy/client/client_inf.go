@@ -14,4 +14,33 @@ type ClientInf interface {
 	HDel(key1 string, key2 ...string) error
 	Ttl(key string, ttl time.Duration) error
 	Del(key string) error
+
+	IncrBy(key string, delta int64) (int64, error)
+	DecrBy(key string, delta int64) (int64, error)
+	SetNX(key string, value *redis_inf.RedisData, ttl time.Duration) (bool, error)
+	SetArgs(key string, value *redis_inf.RedisData, opt SetOpt) (bool, error)
+	Exists(keys ...string) (int64, error)
+	MGet(keys ...string) (map[string]string, error)
+	MSet(values map[string]*redis_inf.RedisData) error
+
+	HGetAll(key string) (map[string]string, error)
+	HMSet(key string, values map[string]*redis_inf.RedisData) error
+	HIncrBy(key1 string, key2 string, delta int64) (int64, error)
+
+	LPush(key string, values ...*redis_inf.RedisData) (int64, error)
+	RPop(key string) (string, error)
+	LRange(key string, start, stop int64) ([]string, error)
+
+	// 集合成员按原始字符串存储，不经过RedisData编码
+	SAdd(key string, members ...string) (int64, error)
+	SRem(key string, members ...string) (int64, error)
+	SMembers(key string) ([]string, error)
+
+	// Pipeline 一次往返执行多个命令，结果与ops一一对应
+	Pipeline(ops []PipeOp, opt PipeOpt) ([]PipeResult, error)
+
+	// 分布式锁，owner标识持有者，释放和续期只对自己持有的锁生效
+	LockAcquire(name string, owner string, ttl time.Duration) (int64, error)
+	LockRenew(name string, owner string, ttl time.Duration) (bool, error)
+	LockRelease(name string, owner string) (bool, error)
 }
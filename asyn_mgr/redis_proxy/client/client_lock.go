@@ -0,0 +1,58 @@
+package client
+
+import (
+	"context"
+	"github.com/redis/go-redis/v9"
+	"time"
+)
+
+// 加锁成功时递增fence计数作为fencing token，锁已被占用返回0
+var lockAcquireScript = redis.NewScript(`
+if redis.call('SET', KEYS[1], ARGV[1], 'NX', 'PX', ARGV[2]) then
+	return redis.call('INCR', KEYS[2])
+end
+return 0`)
+
+// 仍由owner持有时延长过期时间
+var lockRenewScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0`)
+
+// 仍由owner持有时删除，不会误删过期后被其他人获得的锁
+var lockReleaseScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0`)
+
+// LockKeys 锁名对应的锁key和fence key，用{hash tag}保证集群中在同一个slot
+func LockKeys(name string) (string, string) {
+	var key = "lock:{" + name + "}"
+	return key, key + ":fence"
+}
+
+// LockAcquire 获得锁返回递增的fencing token，锁被其他owner持有时返回0
+func (this *redisCmds) LockAcquire(name string, owner string, ttl time.Duration) (int64, error) {
+	key, fence := LockKeys(name)
+	token, err := lockAcquireScript.Run(context.Background(), this.rc, []string{key, fence}, owner, ttl.Milliseconds()).Int64()
+	this.logErr("lock acquire", key, err)
+	return token, err
+}
+
+// LockRenew 返回是否仍持有锁
+func (this *redisCmds) LockRenew(name string, owner string, ttl time.Duration) (bool, error) {
+	key, _ := LockKeys(name)
+	n, err := lockRenewScript.Run(context.Background(), this.rc, []string{key}, owner, ttl.Milliseconds()).Int64()
+	this.logErr("lock renew", key, err)
+	return n == 1, err
+}
+
+// LockRelease 返回是否由owner释放，锁已过期或被其他owner持有时为false
+func (this *redisCmds) LockRelease(name string, owner string) (bool, error) {
+	key, _ := LockKeys(name)
+	n, err := lockReleaseScript.Run(context.Background(), this.rc, []string{key}, owner).Int64()
+	this.logErr("lock release", key, err)
+	return n == 1, err
+}
@@ -0,0 +1,234 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"gitlab.sunborngame.com/base/log"
+	"px/shared/asyn_mgr/redis_proxy/redis_inf"
+	"sync"
+	"time"
+)
+
+var (
+	ErrCrossSlot   = errors.New("redis pipeline keys in different slots")
+	ErrTxConflict  = errors.New("redis watched keys changed")
+	ErrUnknownPipe = errors.New("redis unknown pipeline cmd")
+)
+
+type PipeCmd int32
+
+const (
+	PipeCmdSet PipeCmd = iota + 1
+	PipeCmdGet
+	PipeCmdDel
+	PipeCmdExpire
+	PipeCmdIncrBy
+	PipeCmdHSet
+	PipeCmdHGet
+	PipeCmdHDel
+	PipeCmdHGetAll
+	PipeCmdHIncrBy
+)
+
+// PipeOp pipeline中的一个命令，按Cmd使用对应字段
+type PipeOp struct {
+	Cmd    PipeCmd
+	Key    string
+	Field  string   // HSet、HGet、HIncrBy
+	Fields []string // HDel
+	Value  *redis_inf.RedisData
+	Delta  int64
+	Ttl    time.Duration // Set、Expire
+}
+
+// PipeOpt Tx时以MULTI/EXEC执行，Watch非空时总是使用事务，执行前被修改返回ErrTxConflict
+type PipeOpt struct {
+	Tx    bool
+	Watch []string
+	// WATCH之后、MULTI之前检查当前值，返回error时不执行，用于乐观锁
+	Check func(get func(key string) (string, error)) error
+}
+
+// PipeResult 按Cmd取对应字段，key不存在时Err为redis.Nil
+type PipeResult struct {
+	Str string
+	Map map[string]string
+	Int int64
+	Err error
+}
+
+func addPipeOps(pipe redis.Pipeliner, ops []PipeOp) ([]redis.Cmder, error) {
+	var ctx = context.Background()
+	var cmds = make([]redis.Cmder, 0, len(ops))
+	for _, op := range ops {
+		var cmd redis.Cmder
+		switch op.Cmd {
+		case PipeCmdSet:
+			cmd = pipe.Set(ctx, op.Key, op.Value, op.Ttl)
+		case PipeCmdGet:
+			cmd = pipe.Get(ctx, op.Key)
+		case PipeCmdDel:
+			cmd = pipe.Del(ctx, op.Key)
+		case PipeCmdExpire:
+			cmd = pipe.Expire(ctx, op.Key, op.Ttl)
+		case PipeCmdIncrBy:
+			cmd = pipe.IncrBy(ctx, op.Key, op.Delta)
+		case PipeCmdHSet:
+			cmd = pipe.HSet(ctx, op.Key, op.Field, op.Value)
+		case PipeCmdHGet:
+			cmd = pipe.HGet(ctx, op.Key, op.Field)
+		case PipeCmdHDel:
+			cmd = pipe.HDel(ctx, op.Key, op.Fields...)
+		case PipeCmdHGetAll:
+			cmd = pipe.HGetAll(ctx, op.Key)
+		case PipeCmdHIncrBy:
+			cmd = pipe.HIncrBy(ctx, op.Key, op.Field, op.Delta)
+		default:
+			return nil, fmt.Errorf("%w, cmd=%d, key=%s", ErrUnknownPipe, op.Cmd, op.Key)
+		}
+		cmds = append(cmds, cmd)
+	}
+	return cmds, nil
+}
+
+func pipeResults(cmds []redis.Cmder, results []PipeResult, idx []int) {
+	for i, cmd := range cmds {
+		var result = &results[idx[i]]
+		result.Err = cmd.Err()
+		switch c := cmd.(type) {
+		case *redis.StringCmd:
+			result.Str = c.Val()
+		case *redis.IntCmd:
+			result.Int = c.Val()
+		case *redis.MapStringStringCmd:
+			result.Map = c.Val()
+		}
+	}
+}
+
+// 执行一组命令，结果写入results[idx[i]]，返回事务或连接错误
+func runPipeline(rc redis.Cmdable, watch func(func(*redis.Tx) error, ...string) error, ops []PipeOp, opt PipeOpt, results []PipeResult, idx []int) error {
+	var cmds []redis.Cmder
+	var fn = func(pipe redis.Pipeliner) error {
+		var err error
+		cmds, err = addPipeOps(pipe, ops)
+		return err
+	}
+
+	var err error
+	switch {
+	case len(opt.Watch) > 0:
+		err = watch(func(tx *redis.Tx) error {
+			if opt.Check != nil {
+				var get = func(key string) (string, error) {
+					return tx.Get(context.Background(), key).Result()
+				}
+				if err := opt.Check(get); err != nil {
+					return err
+				}
+			}
+			_, err := tx.TxPipelined(context.Background(), fn)
+			return err
+		}, opt.Watch...)
+	case opt.Tx:
+		_, err = rc.TxPipelined(context.Background(), fn)
+	default:
+		_, err = rc.Pipelined(context.Background(), fn)
+	}
+	if errors.Is(err, redis.TxFailedErr) {
+		return ErrTxConflict
+	}
+	if cmds == nil {
+		return err
+	}
+	pipeResults(cmds, results, idx)
+	// 单个命令的错误已在结果中
+	for _, cmd := range cmds {
+		if cmd.Err() == err {
+			return nil
+		}
+	}
+	return err
+}
+
+// Pipeline 一次往返执行ops，返回每个命令的结果，事务冲突、连接错误等整体失败时返回error
+func (this *Client) Pipeline(ops []PipeOp, opt PipeOpt) ([]PipeResult, error) {
+	if len(ops) == 0 {
+		return nil, nil
+	}
+	var results = make([]PipeResult, len(ops))
+	var watch = func(fn func(*redis.Tx) error, keys ...string) error {
+		return this.rClient.Watch(context.Background(), fn, keys...)
+	}
+	if err := runPipeline(this.rClient, watch, ops, opt, results, seqIdx(len(ops))); err != nil {
+		if !errors.Is(err, ErrTxConflict) {
+			log.Error("%v redis pipeline err:%v, count=%d", ClientLogTag, err, len(ops))
+		}
+		return results, err
+	}
+	return results, nil
+}
+
+// Pipeline 非事务时按slot拆分并发执行；事务和WATCH的key必须在同一个slot(用{hash tag})，否则返回ErrCrossSlot
+func (this *ClientCluster) Pipeline(ops []PipeOp, opt PipeOpt) ([]PipeResult, error) {
+	if len(ops) == 0 {
+		return nil, nil
+	}
+	var results = make([]PipeResult, len(ops))
+	var watch = func(fn func(*redis.Tx) error, keys ...string) error {
+		return this.rClient.Watch(context.Background(), fn, keys...)
+	}
+
+	var slots = make(map[int][]int) // slot -> ops下标
+	var order []int
+	for i, op := range ops {
+		var slot = Slot(op.Key)
+		if _, ok := slots[slot]; !ok {
+			order = append(order, slot)
+		}
+		slots[slot] = append(slots[slot], i)
+	}
+	if opt.Tx || len(opt.Watch) > 0 {
+		for _, key := range opt.Watch {
+			if _, ok := slots[Slot(key)]; !ok || len(slots) > 1 {
+				return nil, fmt.Errorf("%w, watch=%v", ErrCrossSlot, opt.Watch)
+			}
+		}
+		if len(slots) > 1 {
+			return nil, fmt.Errorf("%w, slots=%d", ErrCrossSlot, len(slots))
+		}
+	}
+
+	var wg sync.WaitGroup
+	var errs = make([]error, len(order))
+	for i, slot := range order {
+		var idx = slots[slot]
+		var group = make([]PipeOp, 0, len(idx))
+		for _, j := range idx {
+			group = append(group, ops[j])
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = runPipeline(this.rClient, watch, group, opt, results, idx)
+		}(i)
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		if !errors.Is(err, ErrTxConflict) {
+			log.Error("%v redis pipeline err:%v, count=%d", ClientClusterLogTag, err, len(ops))
+		}
+		return results, err
+	}
+	return results, nil
+}
+
+func seqIdx(n int) []int {
+	var idx = make([]int, n)
+	for i := range idx {
+		idx[i] = i
+	}
+	return idx
+}
@@ -0,0 +1,24 @@
+package client
+
+import "testing"
+
+func TestSlot(t *testing.T) {
+	for _, c := range []struct {
+		key  string
+		slot int
+	}{
+		{"123456789", 0x31C3},
+		{"foo", 12182},
+		{"{user1000}.following", Slot("user1000")},
+		{"{user1000}.followers", Slot("user1000")},
+		{"foo{{bar}}zap", Slot("{bar")},
+	} {
+		if slot := Slot(c.key); slot != c.slot {
+			t.Errorf("Slot(%q)=%d, want %d", c.key, slot, c.slot)
+		}
+	}
+	// 空tag时用整个key
+	if Slot("foo{}{bar}") == Slot("bar") {
+		t.Errorf("empty hash tag should hash whole key")
+	}
+}
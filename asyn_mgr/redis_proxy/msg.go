@@ -1,7 +1,9 @@
 package redis_proxy
 
 import (
+	"fmt"
 	"px/shared/asyn_mgr/asyn_msg"
+	"px/shared/asyn_mgr/redis_proxy/client"
 	"time"
 )
 
@@ -84,6 +86,299 @@ type (
 	}
 )
 
+// 计数器按redis整数存储，不经过RedisData编码，不能用ReqGet读取
+type (
+	ReqIncrBy struct {
+		ReqBase
+		Key   string
+		Delta int64
+	}
+	RespIncrBy struct {
+		RespBase
+		Ret int64
+		Err string
+	}
+	ReqDecrBy struct {
+		ReqBase
+		Key   string
+		Delta int64
+	}
+	RespDecrBy struct {
+		RespBase
+		Ret int64
+		Err string
+	}
+	ReqHIncrBy struct {
+		ReqBase
+		Key1  string
+		Key2  string
+		Delta int64
+	}
+	RespHIncrBy struct {
+		RespBase
+		Ret int64
+		Err string
+	}
+)
+
+type (
+	// ReqSetNX 过期时间为ReqBase.Ttl
+	ReqSetNX struct {
+		ReqBase
+		Key   string
+		Value any
+	}
+	RespSetNX struct {
+		RespBase
+		Ok  bool
+		Err string
+	}
+	// ReqSetArgs 过期时间为ReqBase.Ttl，KeepTtl时保留原过期时间
+	ReqSetArgs struct {
+		ReqBase
+		Key     string
+		Value   any
+		Mode    client.SetMode
+		KeepTtl bool
+	}
+	RespSetArgs struct {
+		RespBase
+		Ok  bool // NX、XX条件不满足时为false
+		Err string
+	}
+	ReqExists struct {
+		ReqBase
+		Keys []string
+	}
+	RespExists struct {
+		RespBase
+		Ret int64 // 存在的key数量
+		Err string
+	}
+	ReqMGet struct {
+		ReqBase
+		Keys []string
+	}
+	RespMGet struct {
+		RespBase
+		Ret map[string]any // 不存在的key不在结果中
+		Err string
+	}
+	ReqMSet struct {
+		ReqBase
+		Values map[string]any
+	}
+	RespMSet struct {
+		RespBase
+		Err string
+	}
+)
+
+type (
+	ReqHGetAll struct {
+		ReqBase
+		Key string
+	}
+	RespHGetAll struct {
+		RespBase
+		Ret map[string]any
+		Err string
+	}
+	ReqHMSet struct {
+		ReqBase
+		Key    string
+		Values map[string]any
+	}
+	RespHMSet struct {
+		RespBase
+		Err string
+	}
+)
+
+type (
+	ReqLPush struct {
+		ReqBase
+		Key    string
+		Values []any
+	}
+	RespLPush struct {
+		RespBase
+		Ret int64 // 列表长度
+		Err string
+	}
+	ReqRPop struct {
+		ReqBase
+		Key string
+	}
+	RespRPop struct {
+		RespBase
+		Ret   any
+		Empty bool // 列表为空
+		Err   string
+	}
+	ReqLRange struct {
+		ReqBase
+		Key   string
+		Start int64
+		Stop  int64
+	}
+	RespLRange struct {
+		RespBase
+		Ret []any
+		Err string
+	}
+)
+
+// 集合成员按原始字符串存储
+type (
+	ReqSAdd struct {
+		ReqBase
+		Key     string
+		Members []string
+	}
+	RespSAdd struct {
+		RespBase
+		Ret int64 // 新加入的成员数
+		Err string
+	}
+	ReqSRem struct {
+		ReqBase
+		Key     string
+		Members []string
+	}
+	RespSRem struct {
+		RespBase
+		Ret int64 // 移除的成员数
+		Err string
+	}
+	ReqSMembers struct {
+		ReqBase
+		Key string
+	}
+	RespSMembers struct {
+		RespBase
+		Ret []string
+		Err string
+	}
+)
+
+type (
+	// PipeOp ReqPipeline中的一个命令，用PipeSet等创建
+	PipeOp struct {
+		Cmd    client.PipeCmd
+		Key    string
+		Field  string
+		Fields []string
+		Value  any
+		Delta  int64
+		Ttl    time.Duration
+	}
+	// PipeResult Get、HGet为解码后的值，HGetAll为map[string]any，IncrBy、HIncrBy为int64
+	PipeResult struct {
+		Ret any
+		Nil bool // key或field不存在
+		Err string
+	}
+	// ReqPipeline 一次往返按顺序执行Ops，集群中按slot拆分
+	// Tx时以MULTI/EXEC执行；Watch非空时总是使用事务，WATCH之后检查Expect，
+	// 不一致或EXEC前被修改时不执行，RespPipeline.Conflict为true
+	// 集群中事务的所有key必须在同一个slot，用{hash tag}保证
+	ReqPipeline struct {
+		ReqBase
+		Ops    []PipeOp
+		Tx     bool
+		Watch  []string
+		Expect map[string]any // Watch的key的期望值，nil表示不存在，计数器用int64
+	}
+	RespPipeline struct {
+		RespBase
+		Results  []PipeResult // 与Ops一一对应
+		Conflict bool
+		Err      string
+	}
+)
+
+type (
+	// ReqLock 获取分布式锁，Ttl为锁的过期时间，为0时使用DefaultLockTtl
+	// 锁被占用时每LockRetryInterval重试直到Wait，Wait为0只尝试一次，请求的超时应大于Wait
+	// 持有期间自动续期，直到ReqUnlock或模块关闭
+	ReqLock struct {
+		ReqBase
+		Name string
+		Wait time.Duration
+
+		owner    string
+		deadline time.Time
+	}
+	// RespLock 写入受锁保护的数据时带上Token，存储端拒绝比已写入的更小的Token
+	RespLock struct {
+		RespBase
+		Ok    bool
+		Name  string
+		Owner string // 释放时使用
+		Token int64
+		Err   string // 等待超时为ErrLockTimeout
+	}
+	ReqUnlock struct {
+		ReqBase
+		Name  string
+		Owner string
+	}
+	// RespUnlock 锁已过期或被其他owner持有时Released为false
+	RespUnlock struct {
+		RespBase
+		Released bool
+		Err      string
+	}
+)
+
+// CloneReq 重试时重新生成owner并重新计算等待时间
+func (this *ReqLock) CloneReq() asyn_msg.ReqInf {
+	var c = *this
+	c.owner, c.deadline = "", time.Time{}
+	return &c
+}
+
+func PipeSet(key string, value any, ttl time.Duration) PipeOp {
+	return PipeOp{Cmd: client.PipeCmdSet, Key: key, Value: value, Ttl: ttl}
+}
+
+func PipeGet(key string) PipeOp {
+	return PipeOp{Cmd: client.PipeCmdGet, Key: key}
+}
+
+func PipeDel(key string) PipeOp {
+	return PipeOp{Cmd: client.PipeCmdDel, Key: key}
+}
+
+func PipeExpire(key string, ttl time.Duration) PipeOp {
+	return PipeOp{Cmd: client.PipeCmdExpire, Key: key, Ttl: ttl}
+}
+
+func PipeIncrBy(key string, delta int64) PipeOp {
+	return PipeOp{Cmd: client.PipeCmdIncrBy, Key: key, Delta: delta}
+}
+
+func PipeHSet(key string, field string, value any) PipeOp {
+	return PipeOp{Cmd: client.PipeCmdHSet, Key: key, Field: field, Value: value}
+}
+
+func PipeHGet(key string, field string) PipeOp {
+	return PipeOp{Cmd: client.PipeCmdHGet, Key: key, Field: field}
+}
+
+func PipeHDel(key string, fields ...string) PipeOp {
+	return PipeOp{Cmd: client.PipeCmdHDel, Key: key, Fields: fields}
+}
+
+func PipeHGetAll(key string) PipeOp {
+	return PipeOp{Cmd: client.PipeCmdHGetAll, Key: key}
+}
+
+func PipeHIncrBy(key string, field string, delta int64) PipeOp {
+	return PipeOp{Cmd: client.PipeCmdHIncrBy, Key: key, Field: field, Delta: delta}
+}
+
 func (this *ReqBase) GetModuleId() asyn_msg.AsynModuleId {
 	return asyn_msg.RedisModuleId
 }
@@ -91,3 +386,24 @@ func (this *ReqBase) GetModuleId() asyn_msg.AsynModuleId {
 func (this *RespBase) GetModuleId() asyn_msg.AsynModuleId {
 	return asyn_msg.RedisModuleId
 }
+
+// CoalesceKey 用于asyn_mgr.EnableCoalesce合并相同的读请求
+func (this *ReqGet) CoalesceKey() string {
+	return this.Key
+}
+
+func (this *ReqHGet) CoalesceKey() string {
+	return this.Key1 + "\x00" + this.Key2
+}
+
+func (this *ReqHGetAll) CoalesceKey() string {
+	return this.Key
+}
+
+func (this *ReqLRange) CoalesceKey() string {
+	return fmt.Sprintf("%s\x00%d\x00%d", this.Key, this.Start, this.Stop)
+}
+
+func (this *ReqSMembers) CoalesceKey() string {
+	return this.Key
+}
@@ -0,0 +1,175 @@
+package redis_proxy
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"gitlab.sunborngame.com/base/log"
+	"px/shared/asyn_mgr/asyn_msg"
+	"time"
+)
+
+const (
+	DefaultLockTtl    = 10 * time.Second
+	LockRetryInterval = 50 * time.Millisecond
+)
+
+var ErrLockTimeout = errors.New("redis lock wait timeout")
+
+// 持有中的锁，每ttl/3续期一次
+type lockHold struct {
+	name  string
+	owner string
+	ttl   time.Duration
+	timer *time.Timer
+}
+
+func newLockOwner() string {
+	var buf = make([]byte, 16)
+	_, _ = rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+func (this *RedisProxyMgr) handleLock(req *ReqLock) {
+	var ttl = req.Ttl
+	if ttl <= 0 {
+		ttl = DefaultLockTtl
+	}
+	if req.owner == "" {
+		req.owner = newLockOwner()
+		req.deadline = time.Now().Add(req.Wait)
+	}
+
+	var resp = &RespLock{Name: req.Name}
+	resp.SetFcId(req.GetFcId())
+	token, err := this.client.LockAcquire(req.Name, req.owner, ttl)
+	if err != nil {
+		log.Error("%s lock failed, Name: %s, error: %v", LogTag, req.Name, err)
+		resp.Err = err.Error()
+		this.RespChan.Put(resp)
+		return
+	}
+	if token == 0 {
+		var left = time.Until(req.deadline)
+		if left <= 0 {
+			resp.Err = ErrLockTimeout.Error()
+			this.RespChan.Put(resp)
+			return
+		}
+		if this.callerGone(req) {
+			return
+		}
+		// 不占用worker等待，重新放回请求队列，保留fcId和回调
+		if left > LockRetryInterval {
+			left = LockRetryInterval
+		}
+		time.AfterFunc(left, func() {
+			select {
+			case <-this.Done():
+			default:
+				this.Requeue(req, nil)
+			}
+		})
+		return
+	}
+
+	// 调用方是否还在等待由主逻辑收到响应时判断，见HandleResp
+	this.holdLock(req.Name, req.owner, ttl)
+	resp.Ok = true
+	resp.Owner = req.owner
+	resp.Token = token
+	this.RespChan.Put(resp)
+}
+
+// HandleResp 获得锁的响应到达主逻辑时调用方已超时，拿不到owner，停止续期并释放锁
+// 与超时回调都在主逻辑中执行，这里判断后不会再有超时
+func (this *RedisProxyMgr) HandleResp(resp asyn_msg.RespInf) asyn_msg.AsynCBPtr {
+	if lock, ok := resp.(*RespLock); ok && lock.Ok {
+		if _, ok = this.GetCallBack(lock.GetFcId()); !ok {
+			log.Warning("%s lock acquired after caller timeout, release, Name: %s", LogTag, lock.Name)
+			this.dropHeld(lock.Owner)
+			var name = lock.Name
+			this.SendReq(&ReqUnlock{Name: name, Owner: lock.Owner}, func(resp asyn_msg.RespInf) asyn_msg.AsynCBPtr {
+				if unlock, ok := resp.(*RespUnlock); !ok || !unlock.Released {
+					log.Warning("%s release lock after caller timeout failed, Name: %s, resp: %v", LogTag, name, resp)
+				}
+				return 0
+			})
+		}
+	}
+	return this.AsynBase.HandleResp(resp)
+}
+
+// 等待期间请求已超时回调，不再重试
+func (this *RedisProxyMgr) callerGone(req asyn_msg.ReqInf) bool {
+	if req.GetTimeout() <= 0 {
+		return false
+	}
+	_, ok := this.GetCallBack(req.GetFcId())
+	return !ok
+}
+
+func (this *RedisProxyMgr) handleUnlock(req *ReqUnlock) {
+	var resp = &RespUnlock{}
+	resp.SetFcId(req.GetFcId())
+	defer this.RespChan.Put(resp)
+
+	this.dropHeld(req.Owner)
+	released, err := this.client.LockRelease(req.Name, req.Owner)
+	if err != nil {
+		log.Error("%s unlock failed, Name: %s, error: %v", LogTag, req.Name, err)
+		resp.Err = err.Error()
+	}
+	resp.Released = released
+}
+
+func (this *RedisProxyMgr) holdLock(name string, owner string, ttl time.Duration) {
+	var hold = &lockHold{name: name, owner: owner, ttl: ttl}
+	this.heldLock.Lock()
+	defer this.heldLock.Unlock()
+	if this.held == nil {
+		this.held = make(map[string]*lockHold)
+	}
+	this.held[owner] = hold
+	hold.timer = time.AfterFunc(ttl/3, func() { this.renewLock(hold) })
+}
+
+func (this *RedisProxyMgr) renewLock(hold *lockHold) {
+	ok, err := this.client.LockRenew(hold.name, hold.owner, hold.ttl)
+
+	this.heldLock.Lock()
+	defer this.heldLock.Unlock()
+	if this.held[hold.owner] != hold {
+		// 续期期间已释放
+		return
+	}
+	if err == nil && !ok {
+		log.Warning("%s lock lost, Name: %s, owner: %s", LogTag, hold.name, hold.owner)
+		delete(this.held, hold.owner)
+		return
+	}
+	// 网络错误时继续续期，锁过期前恢复仍然有效
+	hold.timer = time.AfterFunc(hold.ttl/3, func() { this.renewLock(hold) })
+}
+
+func (this *RedisProxyMgr) dropHeld(owner string) {
+	this.heldLock.Lock()
+	defer this.heldLock.Unlock()
+	if hold, ok := this.held[owner]; ok {
+		hold.timer.Stop()
+		delete(this.held, owner)
+	}
+}
+
+// 模块关闭时释放持有的锁，不等待过期
+func (this *RedisProxyMgr) releaseHeld() {
+	this.heldLock.Lock()
+	var holds = this.held
+	this.held = nil
+	this.heldLock.Unlock()
+
+	for _, hold := range holds {
+		hold.timer.Stop()
+		_, _ = this.client.LockRelease(hold.name, hold.owner)
+	}
+}
@@ -2,6 +2,7 @@ package redis_proxy
 
 import (
 	"encoding/base64"
+	"errors"
 	"flag"
 	"fmt"
 	"gitlab.sunborngame.com/base/log"
@@ -12,6 +13,8 @@ import (
 	"px/shared/asyn_mgr/redis_proxy/redis_inf"
 	"px/utils"
 	"reflect"
+	"strconv"
+	"sync"
 )
 
 var (
@@ -20,11 +23,17 @@ var (
 
 const (
 	LogTag = "[redis_proxy]"
+
+	DefaultWorkers = 8
 )
 
 type RedisProxyMgr struct {
 	*asyn_msg.AsynBase
-	client client.ClientInf
+	client  client.ClientInf
+	workers *asyn_msg.WorkerPool // 相同key的请求按顺序执行
+
+	heldLock sync.Mutex
+	held     map[string]*lockHold // owner -> 持有中的锁
 }
 
 func CreateRedisProxy() *RedisProxyMgr {
@@ -39,6 +48,7 @@ func CreateRedisProxy() *RedisProxyMgr {
 	var redisProxyMgr = &RedisProxyMgr{
 		AsynBase: asyn_msg.NewAsynBase(),
 	}
+	redisProxyMgr.SetWorkers(DefaultWorkers)
 	if len(addrs) == 1 {
 		redisProxyMgr.client = client.NewClient(addrs[0])
 	} else {
@@ -56,16 +66,25 @@ func (this *RedisProxyMgr) GetModuleId() asyn_msg.AsynModuleId {
 	return asyn_msg.RedisModuleId
 }
 
-func (this *RedisProxyMgr) ReqLen() int {
-	return len(this.CallBacks)
-}
-
 func (this *RedisProxyMgr) Close() {
+	this.Stop()
+	this.releaseHeld()
 	this.client.Close()
 }
 
+// SetWorkers Init之前设置并发处理请求的worker数
+func (this *RedisProxyMgr) SetWorkers(n int) {
+	this.workers = asyn_msg.NewWorkerPoolKeys(this.AsynBase, n, orderKeys, this.handleReq)
+}
+
+// ReqLen 包括已分发到worker还未执行的请求
+func (this *RedisProxyMgr) ReqLen() int {
+	return this.AsynBase.ReqLen() + this.workers.Queued()
+}
+
 func (this *RedisProxyMgr) Init() {
-	go this.loop()
+	this.workers.Start()
+	this.Go(this.loop)
 }
 
 func (this *RedisProxyMgr) loop() {
@@ -84,14 +103,15 @@ func (this *RedisProxyMgr) run() bool {
 		if !ok {
 			return false
 		}
-		this.handleReq(req)
+		this.workers.Dispatch(req)
+	case <-this.Done():
+		return false
 	}
 
 	return true
 }
 
 func (this *RedisProxyMgr) handleReq(req asyn_msg.ReqInf) {
-	log.Info("%s redis recv req:%v", LogTag, req)
 	switch msg := req.(type) {
 	case *ReqSet:
 		this.handleSet(msg)
@@ -107,11 +127,118 @@ func (this *RedisProxyMgr) handleReq(req asyn_msg.ReqInf) {
 		this.handleDel(msg)
 	case *ReqTtl:
 		this.handleTtl(msg)
+	case *ReqIncrBy:
+		this.handleIncrBy(msg)
+	case *ReqDecrBy:
+		this.handleDecrBy(msg)
+	case *ReqSetNX:
+		this.handleSetNX(msg)
+	case *ReqSetArgs:
+		this.handleSetArgs(msg)
+	case *ReqExists:
+		this.handleExists(msg)
+	case *ReqMGet:
+		this.handleMGet(msg)
+	case *ReqMSet:
+		this.handleMSet(msg)
+	case *ReqHGetAll:
+		this.handleHGetAll(msg)
+	case *ReqHMSet:
+		this.handleHMSet(msg)
+	case *ReqHIncrBy:
+		this.handleHIncrBy(msg)
+	case *ReqLPush:
+		this.handleLPush(msg)
+	case *ReqRPop:
+		this.handleRPop(msg)
+	case *ReqLRange:
+		this.handleLRange(msg)
+	case *ReqSAdd:
+		this.handleSAdd(msg)
+	case *ReqSRem:
+		this.handleSRem(msg)
+	case *ReqSMembers:
+		this.handleSMembers(msg)
+	case *ReqPipeline:
+		this.handlePipeline(msg)
+	case *ReqLock:
+		this.handleLock(msg)
+	case *ReqUnlock:
+		this.handleUnlock(msg)
 	default:
 		log.Error("reqMsg err %v", req)
 	}
 }
 
+// 同一个key的请求按顺序执行，多key请求排在每个key之前的请求之后
+func orderKeys(req asyn_msg.ReqInf) []string {
+	switch msg := req.(type) {
+	case *ReqSet:
+		return []string{msg.Key}
+	case *ReqGet:
+		return []string{msg.Key}
+	case *ReqHSet:
+		return []string{msg.Key1}
+	case *ReqHGet:
+		return []string{msg.Key1}
+	case *ReqHDel:
+		return []string{msg.Key1}
+	case *ReqDel:
+		return []string{msg.Key}
+	case *ReqTtl:
+		return []string{msg.Key}
+	case *ReqIncrBy:
+		return []string{msg.Key}
+	case *ReqDecrBy:
+		return []string{msg.Key}
+	case *ReqSetNX:
+		return []string{msg.Key}
+	case *ReqSetArgs:
+		return []string{msg.Key}
+	case *ReqExists:
+		return msg.Keys
+	case *ReqMGet:
+		return msg.Keys
+	case *ReqMSet:
+		var keys = make([]string, 0, len(msg.Values))
+		for key := range msg.Values {
+			keys = append(keys, key)
+		}
+		return keys
+	case *ReqHGetAll:
+		return []string{msg.Key}
+	case *ReqHMSet:
+		return []string{msg.Key}
+	case *ReqHIncrBy:
+		return []string{msg.Key1}
+	case *ReqLPush:
+		return []string{msg.Key}
+	case *ReqRPop:
+		return []string{msg.Key}
+	case *ReqLRange:
+		return []string{msg.Key}
+	case *ReqSAdd:
+		return []string{msg.Key}
+	case *ReqSRem:
+		return []string{msg.Key}
+	case *ReqSMembers:
+		return []string{msg.Key}
+	case *ReqPipeline:
+		// 包括Watch的key，检查期间其他请求不能修改
+		var keys = make([]string, 0, len(msg.Ops)+len(msg.Watch))
+		for _, op := range msg.Ops {
+			keys = append(keys, op.Key)
+		}
+		return append(keys, msg.Watch...)
+	case *ReqLock:
+		return []string{msg.Name}
+	case *ReqUnlock:
+		return []string{msg.Name}
+	default:
+		return nil
+	}
+}
+
 func (this *RedisProxyMgr) handleSet(req *ReqSet) {
 	var resp = &RespSet{}
 	resp.SetFcId(req.GetFcId())
@@ -208,6 +335,336 @@ func (this *RedisProxyMgr) handleDel(req *ReqDel) {
 	}
 }
 
+func (this *RedisProxyMgr) handleIncrBy(req *ReqIncrBy) {
+	var resp = &RespIncrBy{}
+	resp.SetFcId(req.GetFcId())
+	defer this.RespChan.Put(resp)
+
+	value, err := this.client.IncrBy(req.Key, req.Delta)
+	if err != nil {
+		log.Error("%s incrby failed, Key: %s, delta: %d, error: %v", LogTag, req.Key, req.Delta, err)
+		resp.Err = err.Error()
+	}
+	resp.Ret = value
+}
+
+func (this *RedisProxyMgr) handleDecrBy(req *ReqDecrBy) {
+	var resp = &RespDecrBy{}
+	resp.SetFcId(req.GetFcId())
+	defer this.RespChan.Put(resp)
+
+	value, err := this.client.DecrBy(req.Key, req.Delta)
+	if err != nil {
+		log.Error("%s decrby failed, Key: %s, delta: %d, error: %v", LogTag, req.Key, req.Delta, err)
+		resp.Err = err.Error()
+	}
+	resp.Ret = value
+}
+
+func (this *RedisProxyMgr) handleSetNX(req *ReqSetNX) {
+	var resp = &RespSetNX{}
+	resp.SetFcId(req.GetFcId())
+	defer this.RespChan.Put(resp)
+
+	ok, err := this.client.SetNX(req.Key, this.encode(req.Value), req.Ttl)
+	if err != nil {
+		log.Error("%s setnx failed, Key: %s, Value: %v, error: %v", LogTag, req.Key, req.Value, err)
+		resp.Err = err.Error()
+	}
+	resp.Ok = ok
+}
+
+func (this *RedisProxyMgr) handleSetArgs(req *ReqSetArgs) {
+	var resp = &RespSetArgs{}
+	resp.SetFcId(req.GetFcId())
+	defer this.RespChan.Put(resp)
+
+	ok, err := this.client.SetArgs(req.Key, this.encode(req.Value), client.SetOpt{
+		Mode:    req.Mode,
+		Ttl:     req.Ttl,
+		KeepTtl: req.KeepTtl,
+	})
+	if err != nil {
+		log.Error("%s set failed, Key: %s, Value: %v, mode: %s, error: %v", LogTag, req.Key, req.Value, req.Mode, err)
+		resp.Err = err.Error()
+	}
+	resp.Ok = ok
+}
+
+func (this *RedisProxyMgr) handleExists(req *ReqExists) {
+	var resp = &RespExists{}
+	resp.SetFcId(req.GetFcId())
+	defer this.RespChan.Put(resp)
+
+	n, err := this.client.Exists(req.Keys...)
+	if err != nil {
+		log.Error("%s exists failed, Keys: %v, error: %v", LogTag, req.Keys, err)
+		resp.Err = err.Error()
+	}
+	resp.Ret = n
+}
+
+func (this *RedisProxyMgr) handleMGet(req *ReqMGet) {
+	var resp = &RespMGet{}
+	resp.SetFcId(req.GetFcId())
+	defer this.RespChan.Put(resp)
+
+	values, err := this.client.MGet(req.Keys...)
+	if err != nil {
+		log.Error("%s mget failed, Keys: %v, error: %v", LogTag, req.Keys, err)
+		resp.Err = err.Error()
+		return
+	}
+	resp.Ret = this.decodeMap(values)
+}
+
+func (this *RedisProxyMgr) handleMSet(req *ReqMSet) {
+	var resp = &RespMSet{}
+	resp.SetFcId(req.GetFcId())
+	defer this.RespChan.Put(resp)
+
+	err := this.client.MSet(this.encodeMap(req.Values))
+	if err != nil {
+		log.Error("%s mset failed, count: %d, error: %v", LogTag, len(req.Values), err)
+		resp.Err = err.Error()
+	}
+}
+
+func (this *RedisProxyMgr) handleHGetAll(req *ReqHGetAll) {
+	var resp = &RespHGetAll{}
+	resp.SetFcId(req.GetFcId())
+	defer this.RespChan.Put(resp)
+
+	values, err := this.client.HGetAll(req.Key)
+	if err != nil {
+		log.Error("%s hgetall failed, Key: %s, error: %v", LogTag, req.Key, err)
+		resp.Err = err.Error()
+		return
+	}
+	resp.Ret = this.decodeMap(values)
+}
+
+func (this *RedisProxyMgr) handleHMSet(req *ReqHMSet) {
+	var resp = &RespHMSet{}
+	resp.SetFcId(req.GetFcId())
+	defer this.RespChan.Put(resp)
+
+	err := this.client.HMSet(req.Key, this.encodeMap(req.Values))
+	if err != nil {
+		log.Error("%s hmset failed, Key: %s, count: %d, error: %v", LogTag, req.Key, len(req.Values), err)
+		resp.Err = err.Error()
+	}
+}
+
+func (this *RedisProxyMgr) handleHIncrBy(req *ReqHIncrBy) {
+	var resp = &RespHIncrBy{}
+	resp.SetFcId(req.GetFcId())
+	defer this.RespChan.Put(resp)
+
+	value, err := this.client.HIncrBy(req.Key1, req.Key2, req.Delta)
+	if err != nil {
+		log.Error("%s hincrby failed, Key1: %s, Key2: %s, delta: %d, error: %v", LogTag, req.Key1, req.Key2, req.Delta, err)
+		resp.Err = err.Error()
+	}
+	resp.Ret = value
+}
+
+func (this *RedisProxyMgr) handleLPush(req *ReqLPush) {
+	var resp = &RespLPush{}
+	resp.SetFcId(req.GetFcId())
+	defer this.RespChan.Put(resp)
+
+	var values = make([]*redis_inf.RedisData, 0, len(req.Values))
+	for _, value := range req.Values {
+		values = append(values, this.encode(value))
+	}
+	n, err := this.client.LPush(req.Key, values...)
+	if err != nil {
+		log.Error("%s lpush failed, Key: %s, error: %v", LogTag, req.Key, err)
+		resp.Err = err.Error()
+	}
+	resp.Ret = n
+}
+
+func (this *RedisProxyMgr) handleRPop(req *ReqRPop) {
+	var resp = &RespRPop{}
+	resp.SetFcId(req.GetFcId())
+	defer this.RespChan.Put(resp)
+
+	value, err := this.client.RPop(req.Key)
+	if client.IsNil(err) {
+		resp.Empty = true
+		return
+	}
+	if err != nil {
+		log.Error("%s rpop failed, Key: %s, error: %v", LogTag, req.Key, err)
+		resp.Err = err.Error()
+		return
+	}
+	resp.Ret = this.decode(value)
+}
+
+func (this *RedisProxyMgr) handleLRange(req *ReqLRange) {
+	var resp = &RespLRange{}
+	resp.SetFcId(req.GetFcId())
+	defer this.RespChan.Put(resp)
+
+	values, err := this.client.LRange(req.Key, req.Start, req.Stop)
+	if err != nil {
+		log.Error("%s lrange failed, Key: %s, start: %d, stop: %d, error: %v", LogTag, req.Key, req.Start, req.Stop, err)
+		resp.Err = err.Error()
+		return
+	}
+	resp.Ret = make([]any, 0, len(values))
+	for _, value := range values {
+		resp.Ret = append(resp.Ret, this.decode(value))
+	}
+}
+
+func (this *RedisProxyMgr) handleSAdd(req *ReqSAdd) {
+	var resp = &RespSAdd{}
+	resp.SetFcId(req.GetFcId())
+	defer this.RespChan.Put(resp)
+
+	n, err := this.client.SAdd(req.Key, req.Members...)
+	if err != nil {
+		log.Error("%s sadd failed, Key: %s, members: %v, error: %v", LogTag, req.Key, req.Members, err)
+		resp.Err = err.Error()
+	}
+	resp.Ret = n
+}
+
+func (this *RedisProxyMgr) handleSRem(req *ReqSRem) {
+	var resp = &RespSRem{}
+	resp.SetFcId(req.GetFcId())
+	defer this.RespChan.Put(resp)
+
+	n, err := this.client.SRem(req.Key, req.Members...)
+	if err != nil {
+		log.Error("%s srem failed, Key: %s, members: %v, error: %v", LogTag, req.Key, req.Members, err)
+		resp.Err = err.Error()
+	}
+	resp.Ret = n
+}
+
+func (this *RedisProxyMgr) handleSMembers(req *ReqSMembers) {
+	var resp = &RespSMembers{}
+	resp.SetFcId(req.GetFcId())
+	defer this.RespChan.Put(resp)
+
+	members, err := this.client.SMembers(req.Key)
+	if err != nil {
+		log.Error("%s smembers failed, Key: %s, error: %v", LogTag, req.Key, err)
+		resp.Err = err.Error()
+	}
+	resp.Ret = members
+}
+
+func (this *RedisProxyMgr) handlePipeline(req *ReqPipeline) {
+	var resp = &RespPipeline{}
+	resp.SetFcId(req.GetFcId())
+	defer this.RespChan.Put(resp)
+
+	var ops = make([]client.PipeOp, 0, len(req.Ops))
+	for _, op := range req.Ops {
+		var clientOp = client.PipeOp{
+			Cmd:    op.Cmd,
+			Key:    op.Key,
+			Field:  op.Field,
+			Fields: op.Fields,
+			Delta:  op.Delta,
+			Ttl:    op.Ttl,
+		}
+		if op.Cmd == client.PipeCmdSet || op.Cmd == client.PipeCmdHSet {
+			clientOp.Value = this.encode(op.Value)
+		}
+		ops = append(ops, clientOp)
+	}
+	var opt = client.PipeOpt{Tx: req.Tx, Watch: req.Watch}
+	if len(req.Expect) > 0 {
+		opt.Check = func(get func(key string) (string, error)) error {
+			return this.checkExpect(req.Expect, get)
+		}
+	}
+
+	results, err := this.client.Pipeline(ops, opt)
+	if err != nil {
+		resp.Conflict = errors.Is(err, client.ErrTxConflict)
+		if !resp.Conflict {
+			log.Error("%s pipeline failed, count: %d, tx: %v, error: %v", LogTag, len(req.Ops), req.Tx, err)
+		}
+		resp.Err = err.Error()
+	}
+	if len(results) == 0 {
+		return
+	}
+	resp.Results = make([]PipeResult, len(results))
+	for i, result := range results {
+		var ret = &resp.Results[i]
+		if client.IsNil(result.Err) {
+			ret.Nil = true
+			continue
+		}
+		if result.Err != nil {
+			ret.Err = result.Err.Error()
+			continue
+		}
+		switch req.Ops[i].Cmd {
+		case client.PipeCmdGet, client.PipeCmdHGet:
+			ret.Ret = this.decode(result.Str)
+		case client.PipeCmdHGetAll:
+			ret.Ret = this.decodeMap(result.Map)
+		case client.PipeCmdIncrBy, client.PipeCmdHIncrBy:
+			ret.Ret = result.Int
+		}
+	}
+}
+
+// WATCH之后比较当前值，计数器按整数比较，其他按RedisData解码后比较
+func (this *RedisProxyMgr) checkExpect(expect map[string]any, get func(key string) (string, error)) error {
+	for key, want := range expect {
+		raw, err := get(key)
+		if client.IsNil(err) {
+			if want != nil {
+				return fmt.Errorf("%w, key=%s not exist", client.ErrTxConflict, key)
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if n, ok := want.(int64); ok {
+			if v, err := strconv.ParseInt(raw, 10, 64); err == nil {
+				if v != n {
+					return fmt.Errorf("%w, key=%s", client.ErrTxConflict, key)
+				}
+				continue
+			}
+		}
+		if !reflect.DeepEqual(this.decode(raw), want) {
+			return fmt.Errorf("%w, key=%s", client.ErrTxConflict, key)
+		}
+	}
+	return nil
+}
+
+func (this *RedisProxyMgr) encodeMap(values map[string]any) map[string]*redis_inf.RedisData {
+	var datas = make(map[string]*redis_inf.RedisData, len(values))
+	for key, value := range values {
+		datas[key] = this.encode(value)
+	}
+	return datas
+}
+
+// 解码失败的值为nil
+func (this *RedisProxyMgr) decodeMap(values map[string]string) map[string]any {
+	var ret = make(map[string]any, len(values))
+	for key, value := range values {
+		ret[key] = this.decode(value)
+	}
+	return ret
+}
+
 func (this *RedisProxyMgr) encode(value interface{}) *redis_inf.RedisData {
 	var tp = redis_inf.VTypeNone
 	var tpName string
@@ -262,7 +719,7 @@ func (this *RedisProxyMgr) decode(value string) any {
 			log.Error("%s base64 decode failed, err:%v", LogTag, err)
 			return nil
 		}
-		rs.Body = data
+		return data
 	case redis_inf.VTypeData:
 		rs.Body = redis_inf.CreateMsg(rs.Head.TpName)
 		err = rs.UnmarshalBinary([]byte(value))
@@ -0,0 +1,395 @@
+package redis_proxy
+
+import (
+	"github.com/redis/go-redis/v9"
+	"px/shared/asyn_mgr/asyn_msg"
+	"px/shared/asyn_mgr/redis_proxy/client"
+	"px/shared/asyn_mgr/redis_proxy/redis_inf"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 内存中的client，只实现测试用到的命令
+type fakeClient struct {
+	client.ClientInf
+	values map[string]string
+	lists  map[string][]string
+
+	lockMu sync.Mutex // 续期在timer中执行
+	locks  map[string]fakeLock
+	fences map[string]int64
+}
+
+func (this *fakeClient) Close() {
+}
+
+type fakeLock struct {
+	owner  string
+	expire time.Time
+}
+
+func (this *fakeClient) LockAcquire(name string, owner string, ttl time.Duration) (int64, error) {
+	this.lockMu.Lock()
+	defer this.lockMu.Unlock()
+	if this.locks == nil {
+		this.locks, this.fences = make(map[string]fakeLock), make(map[string]int64)
+	}
+	if lock, ok := this.locks[name]; ok && time.Now().Before(lock.expire) {
+		return 0, nil
+	}
+	this.locks[name] = fakeLock{owner: owner, expire: time.Now().Add(ttl)}
+	this.fences[name]++
+	return this.fences[name], nil
+}
+
+func (this *fakeClient) LockRenew(name string, owner string, ttl time.Duration) (bool, error) {
+	this.lockMu.Lock()
+	defer this.lockMu.Unlock()
+	if lock, ok := this.locks[name]; !ok || lock.owner != owner || time.Now().After(lock.expire) {
+		return false, nil
+	}
+	this.locks[name] = fakeLock{owner: owner, expire: time.Now().Add(ttl)}
+	return true, nil
+}
+
+func (this *fakeClient) LockRelease(name string, owner string) (bool, error) {
+	this.lockMu.Lock()
+	defer this.lockMu.Unlock()
+	if lock, ok := this.locks[name]; !ok || lock.owner != owner || time.Now().After(lock.expire) {
+		return false, nil
+	}
+	delete(this.locks, name)
+	return true, nil
+}
+
+func (this *fakeClient) MSet(values map[string]*redis_inf.RedisData) error {
+	for key, value := range values {
+		data, _ := value.MarshalBinary()
+		this.values[key] = string(data)
+	}
+	return nil
+}
+
+func (this *fakeClient) MGet(keys ...string) (map[string]string, error) {
+	var ret = make(map[string]string)
+	for _, key := range keys {
+		if value, ok := this.values[key]; ok {
+			ret[key] = value
+		}
+	}
+	return ret, nil
+}
+
+func (this *fakeClient) LPush(key string, values ...*redis_inf.RedisData) (int64, error) {
+	for _, value := range values {
+		data, _ := value.MarshalBinary()
+		this.lists[key] = append([]string{string(data)}, this.lists[key]...)
+	}
+	return int64(len(this.lists[key])), nil
+}
+
+func (this *fakeClient) RPop(key string) (string, error) {
+	var list = this.lists[key]
+	if len(list) == 0 {
+		return "", redis.Nil
+	}
+	this.lists[key] = list[:len(list)-1]
+	return list[len(list)-1], nil
+}
+
+func (this *fakeClient) LRange(key string, start, stop int64) ([]string, error) {
+	return this.lists[key][start : stop+1], nil
+}
+
+// 顺序执行，Watch时先检查，不模拟并发修改
+func (this *fakeClient) Pipeline(ops []client.PipeOp, opt client.PipeOpt) ([]client.PipeResult, error) {
+	if opt.Check != nil {
+		var get = func(key string) (string, error) {
+			if value, ok := this.values[key]; ok {
+				return value, nil
+			}
+			return "", redis.Nil
+		}
+		if err := opt.Check(get); err != nil {
+			return nil, err
+		}
+	}
+	var results = make([]client.PipeResult, len(ops))
+	for i, op := range ops {
+		switch op.Cmd {
+		case client.PipeCmdSet:
+			data, _ := op.Value.MarshalBinary()
+			this.values[op.Key] = string(data)
+		case client.PipeCmdGet:
+			value, ok := this.values[op.Key]
+			if !ok {
+				results[i].Err = redis.Nil
+			}
+			results[i].Str = value
+		case client.PipeCmdIncrBy:
+			var n, _ = strconv.ParseInt(this.values[op.Key], 10, 64)
+			n += op.Delta
+			this.values[op.Key] = strconv.FormatInt(n, 10)
+			results[i].Int = n
+		default:
+			results[i].Err = client.ErrUnknownPipe
+		}
+	}
+	return results, nil
+}
+
+func TestRedisCmdDecode(t *testing.T) {
+	var mgr = &RedisProxyMgr{
+		AsynBase: asyn_msg.NewAsynBase(),
+		client:   &fakeClient{values: make(map[string]string), lists: make(map[string][]string)},
+	}
+	defer mgr.Stop()
+	var call = func(req asyn_msg.ReqInf) asyn_msg.RespInf {
+		t.Helper()
+		mgr.handleReq(req)
+		select {
+		case resp := <-mgr.Resp():
+			return resp
+		case <-time.After(time.Second):
+			t.Fatalf("no resp of %T", req)
+			return nil
+		}
+	}
+
+	var data = &TestRedis{Key: 1, Value: "v", Value3: []byte{1, 2}}
+	call(&ReqMSet{Values: map[string]any{"s": "str", "i": int64(7), "b": []byte{3, 4}, "d": data}})
+	var mget = call(&ReqMGet{Keys: []string{"s", "i", "b", "d", "none"}}).(*RespMGet)
+	var want = map[string]any{"s": "str", "i": int64(7), "b": []byte{3, 4}, "d": data}
+	if mget.Err != "" || !reflect.DeepEqual(mget.Ret, want) {
+		t.Errorf("mget=%+v, want %v", mget, want)
+	}
+
+	call(&ReqLPush{Key: "l", Values: []any{int32(1), "two"}})
+	var lrange = call(&ReqLRange{Key: "l", Start: 0, Stop: 1}).(*RespLRange)
+	if !reflect.DeepEqual(lrange.Ret, []any{"two", int32(1)}) {
+		t.Errorf("lrange=%v", lrange.Ret)
+	}
+	for _, want := range []any{int32(1), "two"} {
+		if rpop := call(&ReqRPop{Key: "l"}).(*RespRPop); rpop.Empty || rpop.Ret != want {
+			t.Errorf("rpop=%+v, want %v", rpop, want)
+		}
+	}
+	if rpop := call(&ReqRPop{Key: "l"}).(*RespRPop); !rpop.Empty || rpop.Err != "" {
+		t.Errorf("rpop of empty list=%+v", rpop)
+	}
+}
+
+func TestRedisPipeline(t *testing.T) {
+	var mgr = &RedisProxyMgr{
+		AsynBase: asyn_msg.NewAsynBase(),
+		client:   &fakeClient{values: make(map[string]string), lists: make(map[string][]string)},
+	}
+	defer mgr.Stop()
+	var call = func(req *ReqPipeline) *RespPipeline {
+		t.Helper()
+		mgr.handleReq(req)
+		return (<-mgr.Resp()).(*RespPipeline)
+	}
+
+	var resp = call(&ReqPipeline{Ops: []PipeOp{
+		PipeSet("{u1}name", "tom", 0),
+		PipeIncrBy("{u1}ver", 1),
+		PipeGet("{u1}name"),
+		PipeGet("{u1}none"),
+		PipeHDel("{u1}h", "f"),
+	}})
+	// fake不支持HDel，单个命令失败不影响其他命令
+	var want = []PipeResult{{}, {Ret: int64(1)}, {Ret: "tom"}, {Nil: true}, {Err: client.ErrUnknownPipe.Error()}}
+	if resp.Err != "" || !reflect.DeepEqual(resp.Results, want) {
+		t.Errorf("results=%+v, want %+v", resp.Results, want)
+	}
+
+	// 版本号一致时执行，不一致时冲突且不执行
+	var save = func(ver int64) *RespPipeline {
+		return call(&ReqPipeline{
+			Ops:    []PipeOp{PipeSet("{u1}name", "jerry", 0), PipeIncrBy("{u1}ver", 1)},
+			Watch:  []string{"{u1}ver", "{u1}name"},
+			Expect: map[string]any{"{u1}ver": ver, "{u1}name": "tom"},
+		})
+	}
+	if resp = save(2); !resp.Conflict || resp.Results != nil {
+		t.Errorf("stale save resp=%+v, want conflict", resp)
+	}
+	if resp = save(1); resp.Conflict || resp.Err != "" || resp.Results[1].Ret != int64(2) {
+		t.Errorf("save resp=%+v", resp)
+	}
+	if resp = save(2); !resp.Conflict || !strings.Contains(resp.Err, "{u1}name") {
+		t.Errorf("changed name resp=%+v, want conflict", resp)
+	}
+}
+
+// pipeline阻塞到release，记录命令执行顺序
+type orderClient struct {
+	client.ClientInf
+	release chan struct{}
+
+	lock sync.Mutex
+	cmds []string
+}
+
+func (this *orderClient) Close() {
+}
+
+func (this *orderClient) add(cmd string) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.cmds = append(this.cmds, cmd)
+}
+
+func (this *orderClient) Pipeline(ops []client.PipeOp, opt client.PipeOpt) ([]client.PipeResult, error) {
+	<-this.release
+	this.add("pipeline")
+	return make([]client.PipeResult, len(ops)), nil
+}
+
+func (this *orderClient) Set(key string, value *redis_inf.RedisData, ttl time.Duration) error {
+	this.add("set " + key)
+	return nil
+}
+
+// pipeline之后发出的单个命令，key在任意op或Watch中时排在pipeline之后
+func TestRedisPipelineOrder(t *testing.T) {
+	var fake = &orderClient{release: make(chan struct{})}
+	var mgr = &RedisProxyMgr{
+		AsynBase: asyn_msg.NewAsynBase(),
+		client:   fake,
+	}
+	defer mgr.Stop()
+	mgr.SetWorkers(4)
+	mgr.workers.Start()
+	var recv = func(n int) {
+		t.Helper()
+		for i := 0; i < n; i++ {
+			select {
+			case <-mgr.Resp():
+			case <-time.After(time.Second):
+				t.Fatal("no resp")
+			}
+		}
+	}
+
+	mgr.workers.Dispatch(&ReqPipeline{
+		Ops:   []PipeOp{PipeIncrBy("{u1}ver", 1), PipeSet("{u1}name", "tom", 0)},
+		Watch: []string{"{u1}flag"},
+	})
+	for _, key := range []string{"{u1}name", "{u1}flag", "{u1}other"} {
+		mgr.workers.Dispatch(&ReqSet{Key: key, Value: 1})
+	}
+	recv(1)
+	close(fake.release)
+	recv(3)
+	// pipeline之后的两个命令在不同worker中执行，顺序不定
+	sort.Strings(fake.cmds[2:])
+	var want = []string{"set {u1}other", "pipeline", "set {u1}flag", "set {u1}name"}
+	if !reflect.DeepEqual(fake.cmds, want) {
+		t.Errorf("cmds=%v, want %v", fake.cmds, want)
+	}
+}
+
+func TestRedisLock(t *testing.T) {
+	var fake = &fakeClient{values: make(map[string]string), lists: make(map[string][]string)}
+	var mgr = &RedisProxyMgr{
+		AsynBase: asyn_msg.NewAsynBase(),
+		client:   fake,
+	}
+	mgr.SetWorkers(2)
+	mgr.Init()
+	var recv = func() asyn_msg.RespInf {
+		t.Helper()
+		select {
+		case resp := <-mgr.Resp():
+			return resp
+		case <-time.After(time.Second):
+			t.Fatal("no resp")
+			return nil
+		}
+	}
+	var lock = func(wait time.Duration) *RespLock {
+		t.Helper()
+		var req = &ReqLock{Name: "room", Wait: wait}
+		req.Ttl = 60 * time.Millisecond
+		mgr.SendReq(req, nil)
+		return recv().(*RespLock)
+	}
+
+	var a = lock(0)
+	if !a.Ok || a.Token != 1 || a.Owner == "" {
+		t.Fatalf("lock a=%+v", a)
+	}
+	// 超过ttl后仍由a持有
+	time.Sleep(150 * time.Millisecond)
+	if b := lock(20 * time.Millisecond); b.Ok || b.Err != ErrLockTimeout.Error() {
+		t.Errorf("lock b=%+v, want timeout", b)
+	}
+
+	// 等待中a释放后获得锁，token递增
+	var c = &ReqLock{Name: "room", Wait: time.Second}
+	c.Ttl = 60 * time.Millisecond
+	mgr.SendReq(c, nil)
+	time.Sleep(20 * time.Millisecond)
+	mgr.SendReq(&ReqUnlock{Name: "room", Owner: a.Owner}, nil)
+	if unlock := recv().(*RespUnlock); !unlock.Released {
+		t.Errorf("unlock a=%+v", unlock)
+	}
+	if resp := recv().(*RespLock); !resp.Ok || resp.Token != 2 {
+		t.Errorf("lock c=%+v, want token 2", resp)
+	}
+	// 只能释放自己持有的锁
+	mgr.SendReq(&ReqUnlock{Name: "room", Owner: a.Owner}, nil)
+	if unlock := recv().(*RespUnlock); unlock.Released || unlock.Err != "" {
+		t.Errorf("unlock a again=%+v", unlock)
+	}
+
+	// 重试的请求重新生成owner
+	var retry = &ReqLock{Name: "room", owner: a.Owner, deadline: time.Now()}
+	if clone := asyn_msg.CloneReq(retry).(*ReqLock); clone.Name != "room" || clone.owner != "" || !clone.deadline.IsZero() {
+		t.Errorf("clone=%+v, want owner and deadline reset", clone)
+	}
+
+	// 获得锁时调用方已超时，主逻辑收到响应时释放
+	var held = func() int {
+		mgr.heldLock.Lock()
+		defer mgr.heldLock.Unlock()
+		return len(mgr.held)
+	}
+	var timeout = &ReqLock{Name: "late"}
+	var called bool
+	mgr.SendReq(timeout, func(resp asyn_msg.RespInf) asyn_msg.AsynCBPtr {
+		called = true
+		return 0
+	})
+	var late = recv().(*RespLock)
+	if !late.Ok || held() != 2 {
+		t.Fatalf("lock late=%+v, held=%d", late, held())
+	}
+	mgr.TakeCallBack(timeout.GetFcId())
+	mgr.HandleResp(late)
+	if n := held(); n != 1 {
+		t.Errorf("held=%d after caller timeout, want 1", n)
+	}
+	if unlock := recv().(*RespUnlock); !unlock.Released {
+		t.Errorf("release late=%+v", unlock)
+	}
+	fake.lockMu.Lock()
+	_, ok := fake.locks["late"]
+	fake.lockMu.Unlock()
+	if ok || called {
+		t.Errorf("late lock not released, called=%v", called)
+	}
+
+	// 关闭时释放持有的锁
+	mgr.Close()
+	if len(fake.locks) != 0 {
+		t.Errorf("locks after close=%v", fake.locks)
+	}
+}
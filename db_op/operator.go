@@ -1,12 +1,10 @@
 package db_op
 
 import (
-	"fmt"
 	"px/proto/proto_db"
 	"px/shared/asyn_mgr"
 	"px/shared/asyn_mgr/asyn_msg"
 	"px/shared/asyn_mgr/dbclient"
-	"reflect"
 )
 
 func DoDbInsert(sql string, args []interface{}, cb func(string)) {
@@ -15,17 +13,15 @@ func DoDbInsert(sql string, args []interface{}, cb func(string)) {
 		Args: args,
 		Op:   proto_db.DB_OPERATOR_DB_OP_INSERT,
 	}
-	asyn_mgr.GetAsynMgr().SendReq(req, func(inf asyn_msg.RespInf) asyn_msg.AsynCBPtr {
-		resp, ok := inf.(*dbclient.RespDbQuery)
-		if !ok {
-			var err = fmt.Errorf("resp type error, resp=%v", inf)
+	asyn_mgr.SendCB(req, func(resp *dbclient.RespDbQuery, err error) asyn_msg.AsynCBPtr {
+		if err != nil {
 			cb(err.Error())
 			return 0
 		}
 
 		cb(resp.ErrMsg)
 
-		return asyn_msg.AsynCBPtr(reflect.ValueOf(cb).Pointer())
+		return asyn_msg.CBPtr(cb)
 	})
 }
 
@@ -35,17 +31,15 @@ func DoDbSelect(sql string, args []interface{}, cb func(string, []*proto_db.DBDa
 		Args: args,
 		Op:   proto_db.DB_OPERATOR_DB_OP_SELECT,
 	}
-	asyn_mgr.GetAsynMgr().SendReq(req, func(inf asyn_msg.RespInf) asyn_msg.AsynCBPtr {
-		resp, ok := inf.(*dbclient.RespDbQuery)
-		if !ok {
-			var err = fmt.Errorf("resp type error, resp=%v", inf)
+	asyn_mgr.SendCB(req, func(resp *dbclient.RespDbQuery, err error) asyn_msg.AsynCBPtr {
+		if err != nil {
 			cb(err.Error(), nil)
 			return 0
 		}
 
 		cb(resp.ErrMsg, resp.Data)
 
-		return asyn_msg.AsynCBPtr(reflect.ValueOf(cb).Pointer())
+		return asyn_msg.CBPtr(cb)
 	})
 }
 
@@ -55,17 +49,15 @@ func DoDbUpdate(sql string, args []interface{}, cb func(string)) {
 		Args: args,
 		Op:   proto_db.DB_OPERATOR_DB_OP_UPDATE,
 	}
-	asyn_mgr.GetAsynMgr().SendReq(req, func(inf asyn_msg.RespInf) asyn_msg.AsynCBPtr {
-		resp, ok := inf.(*dbclient.RespDbQuery)
-		if !ok {
-			var err = fmt.Errorf("resp type error, resp=%v", inf)
+	asyn_mgr.SendCB(req, func(resp *dbclient.RespDbQuery, err error) asyn_msg.AsynCBPtr {
+		if err != nil {
 			cb(err.Error())
 			return 0
 		}
 
 		cb(resp.ErrMsg)
 
-		return asyn_msg.AsynCBPtr(reflect.ValueOf(cb).Pointer())
+		return asyn_msg.CBPtr(cb)
 	})
 }
 
@@ -75,17 +67,15 @@ func DoDbDelete(sql string, args []interface{}, cb func(string)) {
 		Args: args,
 		Op:   proto_db.DB_OPERATOR_DB_OP_DELETE,
 	}
-	asyn_mgr.GetAsynMgr().SendReq(req, func(inf asyn_msg.RespInf) asyn_msg.AsynCBPtr {
-		resp, ok := inf.(*dbclient.RespDbQuery)
-		if !ok {
-			var err = fmt.Errorf("resp type error, resp=%v", inf)
+	asyn_mgr.SendCB(req, func(resp *dbclient.RespDbQuery, err error) asyn_msg.AsynCBPtr {
+		if err != nil {
 			cb(err.Error())
 			return 0
 		}
 
 		cb(resp.ErrMsg)
 
-		return asyn_msg.AsynCBPtr(reflect.ValueOf(cb).Pointer())
+		return asyn_msg.CBPtr(cb)
 	})
 }
 
@@ -95,17 +85,15 @@ func DoDbReplace(sql string, args []interface{}, cb func(string)) {
 		Args: args,
 		Op:   proto_db.DB_OPERATOR_DB_OP_REPLACE,
 	}
-	asyn_mgr.GetAsynMgr().SendReq(req, func(inf asyn_msg.RespInf) asyn_msg.AsynCBPtr {
-		resp, ok := inf.(*dbclient.RespDbQuery)
-		if !ok {
-			var err = fmt.Errorf("resp type error, resp=%v", inf)
+	asyn_mgr.SendCB(req, func(resp *dbclient.RespDbQuery, err error) asyn_msg.AsynCBPtr {
+		if err != nil {
 			cb(err.Error())
 			return 0
 		}
 
 		cb(resp.ErrMsg)
 
-		return asyn_msg.AsynCBPtr(reflect.ValueOf(cb).Pointer())
+		return asyn_msg.CBPtr(cb)
 	})
 }
 
@@ -115,17 +103,15 @@ func DoDbQuery(sql string, args []interface{}, op proto_db.DB_OPERATOR, cb func(
 		Args: args,
 		Op:   op,
 	}
-	asyn_mgr.GetAsynMgr().SendReq(req, func(inf asyn_msg.RespInf) asyn_msg.AsynCBPtr {
-		resp, ok := inf.(*dbclient.RespDbQuery)
-		if !ok {
-			var err = fmt.Errorf("resp type error, resp=%v", inf)
+	asyn_mgr.SendCB(req, func(resp *dbclient.RespDbQuery, err error) asyn_msg.AsynCBPtr {
+		if err != nil {
 			cb(err.Error(), nil)
 			return 0
 		}
 
 		cb(resp.ErrMsg, resp.Data)
 
-		return asyn_msg.AsynCBPtr(reflect.ValueOf(cb).Pointer())
+		return asyn_msg.CBPtr(cb)
 	})
 }
 
@@ -140,17 +126,15 @@ func DoDbMultiSelect(sqlMultiQuery *SqlMultiQuery, cb func(string, [][]*proto_db
 			Op:   proto_db.DB_OPERATOR_DB_OP_SELECT,
 		})
 	}
-	asyn_mgr.GetAsynMgr().SendReq(req, func(inf asyn_msg.RespInf) asyn_msg.AsynCBPtr {
-		resp, ok := inf.(*dbclient.RespDbMulQuery)
-		if !ok {
-			var err = fmt.Errorf("resp type error, resp=%v", inf)
+	asyn_mgr.SendCB(req, func(resp *dbclient.RespDbMulQuery, err error) asyn_msg.AsynCBPtr {
+		if err != nil {
 			cb(err.Error(), nil)
 			return 0
 		}
 
 		cb(resp.ErrMsg, resp.Data)
 
-		return asyn_msg.AsynCBPtr(reflect.ValueOf(cb).Pointer())
+		return asyn_msg.CBPtr(cb)
 	})
 }
 
@@ -165,10 +149,8 @@ func DoDbBlockQuery(sql string, args []interface{}, op proto_db.DB_OPERATOR) (er
 	var ch = make(chan asyn_msg.RespInf)
 	req.SetBlockCh(ch)
 	asyn_mgr.GetAsynMgr().SendReq(req, nil)
-	respInf := <-ch
-	resp, ok := respInf.(*dbclient.RespDbQuery)
-	if !ok {
-		var err = fmt.Errorf("resp type error, resp=%v", respInf)
+	resp, err := asyn_mgr.CastResp[*dbclient.RespDbQuery](<-ch)
+	if err != nil {
 		errMsg = err.Error()
 		return
 	}
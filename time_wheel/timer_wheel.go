@@ -9,6 +9,8 @@ import (
 	"reflect"
 	"runtime"
 	"runtime/debug"
+	"sort"
+	"sync"
 	"time"
 
 	"gitlab.sunborngame.com/base/log"
@@ -39,6 +41,20 @@ type TimeWheelS struct {
 	NotifyChannel *chanx.UnboundedChan[*TaskS]
 
 	lastTickTime int64
+
+	pendingLock sync.Mutex
+	pending     map[int64]TimerInfo // 时间轮中的任务，用于调试查看
+}
+
+// TimerInfo 等待执行的定时器
+type TimerInfo struct {
+	TimerID  int64
+	Callback string
+	ExeTime  int64 // 下次执行时间(ms)
+	Delay    time.Duration
+	Repeated bool
+
+	cb CallbackFuncS
 }
 
 type TaskS struct {
@@ -112,6 +128,7 @@ func NewTimeWheelS(timeStep time.Duration) *TimeWheelS {
 		taskChannel:   chanx.NewUnboundedChan[*ChanTask](TaskChanCap),
 		stopChannel:   make(chan bool, 1024),
 		NotifyChannel: chanx.NewUnboundedChan[*TaskS](TaskChanCap),
+		pending:       make(map[int64]TimerInfo),
 	}
 	tw.initTasks()
 
@@ -240,6 +257,7 @@ func (tw *TimeWheelS) scanAndNotify(l *utils.SortedSet[int64, *TaskS], now int64
 		}
 		tw.NotifyChannel.Put(taskS)
 		tw.tasks.Remove(taskS)
+		tw.removePending(taskS.TimerID)
 		if taskS.repeated {
 			taskS.updateNextDelay()
 			tw.addTaskS(taskS)
@@ -256,11 +274,59 @@ func (tw *TimeWheelS) addTaskS(taskInf any) {
 
 	taskS.exeTime = taskS.delay.Milliseconds() + utils.NowUnixMilli()
 	tw.tasks.Push(taskS)
+
+	tw.pendingLock.Lock()
+	tw.pending[taskS.TimerID] = TimerInfo{
+		TimerID:  taskS.TimerID,
+		ExeTime:  taskS.exeTime,
+		Delay:    taskS.delay,
+		Repeated: taskS.repeated,
+		cb:       taskS.defaultCb,
+	}
+	tw.pendingLock.Unlock()
 }
 
 func (tw *TimeWheelS) removeTaskS(timerID int64) {
 	log.Debug("removeTaskS remove timer task %d", timerID)
 	tw.tasks.RemoveByKey(timerID)
+	tw.removePending(timerID)
+}
+
+func (tw *TimeWheelS) removePending(timerID int64) {
+	tw.pendingLock.Lock()
+	delete(tw.pending, timerID)
+	tw.pendingLock.Unlock()
+}
+
+// PendingTimers 等待执行的定时器，最早执行的在前，limit<=0时返回全部，可在任意goroutine调用
+func (tw *TimeWheelS) PendingTimers(limit int) []TimerInfo {
+	tw.pendingLock.Lock()
+	var timers = make([]TimerInfo, 0, len(tw.pending))
+	for _, timer := range tw.pending {
+		timers = append(timers, timer)
+	}
+	tw.pendingLock.Unlock()
+
+	sort.Slice(timers, func(i, j int) bool {
+		if timers[i].ExeTime != timers[j].ExeTime {
+			return timers[i].ExeTime < timers[j].ExeTime
+		}
+		return timers[i].TimerID < timers[j].TimerID
+	})
+	if limit > 0 && len(timers) > limit {
+		timers = timers[:limit]
+	}
+	for i := range timers {
+		timers[i].Callback = runtime.FuncForPC(reflect.ValueOf(timers[i].cb).Pointer()).Name()
+	}
+	return timers
+}
+
+// PendingLen 等待执行的定时器数量
+func (tw *TimeWheelS) PendingLen() int {
+	tw.pendingLock.Lock()
+	defer tw.pendingLock.Unlock()
+	return len(tw.pending)
 }
 
 // TriggerTimerCb 主线程执行